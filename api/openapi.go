@@ -2,14 +2,89 @@ package api
 
 import (
 	_ "embed"
+	"encoding/json"
+	"io/fs"
 	"net/http"
+	"strings"
+	"sync"
 
+	"github.com/getkin/kin-openapi/openapi3"
 	"github.com/gin-gonic/gin"
+	swaggerFiles "github.com/swaggo/files/v2"
 )
 
 //go:embed employee-wl.openapi.yaml
 var openapiSpec []byte
 
+// swaggerInitializer configures the Swagger UI to show the embedded specification,
+// it replaces the initializer of the distribution pointing to the petstore example
+//
+//go:embed swagger-initializer.js
+var swaggerInitializer []byte
+
+// openapiJson renders the specification as JSON once
+var openapiJson = sync.OnceValues(func() ([]byte, error) {
+	document, err := openapi3.NewLoader().LoadFromData(openapiSpec)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(document)
+})
+
 func HandleOpenApi(ctx *gin.Context) {
 	ctx.Data(http.StatusOK, "application/yaml", openapiSpec)
 }
+
+// OpenApiSpec provides the embedded OpenAPI specification of the service
+func OpenApiSpec() []byte {
+	return openapiSpec
+}
+
+// OpenApiJson provides the embedded specification rendered as JSON for tools not reading YAML,
+// it is served by the service with its problem responses in case of a failure
+func OpenApiJson() ([]byte, error) {
+	return openapiJson()
+}
+
+// HandleOpenApiUi serves the Swagger UI viewer of the specification, it has to be routed
+// with the filepath wildcard parameter. All assets are embedded, so the viewer does not
+// depend on any CDN.
+func HandleOpenApiUi(ctx *gin.Context) {
+	file := strings.TrimPrefix(ctx.Param("filepath"), "/")
+	switch file {
+	case "":
+		file = "index.html"
+	case "swagger-initializer.js":
+		ctx.Data(http.StatusOK, "text/javascript; charset=utf-8", swaggerInitializer)
+		return
+	}
+
+	content, err := fs.ReadFile(swaggerFiles.FS, file)
+	if err != nil {
+		ctx.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	ctx.Data(http.StatusOK, contentType(file), content)
+}
+
+// HandleOpenApiUiRedirect redirects to the viewer directory, so that the relative links of its page resolve.
+// The location is kept relative, http.Redirect would resolve it against the path seen behind a path prefix.
+func HandleOpenApiUiRedirect(ctx *gin.Context) {
+	ctx.Header("Location", "ui/")
+	ctx.Status(http.StatusMovedPermanently)
+}
+
+func contentType(file string) string {
+	switch {
+	case strings.HasSuffix(file, ".html"):
+		return "text/html; charset=utf-8"
+	case strings.HasSuffix(file, ".css"):
+		return "text/css; charset=utf-8"
+	case strings.HasSuffix(file, ".js"):
+		return "text/javascript; charset=utf-8"
+	case strings.HasSuffix(file, ".png"):
+		return "image/png"
+	default:
+		return "application/octet-stream"
+	}
+}
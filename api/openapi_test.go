@@ -0,0 +1,83 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+)
+
+type OpenApiSuite struct {
+	suite.Suite
+	router *gin.Engine
+}
+
+func TestOpenApiSuite(t *testing.T) {
+	suite.Run(t, new(OpenApiSuite))
+}
+
+// SetupTest routes the handlers like the service does
+func (suite *OpenApiSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.router = gin.New()
+	suite.router.GET("/openapi", HandleOpenApi)
+	suite.router.GET("/openapi/ui", HandleOpenApiUiRedirect)
+	suite.router.GET("/openapi/ui/*filepath", HandleOpenApiUi)
+}
+
+func (suite *OpenApiSuite) get(path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	suite.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, path, nil))
+	return recorder
+}
+
+func (suite *OpenApiSuite) Test_Yaml_Served() {
+	recorder := suite.get("/openapi")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("application/yaml", recorder.Header().Get("Content-Type"))
+	suite.Equal(openapiSpec, recorder.Body.Bytes())
+}
+
+func (suite *OpenApiSuite) Test_Ui_RedirectedToDirectory() {
+	recorder := suite.get("/openapi/ui")
+	suite.Equal(http.StatusMovedPermanently, recorder.Code)
+	suite.Equal("ui/", recorder.Header().Get("Location"))
+}
+
+func (suite *OpenApiSuite) Test_Ui_IndexServed() {
+	recorder := suite.get("/openapi/ui/")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("text/html; charset=utf-8", recorder.Header().Get("Content-Type"))
+	suite.Contains(recorder.Body.String(), `src="./swagger-ui-bundle.js"`)
+	suite.NotContains(recorder.Body.String(), "https://", "the assets are not loaded from a CDN")
+}
+
+func (suite *OpenApiSuite) Test_Ui_BundledAssetServed() {
+	recorder := suite.get("/openapi/ui/swagger-ui-bundle.js")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("text/javascript; charset=utf-8", recorder.Header().Get("Content-Type"))
+	suite.NotEmpty(recorder.Body.Bytes())
+
+	recorder = suite.get("/openapi/ui/swagger-ui.css")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("text/css; charset=utf-8", recorder.Header().Get("Content-Type"))
+
+	suite.Equal(http.StatusNotFound, suite.get("/openapi/ui/missing.js").Code)
+}
+
+func (suite *OpenApiSuite) Test_Ui_CustomInitializerServed() {
+	recorder := suite.get("/openapi/ui/swagger-initializer.js")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal(swaggerInitializer, recorder.Body.Bytes())
+	suite.Contains(recorder.Body.String(), `url: "../../openapi.json"`)
+	suite.NotContains(recorder.Body.String(), "petstore")
+}
+
+func (suite *OpenApiSuite) Test_OpenApiJson_Rendered() {
+	data, err := OpenApiJson()
+	suite.Require().NoError(err)
+	suite.True(json.Valid(data))
+}
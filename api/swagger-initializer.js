@@ -0,0 +1,18 @@
+window.onload = function() {
+  // the page is served at <base>/openapi/ui/, relative url keeps working behind path prefixes
+  window.ui = SwaggerUIBundle({
+    url: "../../openapi.json",
+    dom_id: '#swagger-ui',
+    deepLinking: true,
+    // the online validator is not reachable from air-gapped networks
+    validatorUrl: null,
+    presets: [
+      SwaggerUIBundle.presets.apis,
+      SwaggerUIStandalonePreset
+    ],
+    plugins: [
+      SwaggerUIBundle.plugins.DownloadUrl
+    ],
+    layout: "StandaloneLayout"
+  });
+};
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"errors"
 	"github.com/xkello/ambulance-otapi/api"
 	"github.com/xkello/ambulance-otapi/internal/hospital_wl"
 	"github.com/xkello/ambulance-otapi/internal/db_service"
@@ -29,28 +30,187 @@ func main() {
 	corsMiddleware := cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
 		AllowMethods:     []string{"GET", "PUT", "POST", "DELETE", "PATCH"},
-		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type"},
-		ExposeHeaders:    []string{""},
+		AllowHeaders:     []string{"Origin", "Authorization", "Content-Type", "If-Match", "Idempotency-Key"},
+		ExposeHeaders:    []string{"ETag", "X-Total-Count", "Idempotent-Replayed", "X-Dry-Run-Changes"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	})
 	engine.Use(corsMiddleware)
 
-	// setup context update  middleware
-	dbService := db_service.NewMongoService[hospital_wl.Hospital](db_service.MongoServiceConfig{})
+	storage := strings.ToLower(os.Getenv("HOSPITAL_API_STORAGE"))
+	dbService := newDbService[hospital_wl.Hospital](storage, "", os.Getenv("HOSPITAL_API_MEMORY_SEED_FILE"), "")
 	defer dbService.Disconnect(context.Background())
-	engine.Use(func(ctx *gin.Context) {
-		ctx.Set("db_service", dbService)
-		ctx.Next()
-	})
+	employeeCollection := os.Getenv("HOSPITAL_API_EMPLOYEE_COLLECTION")
+	if employeeCollection == "" {
+		employeeCollection = "employee"
+	}
+	employeeDbService := newDbService[hospital_wl.EmployeeDocument](storage, employeeCollection, "", "", "hospitalId")
+	defer employeeDbService.Disconnect(context.Background())
+	performanceCollection := os.Getenv("HOSPITAL_API_PERFORMANCE_COLLECTION")
+	if performanceCollection == "" {
+		performanceCollection = "performance"
+	}
+	performanceDbService := newDbService[hospital_wl.PerformanceDocument](storage, performanceCollection, "", "", "hospitalId", "employeeId", "performance.patientName")
+	defer performanceDbService.Disconnect(context.Background())
+	idempotencyCollection := os.Getenv("HOSPITAL_API_IDEMPOTENCY_COLLECTION")
+	if idempotencyCollection == "" {
+		idempotencyCollection = "idempotency"
+	}
+	idempotencyDbService := newDbService[hospital_wl.IdempotencyRecord](storage, idempotencyCollection, "", "expiresAt")
+	defer idempotencyDbService.Disconnect(context.Background())
+	accessLogCollection := os.Getenv("HOSPITAL_API_ACCESS_LOG_COLLECTION")
+	if accessLogCollection == "" {
+		accessLogCollection = "access-log"
+	}
+	accessLogDbService := newDbService[hospital_wl.AccessRecord](storage, accessLogCollection, "", "", "hospitalId", "patients", "accessedAt")
+	defer accessLogDbService.Disconnect(context.Background())
+
+	checkDbService("hospital", dbService)
+	checkDbService("employee", employeeDbService)
+	checkDbService("performance", performanceDbService)
+	checkDbService("idempotency", idempotencyDbService)
+	checkDbService("access log", accessLogDbService)
+
+	// clinical data of the performances is encrypted at rest if a keyring is configured
+	storedPerformanceDbService := performanceDbService
+	var encryptedPerformanceDbService *db_service.EncryptedService[hospital_wl.PerformanceDocument]
+	var encryptedAccessLogDbService *db_service.EncryptedService[hospital_wl.AccessRecord]
+	var encryptedIdempotencyDbService *db_service.EncryptedService[hospital_wl.IdempotencyRecord]
+	if keyringFile := os.Getenv("HOSPITAL_API_KEYRING_FILE"); keyringFile != "" {
+		keyring, err := db_service.LoadKeyring(keyringFile)
+		if err != nil {
+			log.Fatalf("Failed to load keyring: %v", err)
+		}
+		encryptedPerformanceDbService = db_service.NewEncryptedService(performanceDbService, hospital_wl.PerformanceEncryptionConfig(
+			keyring,
+			strings.EqualFold(os.Getenv("HOSPITAL_API_ENCRYPT_DETAILS"), "true"),
+			strings.EqualFold(os.Getenv("HOSPITAL_API_ENCRYPTION_DETERMINISTIC"), "true"),
+		))
+		performanceDbService = encryptedPerformanceDbService
+		encryptedAccessLogDbService = db_service.NewEncryptedService(accessLogDbService, hospital_wl.AccessLogEncryptionConfig(keyring))
+		accessLogDbService = encryptedAccessLogDbService
+		// the responses replayed to retried requests include the clinical data too
+		encryptedIdempotencyDbService = db_service.NewEncryptedService(idempotencyDbService, hospital_wl.IdempotencyEncryptionConfig(keyring))
+		idempotencyDbService = encryptedIdempotencyDbService
+	}
+
+	if err := hospital_wl.MigrateEmbeddedEmployees(context.Background(), dbService, employeeDbService, performanceDbService); err != nil {
+		log.Fatalf("Failed to migrate employees into their own collection: %v", err)
+	}
+	// legacy dates are recognized only when loaded from the storage, the encryption would convert them on read
+	if err := hospital_wl.MigrateActivityDates(context.Background(), storedPerformanceDbService); err != nil {
+		log.Fatalf("Failed to migrate activity dates: %v", err)
+	}
+	// "reencrypt" command encrypts the existing data with the primary key of the keyring and exits,
+	// it is run after the encryption is enabled, its settings are changed or a key is rotated
+	if len(os.Args) > 1 && os.Args[1] == "reencrypt" {
+		if encryptedPerformanceDbService == nil {
+			log.Fatalf("HOSPITAL_API_KEYRING_FILE is required to re-encrypt the performances")
+		}
+		count, err := encryptedPerformanceDbService.Reencrypt(context.Background())
+		if err != nil {
+			log.Fatalf("Failed to re-encrypt performances: %v", err)
+		}
+		log.Printf("Re-encrypted %v performances", count)
+		// the access log is append-only for the API, but its records are re-encrypted too
+		if count, err = encryptedAccessLogDbService.Reencrypt(context.Background()); err != nil {
+			log.Fatalf("Failed to re-encrypt access log: %v", err)
+		}
+		log.Printf("Re-encrypted %v access log records", count)
+		if count, err = encryptedIdempotencyDbService.Reencrypt(context.Background()); err != nil {
+			log.Fatalf("Failed to re-encrypt idempotency records: %v", err)
+		}
+		log.Printf("Re-encrypted %v idempotency records", count)
+		return
+	}
+
+	// replicas of the service must compute the same entity tags
+	if secret := os.Getenv("HOSPITAL_API_ETAG_SECRET"); secret != "" {
+		hospital_wl.SetETagSecret(secret)
+	}
 
 	// request routings
+	store := hospital_wl.Store{
+		Hospitals:    dbService,
+		Employees:    employeeDbService,
+		Performances: performanceDbService,
+		Idempotency:  idempotencyDbService,
+		AccessLog:    db_service.NewAppendOnlyService(accessLogDbService),
+	}
+	logger := log.Default()
 	handleFunctions := &hospital_wl.ApiHandleFunctions{
-		HospitalRolesAPI:  hospital_wl.NewHospitalRolesApi(),
-		HospitalEmployeeListAPI: hospital_wl.NewHospitalEmployeeListApi(),
-		HospitalsAPI:           hospital_wl.NewHospitalsApi(),
+		AccessLogAPI:      hospital_wl.NewAccessLogApi(store, hospital_wl.SystemClock, hospital_wl.UuidGenerator, logger),
+		HospitalRolesAPI:  hospital_wl.NewHospitalRolesApi(store, hospital_wl.SystemClock, hospital_wl.UuidGenerator, logger),
+		HospitalEmployeeListAPI: hospital_wl.NewHospitalEmployeeListApi(store, hospital_wl.SystemClock, hospital_wl.UuidGenerator, logger),
+		HospitalsAPI:           hospital_wl.NewHospitalsApi(store, hospital_wl.SystemClock, hospital_wl.UuidGenerator, logger),
+	}
+	// requests are authenticated before they are validated, so that anonymous callers learn nothing about the API
+	authenticator, err := hospital_wl.NewAuthenticator(*handleFunctions, hospital_wl.AuthenticationConfig{
+		Issuer:            os.Getenv("HOSPITAL_API_AUTH_ISSUER"),
+		Audience:          os.Getenv("HOSPITAL_API_AUTH_AUDIENCE"),
+		JwksUrl:           os.Getenv("HOSPITAL_API_AUTH_JWKS_URL"),
+		JwksFile:          os.Getenv("HOSPITAL_API_AUTH_JWKS_FILE"),
+		DevelopmentBypass: strings.EqualFold(os.Getenv("HOSPITAL_API_AUTH_DEVELOPMENT_BYPASS"), "true"),
+		Production:        strings.EqualFold(environment, "production"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create authenticator: %v", err)
+	}
+	policy := hospital_wl.DefaultPolicy
+	if policyFile := os.Getenv("HOSPITAL_API_AUTH_POLICY_FILE"); policyFile != "" {
+		if policy, err = hospital_wl.LoadPolicy(policyFile); err != nil {
+			log.Fatalf("Failed to load authorization policy: %v", err)
+		}
+	}
+	authorizer, err := hospital_wl.NewAuthorizer(*handleFunctions, policy)
+	if err != nil {
+		log.Fatalf("Failed to create authorizer: %v", err)
+	}
+	// responses including patient data are recorded in the access log
+	auditor := hospital_wl.NewAccessAuditor(store, hospital_wl.SystemClock, hospital_wl.UuidGenerator, logger)
+	engine.Use(authenticator, authorizer, auditor)
+	validator, err := hospital_wl.NewOpenApiValidator(*handleFunctions, hospital_wl.OpenApiValidatorConfig{
+		// responses are validated only during development, violations are logged
+		ValidateResponses: !strings.EqualFold(environment, "production"),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create OpenAPI validator: %v", err)
 	}
+	engine.Use(validator)
 	hospital_wl.NewRouterWithGinEngine(engine, *handleFunctions)
 	engine.GET("/openapi", api.HandleOpenApi)
+	engine.GET("/openapi.json", hospital_wl.HandleOpenApiJson)
+	engine.GET("/openapi/ui", api.HandleOpenApiUiRedirect)
+	engine.GET("/openapi/ui/*filepath", api.HandleOpenApiUi)
 	engine.Run(":" + port)
 }
+
+// checkDbService stops the service if the storage cannot be used, the MongoDB storage connects
+// on the first use and fails if the unique id index of its collection cannot be created
+func checkDbService[DocType interface{}](name string, svc db_service.DbService[DocType]) {
+	_, err := svc.FindDocumentWith(context.Background(), "", db_service.ReadOptions{Fields: []string{"id"}})
+	if err != nil && !errors.Is(err, db_service.ErrNotFound) {
+		log.Fatalf("Failed to use %v storage: %v", name, err)
+	}
+}
+
+// newDbService creates the storage selected by HOSPITAL_API_STORAGE for one collection of documents.
+// Empty collection name selects the default collection of the storage, seed file is used by the memory storage only.
+// Documents expire at the date in expireAfterField only in MongoDB, the other storages keep them.
+func newDbService[DocType interface{}](storage string, collection string, seedFile string, expireAfterField string, indexes ...string) db_service.DbService[DocType] {
+	switch storage {
+	case "memory":
+		svc, err := db_service.NewMemoryService[DocType](db_service.MemoryServiceConfig{SeedFile: seedFile})
+		if err != nil {
+			log.Fatalf("Failed to create in-memory storage: %v", err)
+		}
+		return svc
+	case "bolt":
+		return db_service.NewBoltService[DocType](db_service.BoltServiceConfig{Bucket: collection})
+	case "", "mongo", "mongodb":
+		return db_service.NewMongoService[DocType](db_service.MongoServiceConfig{Collection: collection, Indexes: indexes, ExpireAfterField: expireAfterField})
+	default:
+		log.Fatalf("Unknown storage: %v", storage)
+		return nil
+	}
+}
@@ -6,6 +6,9 @@ const mongoPassword = process.env.HOSPITAL_API_MONGODB_PASSWORD
 
 const database = process.env.HOSPITAL_API_MONGODB_DATABASE
 const collection = process.env.HOSPITAL_API_MONGODB_COLLECTION
+const employeeCollection = process.env.HOSPITAL_API_EMPLOYEE_COLLECTION || "employee"
+const performanceCollection = process.env.HOSPITAL_API_PERFORMANCE_COLLECTION || "performance"
+const idempotencyCollection = process.env.HOSPITAL_API_IDEMPOTENCY_COLLECTION || "idempotency"
 
 const retrySeconds = parseInt(process.env.RETRY_CONNECTION_SECONDS || "5") || 5;
 
@@ -37,9 +40,21 @@ if (databases.includes(database)) {
 // create database and collection
 const db = connection.getDB(database)
 db.createCollection(collection)
+db.createCollection(employeeCollection)
+db.createCollection(performanceCollection)
+db.createCollection(idempotencyCollection)
 
 // create indexes
 db[collection].createIndex({ "id": 1 })
+db[employeeCollection].createIndex({ "id": 1 })
+db[employeeCollection].createIndex({ "hospitalid": 1 })
+db[performanceCollection].createIndex({ "id": 1 })
+db[performanceCollection].createIndex({ "hospitalid": 1 })
+db[performanceCollection].createIndex({ "employeeid": 1 })
+db[performanceCollection].createIndex({ "performance.patientname": 1 })
+db[idempotencyCollection].createIndex({ "id": 1 })
+// idempotency records are removed once they expire
+db[idempotencyCollection].createIndex({ "expiresat": 1 }, { expireAfterSeconds: 0 })
 
 //insert sample data
 let result = db[collection].insertMany([
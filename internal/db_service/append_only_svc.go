@@ -0,0 +1,71 @@
+package db_service
+
+import (
+	"context"
+	"fmt"
+)
+
+// ErrAppendOnly is returned for changes of the documents stored by an append-only service
+var ErrAppendOnly = fmt.Errorf("documents are append-only")
+
+// appendOnlySvc lets documents be created and read, but never changed or removed,
+// for example the records of an audit trail
+type appendOnlySvc[DocType interface{}] struct {
+	inner DbService[DocType]
+}
+
+func NewAppendOnlyService[DocType interface{}](inner DbService[DocType]) DbService[DocType] {
+	return &appendOnlySvc[DocType]{inner: inner}
+}
+
+func (s *appendOnlySvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	return s.inner.CreateDocument(ctx, id, document)
+}
+
+func (s *appendOnlySvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	return s.inner.FindDocument(ctx, id)
+}
+
+func (s *appendOnlySvc[DocType]) FindDocumentWith(ctx context.Context, id string, options ReadOptions) (*DocType, error) {
+	return s.inner.FindDocumentWith(ctx, id, options)
+}
+
+func (s *appendOnlySvc[DocType]) UpdateDocument(context.Context, string, *DocType) error {
+	return ErrAppendOnly
+}
+
+func (s *appendOnlySvc[DocType]) CompareAndSwapDocument(context.Context, string, int64, *DocType) error {
+	return ErrAppendOnly
+}
+
+func (s *appendOnlySvc[DocType]) DeleteDocument(context.Context, string) error {
+	return ErrAppendOnly
+}
+
+func (s *appendOnlySvc[DocType]) Disconnect(ctx context.Context) error {
+	return s.inner.Disconnect(ctx)
+}
+
+func (s *appendOnlySvc[DocType]) ListDocuments(ctx context.Context) ([]DocType, error) {
+	return s.inner.ListDocuments(ctx)
+}
+
+func (s *appendOnlySvc[DocType]) FindDocuments(ctx context.Context, query Query) ([]DocType, error) {
+	return s.inner.FindDocuments(ctx, query)
+}
+
+func (s *appendOnlySvc[DocType]) CountDocuments(ctx context.Context, query Query) (int64, error) {
+	return s.inner.CountDocuments(ctx, query)
+}
+
+func (s *appendOnlySvc[DocType]) CountDocumentsBy(ctx context.Context, query Query, field string) (map[string]int64, error) {
+	return s.inner.CountDocumentsBy(ctx, query, field)
+}
+
+func (s *appendOnlySvc[DocType]) DeleteDocuments(context.Context, Query) (int64, error) {
+	return 0, ErrAppendOnly
+}
+
+func (s *appendOnlySvc[DocType]) UpdateDocuments(context.Context, []string, DocumentsUpdater[DocType]) error {
+	return ErrAppendOnly
+}
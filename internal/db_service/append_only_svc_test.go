@@ -0,0 +1,23 @@
+package db_service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAppendOnlyService_Changes_Rejected(t *testing.T) {
+	svc := NewAppendOnlyService(newTestMemoryService(t))
+	require.NoError(t, svc.CreateDocument(t.Context(), "a", &testDocument{Id: "a", Name: "A"}))
+
+	assert.Equal(t, ErrAppendOnly, svc.UpdateDocument(t.Context(), "a", &testDocument{Id: "a", Name: "B"}))
+	assert.Equal(t, ErrAppendOnly, svc.CompareAndSwapDocument(t.Context(), "a", 0, &testDocument{Id: "a", Name: "B"}))
+	assert.Equal(t, ErrAppendOnly, svc.DeleteDocument(t.Context(), "a"))
+	_, err := svc.DeleteDocuments(t.Context(), Query{})
+	assert.Equal(t, ErrAppendOnly, err)
+
+	documents, err := svc.ListDocuments(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []testDocument{{Id: "a", Name: "A"}}, documents)
+}
@@ -0,0 +1,380 @@
+package db_service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.etcd.io/bbolt"
+)
+
+type BoltServiceConfig struct {
+	// Path of the database file, created if it does not exist
+	Path string
+	// Bucket holding the documents, the equivalent of a MongoDB collection
+	Bucket  string
+	Timeout time.Duration
+}
+
+type boltSvc[DocType interface{}] struct {
+	BoltServiceConfig
+	db     *bbolt.DB
+	dbLock sync.Mutex
+}
+
+func NewBoltService[DocType interface{}](config BoltServiceConfig) DbService[DocType] {
+	enviro := func(name string, defaultValue string) string {
+		if value, ok := os.LookupEnv(name); ok {
+			return value
+		}
+		return defaultValue
+	}
+
+	svc := &boltSvc[DocType]{}
+	svc.BoltServiceConfig = config
+
+	if svc.Path == "" {
+		svc.Path = enviro("HOSPITAL_API_BOLT_PATH", "hospital.db")
+	}
+
+	if svc.Bucket == "" {
+		svc.Bucket = enviro("HOSPITAL_API_BOLT_BUCKET", "hospital")
+	}
+
+	if svc.Timeout == 0 {
+		seconds := enviro("HOSPITAL_API_BOLT_TIMEOUT_SECONDS", "10")
+		if seconds, err := strconv.Atoi(seconds); err == nil {
+			svc.Timeout = time.Duration(seconds) * time.Second
+		} else {
+			log.Printf("Invalid timeout value: %v", seconds)
+			svc.Timeout = 10 * time.Second
+		}
+	}
+
+	log.Printf("Bolt config: %v/%v", svc.Path, svc.Bucket)
+	return svc
+}
+
+// boltFiles shares open database files between services, bbolt locks the file
+// exclusively so it can be opened only once per process
+var boltFiles = struct {
+	sync.Mutex
+	handles map[string]*boltFile
+}{handles: map[string]*boltFile{}}
+
+type boltFile struct {
+	db         *bbolt.DB
+	references int
+}
+
+func (b *boltSvc[DocType]) connect() (*bbolt.DB, error) {
+	b.dbLock.Lock()
+	defer b.dbLock.Unlock()
+	if b.db != nil {
+		return b.db, nil
+	}
+
+	boltFiles.Lock()
+	defer boltFiles.Unlock()
+	file, exists := boltFiles.handles[b.Path]
+	if !exists {
+		db, err := bbolt.Open(b.Path, 0o600, &bbolt.Options{Timeout: b.Timeout})
+		if err != nil {
+			return nil, err
+		}
+		file = &boltFile{db: db}
+		boltFiles.handles[b.Path] = file
+	}
+
+	err := file.db.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(b.Bucket))
+		return err
+	})
+	if err != nil {
+		if !exists {
+			delete(boltFiles.handles, b.Path)
+			file.db.Close()
+		}
+		return nil, err
+	}
+
+	file.references++
+	b.db = file.db
+	return b.db, nil
+}
+
+func (b *boltSvc[DocType]) Disconnect(ctx context.Context) error {
+	b.dbLock.Lock()
+	defer b.dbLock.Unlock()
+	if b.db == nil {
+		return nil
+	}
+	b.db = nil
+
+	boltFiles.Lock()
+	defer boltFiles.Unlock()
+	file := boltFiles.handles[b.Path]
+	file.references--
+	if file.references > 0 {
+		return nil
+	}
+	delete(boltFiles.handles, b.Path)
+	return file.db.Close()
+}
+
+// view runs fn in a read-only transaction over the documents bucket
+func (b *boltSvc[DocType]) view(fn func(bucket *bbolt.Bucket) error) error {
+	db, err := b.connect()
+	if err != nil {
+		return err
+	}
+	return db.View(func(tx *bbolt.Tx) error {
+		return fn(tx.Bucket([]byte(b.Bucket)))
+	})
+}
+
+// update runs fn in a read-write transaction over the documents bucket,
+// nothing is stored if fn returns an error
+func (b *boltSvc[DocType]) update(fn func(bucket *bbolt.Bucket) error) error {
+	db, err := b.connect()
+	if err != nil {
+		return err
+	}
+	return db.Update(func(tx *bbolt.Tx) error {
+		return fn(tx.Bucket([]byte(b.Bucket)))
+	})
+}
+
+func (b *boltSvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return b.update(func(bucket *bbolt.Bucket) error {
+		if bucket.Get([]byte(id)) != nil {
+			return ErrConflict
+		}
+		return bucket.Put([]byte(id), data)
+	})
+}
+
+func (b *boltSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	var document *DocType
+	err := b.view(func(bucket *bbolt.Bucket) error {
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		var err error
+		document, err = decodeDocument[DocType](data)
+		return err
+	})
+	return document, err
+}
+
+func (b *boltSvc[DocType]) FindDocumentWith(ctx context.Context, id string, options ReadOptions) (*DocType, error) {
+	var document *DocType
+	err := b.view(func(bucket *bbolt.Bucket) error {
+		data := bucket.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		data, err := options.projectJSON(data)
+		if err != nil {
+			return err
+		}
+		document, err = decodeDocument[DocType](data)
+		return err
+	})
+	return document, err
+}
+
+func (b *boltSvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return b.update(func(bucket *bbolt.Bucket) error {
+		if bucket.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Put([]byte(id), data)
+	})
+}
+
+func (b *boltSvc[DocType]) CompareAndSwapDocument(ctx context.Context, id string, expectedVersion int64, document *DocType) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	return b.update(func(bucket *bbolt.Bucket) error {
+		stored := bucket.Get([]byte(id))
+		if stored == nil {
+			return ErrNotFound
+		}
+		version, err := documentVersion(stored)
+		if err != nil {
+			return err
+		}
+		if version != expectedVersion {
+			return ErrVersionMismatch
+		}
+		return bucket.Put([]byte(id), data)
+	})
+}
+
+func (b *boltSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	return b.update(func(bucket *bbolt.Bucket) error {
+		if bucket.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func (b *boltSvc[DocType]) ListDocuments(ctx context.Context) ([]DocType, error) {
+	var results []DocType
+	err := b.view(func(bucket *bbolt.Bucket) error {
+		return bucket.ForEach(func(_, data []byte) error {
+			document, err := decodeDocument[DocType](data)
+			if err != nil {
+				return err
+			}
+			results = append(results, *document)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (b *boltSvc[DocType]) FindDocuments(ctx context.Context, query Query) ([]DocType, error) {
+	var results []DocType
+	err := b.view(func(bucket *bbolt.Bucket) error {
+		var documents [][]byte
+		if err := bucket.ForEach(func(_, data []byte) error {
+			documents = append(documents, data)
+			return nil
+		}); err != nil {
+			return err
+		}
+
+		selected, err := query.selectJSON(documents)
+		if err != nil {
+			return err
+		}
+		for _, data := range selected {
+			document, err := decodeDocument[DocType](data)
+			if err != nil {
+				return err
+			}
+			results = append(results, *document)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (b *boltSvc[DocType]) CountDocuments(ctx context.Context, query Query) (int64, error) {
+	var count int64
+	err := b.view(func(bucket *bbolt.Bucket) error {
+		return bucket.ForEach(func(_, data []byte) error {
+			matches, err := query.matchesJSON(data)
+			if matches {
+				count++
+			}
+			return err
+		})
+	})
+	return count, err
+}
+
+func (b *boltSvc[DocType]) CountDocumentsBy(ctx context.Context, query Query, field string) (map[string]int64, error) {
+	counts := map[string]int64{}
+	err := b.view(func(bucket *bbolt.Bucket) error {
+		return bucket.ForEach(func(_, data []byte) error {
+			value, ok, err := query.groupJSON(data, field)
+			if ok {
+				counts[value]++
+			}
+			return err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+func (b *boltSvc[DocType]) DeleteDocuments(ctx context.Context, query Query) (int64, error) {
+	var deleted int64
+	err := b.update(func(bucket *bbolt.Bucket) error {
+		var matching [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			matches, err := query.matchesJSON(data)
+			if err == nil && matches {
+				matching = append(matching, slices.Clone(key))
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range matching {
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		deleted = int64(len(matching))
+		return nil
+	})
+	return deleted, err
+}
+
+func (b *boltSvc[DocType]) UpdateDocuments(ctx context.Context, ids []string, updater DocumentsUpdater[DocType]) error {
+	return b.update(func(bucket *bbolt.Bucket) error {
+		originals := make([]*DocType, len(ids))
+		for i, id := range ids {
+			if data := bucket.Get([]byte(id)); data != nil {
+				document, err := decodeDocument[DocType](data)
+				if err != nil {
+					return err
+				}
+				originals[i] = document
+			}
+		}
+
+		updated, err := applyUpdater(ids, originals, updater)
+		if err != nil {
+			return err
+		}
+
+		for i, id := range ids {
+			switch {
+			case updated[i] != nil:
+				data, err := json.Marshal(updated[i])
+				if err != nil {
+					return err
+				}
+				if err := bucket.Put([]byte(id), data); err != nil {
+					return err
+				}
+			case originals[i] != nil:
+				if err := bucket.Delete([]byte(id)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
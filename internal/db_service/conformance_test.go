@@ -0,0 +1,397 @@
+package db_service
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/suite"
+)
+
+// DbServiceConformanceSuite verifies that every DbService implementation behaves the same way
+type DbServiceConformanceSuite struct {
+	suite.Suite
+	newService func(t *testing.T) DbService[testDocument]
+	svc        DbService[testDocument]
+}
+
+func TestMemoryServiceConformance(t *testing.T) {
+	suite.Run(t, &DbServiceConformanceSuite{
+		newService: newTestMemoryService,
+	})
+}
+
+func TestBoltServiceConformance(t *testing.T) {
+	suite.Run(t, &DbServiceConformanceSuite{
+		newService: func(t *testing.T) DbService[testDocument] {
+			return NewBoltService[testDocument](BoltServiceConfig{
+				Path: filepath.Join(t.TempDir(), "test.db"),
+			})
+		},
+	})
+}
+
+func TestEncryptedServiceConformance(t *testing.T) {
+	suite.Run(t, &DbServiceConformanceSuite{
+		newService: func(t *testing.T) DbService[testDocument] {
+			return NewEncryptedService(newTestMemoryService(t), EncryptionConfig{
+				Keyring:       testKeyring(t, "k1", "k1"),
+				Fields:        []string{"items.value", "tags"},
+				Deterministic: []string{"items.value", "tags"},
+			})
+		},
+	})
+}
+
+// TestMongoServiceConformance needs a running server configured by HOSPITAL_API_MONGODB_* variables
+func TestMongoServiceConformance(t *testing.T) {
+	if os.Getenv("HOSPITAL_API_TEST_MONGODB") == "" {
+		t.Skip("set HOSPITAL_API_TEST_MONGODB to run against MongoDB")
+	}
+	suite.Run(t, &DbServiceConformanceSuite{
+		newService: func(t *testing.T) DbService[testDocument] {
+			return NewMongoService[testDocument](MongoServiceConfig{
+				DbName:     "ot-hospital-test",
+				Collection: "conformance-" + uuid.NewString(),
+			})
+		},
+	})
+}
+
+func (suite *DbServiceConformanceSuite) SetupTest() {
+	suite.svc = suite.newService(suite.T())
+}
+
+func (suite *DbServiceConformanceSuite) TearDownTest() {
+	suite.NoError(suite.svc.Disconnect(suite.T().Context()))
+}
+
+func (suite *DbServiceConformanceSuite) create(documents ...testDocument) {
+	for _, document := range documents {
+		suite.Require().NoError(suite.svc.CreateDocument(suite.T().Context(), document.Id, &document))
+	}
+}
+
+func (suite *DbServiceConformanceSuite) find(id string) *testDocument {
+	document, err := suite.svc.FindDocument(suite.T().Context(), id)
+	suite.Require().NoError(err)
+	return document
+}
+
+func (suite *DbServiceConformanceSuite) Test_CreateDocument_Found() {
+	suite.create(testDocument{Id: "a", Name: "A", Tags: []string{"x", "y"}})
+
+	suite.Equal(&testDocument{Id: "a", Name: "A", Tags: []string{"x", "y"}}, suite.find("a"))
+}
+
+func (suite *DbServiceConformanceSuite) Test_CreateDocument_Existing_Conflict() {
+	suite.create(testDocument{Id: "a"})
+
+	err := suite.svc.CreateDocument(suite.T().Context(), "a", &testDocument{Id: "a"})
+	suite.ErrorIs(err, ErrConflict)
+}
+
+func (suite *DbServiceConformanceSuite) Test_CreateDocument_Concurrent_OnlyOneCreated() {
+	const attempts = 8
+	errs := make(chan error, attempts)
+	for i := range attempts {
+		go func() {
+			errs <- suite.svc.CreateDocument(suite.T().Context(), "a", &testDocument{Id: "a", Name: strconv.Itoa(i)})
+		}()
+	}
+
+	created := 0
+	for range attempts {
+		if err := <-errs; err == nil {
+			created++
+		} else {
+			suite.ErrorIs(err, ErrConflict)
+		}
+	}
+	suite.Equal(1, created)
+	documents, err := suite.svc.ListDocuments(suite.T().Context())
+	suite.NoError(err)
+	suite.Len(documents, 1)
+}
+
+func (suite *DbServiceConformanceSuite) Test_FindDocument_Missing_NotFound() {
+	_, err := suite.svc.FindDocument(suite.T().Context(), "missing")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *DbServiceConformanceSuite) Test_UpdateDocument_Replaced() {
+	suite.create(testDocument{Id: "a", Name: "A", Tags: []string{"x"}})
+
+	suite.NoError(suite.svc.UpdateDocument(suite.T().Context(), "a", &testDocument{Id: "a", Name: "B"}))
+	suite.Equal(&testDocument{Id: "a", Name: "B"}, suite.find("a"))
+}
+
+func (suite *DbServiceConformanceSuite) Test_UpdateDocument_Missing_NotFound() {
+	err := suite.svc.UpdateDocument(suite.T().Context(), "missing", &testDocument{Id: "missing"})
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *DbServiceConformanceSuite) Test_CompareAndSwapDocument_MatchingVersion_Replaced() {
+	suite.create(testDocument{Id: "a", Name: "A"})
+
+	suite.NoError(suite.svc.CompareAndSwapDocument(suite.T().Context(), "a", 0, &testDocument{Id: "a", Name: "B", Version: 1}))
+	suite.NoError(suite.svc.CompareAndSwapDocument(suite.T().Context(), "a", 1, &testDocument{Id: "a", Name: "C", Version: 2}))
+	suite.Equal(&testDocument{Id: "a", Name: "C", Version: 2}, suite.find("a"))
+}
+
+func (suite *DbServiceConformanceSuite) Test_CompareAndSwapDocument_StaleVersion_Mismatch() {
+	suite.create(testDocument{Id: "a", Name: "A", Version: 3})
+
+	err := suite.svc.CompareAndSwapDocument(suite.T().Context(), "a", 2, &testDocument{Id: "a", Name: "B", Version: 3})
+	suite.ErrorIs(err, ErrVersionMismatch)
+	suite.Equal("A", suite.find("a").Name)
+}
+
+func (suite *DbServiceConformanceSuite) Test_CompareAndSwapDocument_Missing_NotFound() {
+	err := suite.svc.CompareAndSwapDocument(suite.T().Context(), "missing", 0, &testDocument{Id: "missing"})
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *DbServiceConformanceSuite) Test_DeleteDocument_Removed() {
+	suite.create(testDocument{Id: "a"})
+
+	suite.NoError(suite.svc.DeleteDocument(suite.T().Context(), "a"))
+	_, err := suite.svc.FindDocument(suite.T().Context(), "a")
+	suite.ErrorIs(err, ErrNotFound)
+	suite.ErrorIs(suite.svc.DeleteDocument(suite.T().Context(), "a"), ErrNotFound)
+}
+
+func (suite *DbServiceConformanceSuite) Test_ListDocuments_AllReturned() {
+	documents, err := suite.svc.ListDocuments(suite.T().Context())
+	suite.NoError(err)
+	suite.Empty(documents)
+
+	suite.create(testDocument{Id: "a", Name: "A"}, testDocument{Id: "b", Name: "B"})
+
+	documents, err = suite.svc.ListDocuments(suite.T().Context())
+	suite.NoError(err)
+	suite.ElementsMatch([]testDocument{{Id: "a", Name: "A"}, {Id: "b", Name: "B"}}, documents)
+}
+
+func (suite *DbServiceConformanceSuite) Test_FindDocuments_MatchingReturned() {
+	suite.create(
+		testDocument{Id: "a", Name: "A", Owner: testOwner{GroupId: "g1"}},
+		testDocument{Id: "b", Name: "B", Owner: testOwner{GroupId: "g2"}},
+		testDocument{Id: "c", Name: "C", Owner: testOwner{GroupId: "g1"}},
+	)
+
+	documents, err := suite.svc.FindDocuments(suite.T().Context(), Query{Equal: map[string]interface{}{"owner.groupId": "g1"}})
+	suite.NoError(err)
+	suite.ElementsMatch([]string{"a", "c"}, documentIds(documents))
+
+	documents, err = suite.svc.FindDocuments(suite.T().Context(), Query{Equal: map[string]interface{}{"owner.groupId": "g1", "name": "C"}})
+	suite.NoError(err)
+	suite.Equal([]string{"c"}, documentIds(documents))
+
+	documents, err = suite.svc.FindDocuments(suite.T().Context(), Query{Equal: map[string]interface{}{"owner.groupId": "g3"}})
+	suite.NoError(err)
+	suite.Empty(documents)
+}
+
+func (suite *DbServiceConformanceSuite) Test_DeleteDocuments_MatchingRemoved() {
+	suite.create(
+		testDocument{Id: "a", Owner: testOwner{GroupId: "g1"}},
+		testDocument{Id: "b", Owner: testOwner{GroupId: "g2"}},
+		testDocument{Id: "c", Owner: testOwner{GroupId: "g1"}},
+	)
+
+	deleted, err := suite.svc.DeleteDocuments(suite.T().Context(), Query{Equal: map[string]interface{}{"owner.groupId": "g1"}})
+	suite.NoError(err)
+	suite.Equal(int64(2), deleted)
+
+	documents, err := suite.svc.ListDocuments(suite.T().Context())
+	suite.NoError(err)
+	suite.Equal([]string{"b"}, documentIds(documents))
+}
+
+func (suite *DbServiceConformanceSuite) Test_UpdateDocuments_AllChangesStored() {
+	suite.create(testDocument{Id: "a", Name: "A"}, testDocument{Id: "b", Name: "B"})
+
+	err := suite.svc.UpdateDocuments(suite.T().Context(), []string{"a", "b", "c"}, func(documents []*testDocument) ([]*testDocument, error) {
+		suite.Require().NotNil(documents[0])
+		suite.Require().NotNil(documents[1])
+		suite.Nil(documents[2])
+		documents[0].Name = "A2"
+		return []*testDocument{documents[0], nil, {Id: "c", Name: "C"}}, nil
+	})
+	suite.NoError(err)
+
+	suite.Equal("A2", suite.find("a").Name)
+	_, err = suite.svc.FindDocument(suite.T().Context(), "b")
+	suite.ErrorIs(err, ErrNotFound)
+	suite.Equal("C", suite.find("c").Name)
+}
+
+func (suite *DbServiceConformanceSuite) Test_UpdateDocuments_UpdaterFails_NothingStored() {
+	suite.create(testDocument{Id: "a", Name: "A"})
+	errRejected := errors.New("rejected")
+
+	err := suite.svc.UpdateDocuments(suite.T().Context(), []string{"a", "b"}, func(documents []*testDocument) ([]*testDocument, error) {
+		documents[0].Name = "A2"
+		return nil, errRejected
+	})
+	suite.ErrorIs(err, errRejected)
+	suite.Equal("A", suite.find("a").Name)
+	_, err = suite.svc.FindDocument(suite.T().Context(), "b")
+	suite.ErrorIs(err, ErrNotFound)
+}
+
+func (suite *DbServiceConformanceSuite) Test_FindDocumentWith_NoOptions_Complete() {
+	suite.create(testDocument{Id: "a", Name: "A", Tags: []string{"x"}})
+
+	document, err := suite.svc.FindDocumentWith(suite.T().Context(), "a", ReadOptions{})
+	suite.Require().NoError(err)
+	suite.Equal(&testDocument{Id: "a", Name: "A", Tags: []string{"x"}}, document)
+}
+
+func (suite *DbServiceConformanceSuite) Test_FindDocumentWith_Fields_OnlyFieldsReturned() {
+	suite.create(testDocument{Id: "a", Name: "A", Tags: []string{"x"}, Owner: testOwner{GroupId: "g"}})
+
+	document, err := suite.svc.FindDocumentWith(suite.T().Context(), "a", ReadOptions{Fields: []string{"name", "owner.groupId"}})
+	suite.Require().NoError(err)
+	suite.Equal(&testDocument{Name: "A", Owner: testOwner{GroupId: "g"}}, document)
+}
+
+func (suite *DbServiceConformanceSuite) Test_FindDocuments_Conditions_AllApplied() {
+	suite.create(
+		testDocument{Id: "a", Name: "Anna", Rank: 7, Owner: testOwner{GroupId: "g1"}},
+		testDocument{Id: "b", Name: "Hannah", Rank: 9, Owner: testOwner{GroupId: "g1"}},
+		testDocument{Id: "c", Name: "Hanka", Rank: 5, Owner: testOwner{GroupId: "g1"}},
+		testDocument{Id: "d", Name: "Annabel", Rank: 8, Owner: testOwner{GroupId: "g2"}},
+		testDocument{Id: "e", Name: "Bob", Rank: 10, Owner: testOwner{GroupId: "g1"}},
+	)
+
+	documents, err := suite.svc.FindDocuments(suite.T().Context(), Query{
+		Equal:          map[string]interface{}{"owner.groupId": "g1"},
+		GreaterOrEqual: map[string]interface{}{"rank": 7},
+		Contains:       map[string]string{"name": "ANN"},
+	})
+	suite.Require().NoError(err)
+	suite.ElementsMatch([]string{"a", "b"}, documentIds(documents))
+
+	documents, err = suite.svc.FindDocuments(suite.T().Context(), Query{
+		GreaterOrEqual: map[string]interface{}{"rank": 7},
+		Less:           map[string]interface{}{"rank": 9},
+	})
+	suite.Require().NoError(err)
+	suite.ElementsMatch([]string{"a", "d"}, documentIds(documents))
+}
+
+func (suite *DbServiceConformanceSuite) Test_FindDocuments_ArrayElements_Matched() {
+	suite.create(
+		testDocument{Id: "a", Tags: []string{"x", "y"}, Items: []testItem{{Id: "1", Value: "p"}, {Id: "2", Value: "q"}}},
+		testDocument{Id: "b", Tags: []string{"y"}, Items: []testItem{{Id: "1", Value: "q"}}},
+		testDocument{Id: "c"},
+	)
+
+	documents, err := suite.svc.FindDocuments(suite.T().Context(), Query{Equal: map[string]interface{}{"items.value": "p"}})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"a"}, documentIds(documents))
+
+	documents, err = suite.svc.FindDocuments(suite.T().Context(), Query{Equal: map[string]interface{}{"items.value": "q", "tags": "y"}})
+	suite.Require().NoError(err)
+	suite.ElementsMatch([]string{"a", "b"}, documentIds(documents))
+}
+
+func (suite *DbServiceConformanceSuite) Test_FindDocuments_In_AnyValueMatched() {
+	suite.create(
+		testDocument{Id: "a", Owner: testOwner{GroupId: "g1"}},
+		testDocument{Id: "b", Owner: testOwner{GroupId: "g2"}},
+		testDocument{Id: "c", Owner: testOwner{GroupId: "g3"}},
+	)
+
+	documents, err := suite.svc.FindDocuments(suite.T().Context(), Query{In: map[string][]interface{}{"owner.groupId": {"g1", "g2", "g4"}}})
+	suite.Require().NoError(err)
+	suite.ElementsMatch([]string{"a", "b"}, documentIds(documents))
+
+	documents, err = suite.svc.FindDocuments(suite.T().Context(), Query{In: map[string][]interface{}{"id": {"a", "b", "c"}, "owner.groupId": {"g3"}}})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"c"}, documentIds(documents))
+
+	documents, err = suite.svc.FindDocuments(suite.T().Context(), Query{In: map[string][]interface{}{"id": {}}})
+	suite.Require().NoError(err)
+	suite.Empty(documents)
+}
+
+func (suite *DbServiceConformanceSuite) Test_FindDocuments_SortedAndPaged() {
+	suite.create(
+		testDocument{Id: "a", Name: "C", Rank: 1},
+		testDocument{Id: "b", Name: "A", Rank: 2},
+		testDocument{Id: "c", Name: "B", Rank: 2},
+		testDocument{Id: "d", Name: "D", Rank: 3},
+	)
+
+	documents, err := suite.svc.FindDocuments(suite.T().Context(), Query{Sort: []string{"name"}})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"b", "c", "a", "d"}, documentIds(documents))
+
+	documents, err = suite.svc.FindDocuments(suite.T().Context(), Query{Sort: []string{"-rank", "name"}, Skip: 1, Limit: 2})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"b", "c"}, documentIds(documents))
+
+	documents, err = suite.svc.FindDocuments(suite.T().Context(), Query{Sort: []string{"name"}, Skip: 3, Limit: 2})
+	suite.Require().NoError(err)
+	suite.Equal([]string{"d"}, documentIds(documents))
+}
+
+func (suite *DbServiceConformanceSuite) Test_FindDocuments_Fields_OnlyFieldsReturned() {
+	suite.create(
+		testDocument{Id: "a", Name: "A", Tags: []string{"x"}, Rank: 1},
+		testDocument{Id: "b", Name: "B", Tags: []string{"y"}, Rank: 2},
+	)
+
+	documents, err := suite.svc.FindDocuments(suite.T().Context(), Query{Fields: []string{"id", "name"}, Sort: []string{"rank"}})
+	suite.Require().NoError(err)
+	suite.Equal([]testDocument{{Id: "a", Name: "A"}, {Id: "b", Name: "B"}}, documents)
+}
+
+func (suite *DbServiceConformanceSuite) Test_CountDocuments_PagingIgnored() {
+	suite.create(
+		testDocument{Id: "a", Rank: 1},
+		testDocument{Id: "b", Rank: 2},
+		testDocument{Id: "c", Rank: 3},
+	)
+
+	count, err := suite.svc.CountDocuments(suite.T().Context(), Query{
+		GreaterOrEqual: map[string]interface{}{"rank": 2},
+		Limit:          1,
+	})
+	suite.Require().NoError(err)
+	suite.Equal(int64(2), count)
+}
+
+func (suite *DbServiceConformanceSuite) Test_CountDocumentsBy_CountedPerValue() {
+	suite.create(
+		testDocument{Id: "a", Name: "A", Rank: 1},
+		testDocument{Id: "b", Name: "B", Rank: 2},
+		testDocument{Id: "c", Name: "A", Rank: 3},
+		testDocument{Id: "d", Rank: 4},
+	)
+
+	counts, err := suite.svc.CountDocumentsBy(suite.T().Context(), Query{
+		GreaterOrEqual: map[string]interface{}{"rank": 2},
+		Limit:          1,
+	}, "name")
+	suite.Require().NoError(err)
+	suite.Equal(map[string]int64{"A": 1, "B": 1, "": 1}, counts)
+
+	counts, err = suite.svc.CountDocumentsBy(suite.T().Context(), Query{}, "rank")
+	suite.Require().NoError(err)
+	suite.Empty(counts, "only string values are counted")
+}
+
+func documentIds(documents []testDocument) []string {
+	ids := []string{}
+	for _, document := range documents {
+		ids = append(ids, document.Id)
+	}
+	return ids
+}
@@ -0,0 +1,370 @@
+package db_service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+)
+
+// ErrNotSearchable is returned for queries conditioning encrypted fields, which can be selected
+// only by Query.Equal and only if they are encrypted deterministically
+var ErrNotSearchable = fmt.Errorf("encrypted field cannot be searched")
+
+type EncryptionConfig struct {
+	Keyring *Keyring
+
+	// Fields lists dot separated paths of the string fields to encrypt, paths crossing arrays
+	// select the field in all elements, for example "entry.performances.patientName". Fields
+	// holding arrays of strings have all the strings encrypted.
+	Fields []string
+
+	// Deterministic lists the Fields encrypted to equal values for equal plaintexts, so that
+	// Query.Equal can select them. Values encrypted with a retired key are not selected until
+	// the documents are re-encrypted.
+	Deterministic []string
+}
+
+// EncryptedService encrypts the configured fields of the documents before the wrapped service
+// stores them, and decrypts them on read. Plaintext values stored before the encryption
+// was enabled are read unchanged, Reencrypt encrypts them.
+type EncryptedService[DocType interface{}] struct {
+	EncryptionConfig
+	inner DbService[DocType]
+}
+
+func NewEncryptedService[DocType interface{}](inner DbService[DocType], config EncryptionConfig) *EncryptedService[DocType] {
+	return &EncryptedService[DocType]{EncryptionConfig: config, inner: inner}
+}
+
+// fieldTransform maps the value of the encrypted field
+type fieldTransform func(value string, deterministic bool) (string, error)
+
+// encryptValue encrypts the value written by the caller, which is always plaintext,
+// also if it looks like an encrypted value
+func (s *EncryptedService[DocType]) encryptValue(value string, deterministic bool) (string, error) {
+	if value == "" {
+		return value, nil
+	}
+	return s.Keyring.encrypt(value, deterministic)
+}
+
+// reencryptValue encrypts the stored value with the primary key, unless it is already
+func (s *EncryptedService[DocType]) reencryptValue(value string, deterministic bool) (string, error) {
+	if value == "" || s.Keyring.current(value, deterministic) {
+		return value, nil
+	}
+	plaintext, err := s.Keyring.decrypt(value)
+	if err != nil {
+		return "", err
+	}
+	return s.Keyring.encrypt(plaintext, deterministic)
+}
+
+func (s *EncryptedService[DocType]) decryptValue(value string, _ bool) (string, error) {
+	return s.Keyring.decrypt(value)
+}
+
+// transformFields applies the transform to the encrypted fields of the generic JSON value,
+// which is found at the prefix path of the document
+func (s *EncryptedService[DocType]) transformFields(value interface{}, prefix string, transform fieldTransform) error {
+	for _, field := range s.Fields {
+		path := field
+		if prefix != "" {
+			var ok bool
+			if path, ok = strings.CutPrefix(field, prefix+"."); !ok {
+				continue
+			}
+		}
+		deterministic := slices.Contains(s.Deterministic, field)
+		err := transformJSONPath(value, strings.Split(path, "."), func(value string) (string, error) {
+			return transform(value, deterministic)
+		})
+		if err != nil {
+			return fmt.Errorf("field %v: %w", field, err)
+		}
+	}
+	return nil
+}
+
+// transformJSONPath replaces the strings at the path of the generic JSON value,
+// continuing into the elements of the arrays on the path
+func transformJSONPath(value interface{}, path []string, transform func(string) (string, error)) error {
+	switch value := value.(type) {
+	case []interface{}:
+		for _, element := range value {
+			if err := transformJSONPath(element, path, transform); err != nil {
+				return err
+			}
+		}
+	case map[string]interface{}:
+		child, ok := value[path[0]]
+		if !ok {
+			return nil
+		}
+		if len(path) > 1 {
+			return transformJSONPath(child, path[1:], transform)
+		}
+		switch child := child.(type) {
+		case string:
+			transformed, err := transform(child)
+			if err != nil {
+				return err
+			}
+			value[path[0]] = transformed
+		case []interface{}:
+			// arrays of strings are transformed element by element
+			for i, element := range child {
+				if text, ok := element.(string); ok {
+					transformed, err := transform(text)
+					if err != nil {
+						return err
+					}
+					child[i] = transformed
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// transformDocument returns the copy of the document with the transformed fields
+func (s *EncryptedService[DocType]) transformDocument(document *DocType, transform fieldTransform) (*DocType, error) {
+	if document == nil {
+		return nil, nil
+	}
+	generic, err := jsonValue(document)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.transformFields(generic, "", transform); err != nil {
+		return nil, err
+	}
+	result := new(DocType)
+	return result, convertJSON(generic, result)
+}
+
+func (s *EncryptedService[DocType]) transformDocuments(documents []DocType, transform fieldTransform) ([]DocType, error) {
+	result := make([]DocType, 0, len(documents))
+	for i := range documents {
+		document, err := s.transformDocument(&documents[i], transform)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, *document)
+	}
+	return result, nil
+}
+
+// convertJSON stores the generic JSON value into the target
+func convertJSON(value interface{}, target interface{}) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
+
+// encryptQuery replaces the values of the encrypted fields in the query by their ciphertexts
+func (s *EncryptedService[DocType]) encryptQuery(query Query) (Query, error) {
+	for _, field := range s.Fields {
+		_, greater := query.GreaterOrEqual[field]
+		_, less := query.Less[field]
+		_, contains := query.Contains[field]
+		_, in := query.In[field]
+		sorted := slices.ContainsFunc(query.Sort, func(key string) bool { return strings.TrimPrefix(key, "-") == field })
+		if greater || less || contains || in || sorted {
+			return query, fmt.Errorf("%w: %v is only compared for equality", ErrNotSearchable, field)
+		}
+		value, ok := query.Equal[field]
+		if !ok {
+			continue
+		}
+		text, ok := value.(string)
+		if !ok || !slices.Contains(s.Deterministic, field) {
+			return query, fmt.Errorf("%w: %v is not encrypted deterministically", ErrNotSearchable, field)
+		}
+		ciphertext, err := s.Keyring.encrypt(text, true)
+		if err != nil {
+			return query, err
+		}
+		equal := make(map[string]interface{}, len(query.Equal))
+		for path, value := range query.Equal {
+			equal[path] = value
+		}
+		equal[field] = ciphertext
+		query.Equal = equal
+	}
+	return query, nil
+}
+
+func (s *EncryptedService[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	encrypted, err := s.transformDocument(document, s.encryptValue)
+	if err != nil {
+		return err
+	}
+	return s.inner.CreateDocument(ctx, id, encrypted)
+}
+
+func (s *EncryptedService[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	document, err := s.inner.FindDocument(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return s.transformDocument(document, s.decryptValue)
+}
+
+func (s *EncryptedService[DocType]) FindDocumentWith(ctx context.Context, id string, options ReadOptions) (*DocType, error) {
+	document, err := s.inner.FindDocumentWith(ctx, id, options)
+	if err != nil {
+		return nil, err
+	}
+	return s.transformDocument(document, s.decryptValue)
+}
+
+func (s *EncryptedService[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	encrypted, err := s.transformDocument(document, s.encryptValue)
+	if err != nil {
+		return err
+	}
+	return s.inner.UpdateDocument(ctx, id, encrypted)
+}
+
+func (s *EncryptedService[DocType]) CompareAndSwapDocument(ctx context.Context, id string, expectedVersion int64, document *DocType) error {
+	encrypted, err := s.transformDocument(document, s.encryptValue)
+	if err != nil {
+		return err
+	}
+	return s.inner.CompareAndSwapDocument(ctx, id, expectedVersion, encrypted)
+}
+
+func (s *EncryptedService[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	return s.inner.DeleteDocument(ctx, id)
+}
+
+func (s *EncryptedService[DocType]) Disconnect(ctx context.Context) error {
+	return s.inner.Disconnect(ctx)
+}
+
+func (s *EncryptedService[DocType]) ListDocuments(ctx context.Context) ([]DocType, error) {
+	documents, err := s.inner.ListDocuments(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return s.transformDocuments(documents, s.decryptValue)
+}
+
+func (s *EncryptedService[DocType]) FindDocuments(ctx context.Context, query Query) ([]DocType, error) {
+	query, err := s.encryptQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	documents, err := s.inner.FindDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return s.transformDocuments(documents, s.decryptValue)
+}
+
+func (s *EncryptedService[DocType]) CountDocuments(ctx context.Context, query Query) (int64, error) {
+	query, err := s.encryptQuery(query)
+	if err != nil {
+		return 0, err
+	}
+	return s.inner.CountDocuments(ctx, query)
+}
+
+// CountDocumentsBy rejects the encrypted fields, their stored values are not the plaintext
+func (s *EncryptedService[DocType]) CountDocumentsBy(ctx context.Context, query Query, field string) (map[string]int64, error) {
+	if slices.Contains(s.Fields, field) {
+		return nil, fmt.Errorf("%w: %v is only compared for equality", ErrNotSearchable, field)
+	}
+	query, err := s.encryptQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return s.inner.CountDocumentsBy(ctx, query, field)
+}
+
+func (s *EncryptedService[DocType]) DeleteDocuments(ctx context.Context, query Query) (int64, error) {
+	query, err := s.encryptQuery(query)
+	if err != nil {
+		return 0, err
+	}
+	return s.inner.DeleteDocuments(ctx, query)
+}
+
+func (s *EncryptedService[DocType]) UpdateDocuments(ctx context.Context, ids []string, updater DocumentsUpdater[DocType]) error {
+	return s.inner.UpdateDocuments(ctx, ids, func(documents []*DocType) ([]*DocType, error) {
+		decrypted := make([]*DocType, len(documents))
+		for i, document := range documents {
+			var err error
+			if decrypted[i], err = s.transformDocument(document, s.decryptValue); err != nil {
+				return nil, err
+			}
+		}
+		updated, err := updater(decrypted)
+		if err != nil {
+			return nil, err
+		}
+		encrypted := make([]*DocType, len(updated))
+		for i, document := range updated {
+			if encrypted[i], err = s.transformDocument(document, s.encryptValue); err != nil {
+				return nil, err
+			}
+		}
+		return encrypted, nil
+	})
+}
+
+// Reencrypt stores again the documents with plaintext values, values encrypted with a retired key
+// or in the other mode, and returns their count. It is run after a new primary key is added to
+// the keyring, or the encrypted fields or their modes are changed. Documents changed concurrently
+// are skipped, the change stored them encrypted already.
+func (s *EncryptedService[DocType]) Reencrypt(ctx context.Context) (int, error) {
+	documents, err := s.inner.ListDocuments(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	reencrypted := 0
+	for i := range documents {
+		generic, err := jsonValue(&documents[i])
+		if err != nil {
+			return reencrypted, err
+		}
+		stale := false
+		err = s.transformFields(generic, "", func(value string, deterministic bool) (string, error) {
+			stale = stale || (value != "" && !s.Keyring.current(value, deterministic))
+			return s.reencryptValue(value, deterministic)
+		})
+		if err != nil {
+			return reencrypted, err
+		}
+		if !stale {
+			continue
+		}
+
+		object, _ := generic.(map[string]interface{})
+		id, _ := object["id"].(string)
+		expectedVersion, _ := object[VersionField].(float64)
+		object[VersionField] = expectedVersion + 1
+		document := new(DocType)
+		if err := convertJSON(object, document); err != nil {
+			return reencrypted, err
+		}
+		err = s.inner.CompareAndSwapDocument(ctx, id, int64(expectedVersion), document)
+		switch {
+		case err == nil:
+			reencrypted++
+		case errors.Is(err, ErrVersionMismatch), errors.Is(err, ErrNotFound):
+			log.Printf("Skipped re-encryption of document %v changed concurrently", id)
+		default:
+			return reencrypted, fmt.Errorf("failed to re-encrypt document %v: %w", id, err)
+		}
+	}
+	return reencrypted, nil
+}
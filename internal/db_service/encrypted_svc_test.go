@@ -0,0 +1,170 @@
+package db_service
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testKeyring holds the keys with the given ids, keys of the same id are equal in all keyrings
+func testKeyring(t *testing.T, primary string, ids ...string) *Keyring {
+	keys := map[string][]byte{}
+	for _, id := range ids {
+		key := sha256.Sum256([]byte(id))
+		keys[id] = key[:]
+	}
+	keyring, err := NewKeyring(primary, keys)
+	require.NoError(t, err)
+	return keyring
+}
+
+func newTestEncryptedService(inner DbService[testDocument], keyring *Keyring, deterministic ...string) *EncryptedService[testDocument] {
+	return NewEncryptedService(inner, EncryptionConfig{
+		Keyring:       keyring,
+		Fields:        []string{"name", "items.value"},
+		Deterministic: deterministic,
+	})
+}
+
+func TestEncryptedService_StoredEncrypted_ReadDecrypted(t *testing.T) {
+	inner := newTestMemoryService(t)
+	svc := newTestEncryptedService(inner, testKeyring(t, "k1", "k1"))
+	require.NoError(t, svc.CreateDocument(t.Context(), "a", &testDocument{Id: "a", Name: "Anna", Items: []testItem{{Id: "1", Value: "x"}, {Id: "2", Value: "x"}}}))
+
+	stored, err := inner.FindDocument(t.Context(), "a")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Name, "enc:r:k1:"), stored.Name)
+	require.Len(t, stored.Items, 2)
+	assert.Equal(t, "2", stored.Items[1].Id)
+	assert.True(t, strings.HasPrefix(stored.Items[1].Value, "enc:r:k1:"), stored.Items[1].Value)
+	assert.NotEqual(t, stored.Items[0].Value, stored.Items[1].Value, "random mode must not reveal equal values")
+
+	found, err := svc.FindDocument(t.Context(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, &testDocument{Id: "a", Name: "Anna", Items: []testItem{{Id: "1", Value: "x"}, {Id: "2", Value: "x"}}}, found)
+}
+
+func TestEncryptedService_PlaintextDocuments_ReadAndReencrypted(t *testing.T) {
+	inner := newTestMemoryService(t)
+	require.NoError(t, inner.CreateDocument(t.Context(), "a", &testDocument{Id: "a", Name: "Anna", Version: 3}))
+	svc := newTestEncryptedService(inner, testKeyring(t, "k1", "k1"))
+
+	found, err := svc.FindDocument(t.Context(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, "Anna", found.Name)
+
+	count, err := svc.Reencrypt(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	stored, err := inner.FindDocument(t.Context(), "a")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Name, "enc:r:k1:"), stored.Name)
+	assert.Equal(t, int64(4), stored.Version)
+
+	count, err = svc.Reencrypt(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
+
+func TestEncryptedService_PlaintextLikeCiphertext_Encrypted(t *testing.T) {
+	inner := newTestMemoryService(t)
+	svc := newTestEncryptedService(inner, testKeyring(t, "k1", "k1"), "name")
+	ciphertext, err := testKeyring(t, "k1", "k1").encrypt("x", false)
+	require.NoError(t, err)
+	document := &testDocument{Id: "a", Name: "enc:foo", Items: []testItem{{Id: "1", Value: "enc:r:k2:AAAA"}, {Id: "2", Value: ciphertext}}}
+	require.NoError(t, svc.CreateDocument(t.Context(), "a", document))
+
+	stored, err := inner.FindDocument(t.Context(), "a")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Name, "enc:d:k1:"), stored.Name)
+	assert.NotEqual(t, ciphertext, stored.Items[1].Value)
+	found, err := svc.FindDocument(t.Context(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, document, found)
+
+	documents, err := svc.FindDocuments(t.Context(), Query{Equal: map[string]interface{}{"name": "enc:foo"}})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, documentIds(documents))
+
+	// plaintext stored before the encryption was enabled
+	require.NoError(t, inner.CreateDocument(t.Context(), "b", &testDocument{Id: "b", Name: "enc:bar"}))
+	found, err = svc.FindDocument(t.Context(), "b")
+	require.NoError(t, err)
+	assert.Equal(t, "enc:bar", found.Name)
+}
+
+func TestEncryptedService_KeyRotated_SearchableAfterReencryption(t *testing.T) {
+	inner := newTestMemoryService(t)
+	old := newTestEncryptedService(inner, testKeyring(t, "k1", "k1"), "name")
+	require.NoError(t, old.CreateDocument(t.Context(), "a", &testDocument{Id: "a", Name: "Anna"}))
+	require.NoError(t, old.CreateDocument(t.Context(), "b", &testDocument{Id: "b", Name: "Bob"}))
+	byName := Query{Equal: map[string]interface{}{"name": "Anna"}}
+
+	documents, err := old.FindDocuments(t.Context(), byName)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, documentIds(documents))
+
+	svc := newTestEncryptedService(inner, testKeyring(t, "k2", "k1", "k2"), "name")
+	documents, err = svc.FindDocuments(t.Context(), byName)
+	require.NoError(t, err)
+	assert.Empty(t, documents, "values of the retired key are not found until re-encrypted")
+
+	count, err := svc.Reencrypt(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	documents, err = svc.FindDocuments(t.Context(), byName)
+	require.NoError(t, err)
+	assert.Equal(t, []testDocument{{Id: "a", Name: "Anna", Version: 1}}, documents)
+	stored, err := inner.FindDocument(t.Context(), "a")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(stored.Name, "enc:d:k2:"), stored.Name)
+
+	_, err = newTestEncryptedService(inner, testKeyring(t, "k1", "k1")).FindDocument(t.Context(), "a")
+	assert.Error(t, err, "retired keyring cannot read the rotated values")
+}
+
+func TestEncryptedService_RandomField_NotSearchable(t *testing.T) {
+	svc := newTestEncryptedService(newTestMemoryService(t), testKeyring(t, "k1", "k1"), "name")
+
+	queries := map[string]Query{
+		"random equal":       {Equal: map[string]interface{}{"items.value": "x"}},
+		"deterministic text": {Contains: map[string]string{"name": "ann"}},
+		"deterministic sort": {Sort: []string{"-name"}},
+	}
+	for name, query := range queries {
+		_, err := svc.FindDocuments(t.Context(), query)
+		assert.ErrorIs(t, err, ErrNotSearchable, name)
+	}
+}
+
+func TestLoadKeyring_InvalidKeyringsRejected(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, keySize))
+	keyrings := map[string]string{
+		"missing primary": `{"primary": "k2", "keys": [{"id": "k1", "key": "` + key + `"}]}`,
+		"short key":       `{"primary": "k1", "keys": [{"id": "k1", "key": "c2hvcnQ="}]}`,
+		"not base64":      `{"primary": "k1", "keys": [{"id": "k1", "key": "not base64"}]}`,
+		"colon in id":     `{"primary": "k:1", "keys": [{"id": "k:1", "key": "` + key + `"}]}`,
+		"duplicate id":    `{"primary": "k1", "keys": [{"id": "k1", "key": "` + key + `"}, {"id": "k1", "key": "` + key + `"}]}`,
+	}
+	path := filepath.Join(t.TempDir(), "keyring.json")
+	for name, content := range keyrings {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+		_, err := LoadKeyring(path)
+		assert.Error(t, err, name)
+	}
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"primary": "k1", "keys": [{"id": "k1", "key": "`+key+`"}]}`), 0o600))
+	keyring, err := LoadKeyring(path)
+	require.NoError(t, err)
+	encrypted, err := keyring.encrypt("Anna", false)
+	require.NoError(t, err)
+	decrypted, err := keyring.decrypt(encrypted)
+	require.NoError(t, err)
+	assert.Equal(t, "Anna", decrypted)
+}
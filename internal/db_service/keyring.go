@@ -0,0 +1,166 @@
+package db_service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix marks the encrypted field values, followed by the mode, the key id and the
+// base64 encoded nonce and ciphertext separated by colons, for example "enc:r:2025-01:...".
+// Values without the prefix, or not in this form, are plaintext stored before the encryption
+// was enabled. The values written through EncryptedService are always encrypted, so that
+// a plaintext looking like an encrypted value is not stored.
+const encryptedPrefix = "enc:"
+
+const (
+	// randomMode encrypts with a random nonce, equal values have different ciphertexts
+	randomMode = "r"
+	// deterministicMode derives the nonce from the value, equal values encrypted with the same key
+	// have equal ciphertexts, so that they can be searched for
+	deterministicMode = "d"
+)
+
+// keySize is the size of the keys in the keyring file, they select AES-256
+const keySize = 32
+
+// Keyring holds the keys encrypting document fields. Values are encrypted with the primary key
+// and keep the id of their key, so the keys can be rotated: a new primary key is added to the
+// keyring, the documents are re-encrypted and the retired key is removed afterwards.
+type Keyring struct {
+	primary string
+	keys    map[string]fieldKey
+}
+
+// fieldKey is derived from a key of the keyring, separate keys encrypt the values
+// and derive the nonces of the deterministic mode
+type fieldKey struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// keyringFile is the JSON form of the keyring, the keys are base64 encoded 32 bytes
+type keyringFile struct {
+	Primary string `json:"primary"`
+	Keys    []struct {
+		Id  string `json:"id"`
+		Key string `json:"key"`
+	} `json:"keys"`
+}
+
+// LoadKeyring reads the keyring from the JSON file, for example
+// {"primary": "2025-01", "keys": [{"id": "2025-01", "key": "<base64 of 32 random bytes>"}]}
+func LoadKeyring(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("invalid keyring: %w", err)
+	}
+	keys := map[string][]byte{}
+	for _, key := range file.Keys {
+		if _, exists := keys[key.Id]; exists {
+			return nil, fmt.Errorf("invalid keyring: duplicate key id %v", key.Id)
+		}
+		if keys[key.Id], err = base64.StdEncoding.DecodeString(key.Key); err != nil {
+			return nil, fmt.Errorf("invalid keyring: key %v is not base64 encoded", key.Id)
+		}
+	}
+	return NewKeyring(file.Primary, keys)
+}
+
+// NewKeyring creates the keyring of the 32 byte keys by their ids, the primary key encrypts new values
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("invalid keyring: primary key %q is missing", primary)
+	}
+	keyring := &Keyring{primary: primary, keys: map[string]fieldKey{}}
+	for id, key := range keys {
+		if id == "" || strings.Contains(id, ":") {
+			return nil, fmt.Errorf("invalid keyring: key id %q must be non-empty and without colons", id)
+		}
+		if len(key) != keySize {
+			return nil, fmt.Errorf("invalid keyring: key %v must have %v bytes", id, keySize)
+		}
+		block, err := aes.NewCipher(deriveKey(key, "value"))
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys[id] = fieldKey{aead: aead, nonceKey: deriveKey(key, "nonce")}
+	}
+	return keyring, nil
+}
+
+func deriveKey(key []byte, purpose string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("field-encryption-" + purpose))
+	return mac.Sum(nil)
+}
+
+// encrypt encrypts the value with the primary key
+func (k *Keyring) encrypt(value string, deterministic bool) (string, error) {
+	key := k.keys[k.primary]
+	nonce := make([]byte, key.aead.NonceSize())
+	mode := randomMode
+	if deterministic {
+		mac := hmac.New(sha256.New, key.nonceKey)
+		mac.Write([]byte(value))
+		copy(nonce, mac.Sum(nil))
+		mode = deterministicMode
+	} else if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := key.aead.Seal(nonce, nonce, []byte(value), nil)
+	return encryptedPrefix + mode + ":" + k.primary + ":" + base64.RawStdEncoding.EncodeToString(sealed), nil
+}
+
+// decrypt returns the plaintext of the encrypted value, plaintext values are returned unchanged
+func (k *Keyring) decrypt(value string) (string, error) {
+	rest, ok := strings.CutPrefix(value, encryptedPrefix)
+	if !ok {
+		return value, nil
+	}
+	parts := strings.SplitN(rest, ":", 3)
+	if len(parts) != 3 || (parts[0] != randomMode && parts[0] != deterministicMode) {
+		return value, nil
+	}
+	sealed, err := base64.RawStdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return value, nil
+	}
+	key, ok := k.keys[parts[1]]
+	if !ok {
+		return "", fmt.Errorf("value encrypted with key %v missing in the keyring", parts[1])
+	}
+	nonceSize := key.aead.NonceSize()
+	if len(sealed) < nonceSize {
+		return "", fmt.Errorf("malformed encrypted value")
+	}
+	plaintext, err := key.aead.Open(nil, sealed[:nonceSize], sealed[nonceSize:], nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to decrypt value with key %v: %w", parts[1], err)
+	}
+	return string(plaintext), nil
+}
+
+// current checks whether the value is encrypted with the primary key in the mode
+func (k *Keyring) current(value string, deterministic bool) bool {
+	mode := randomMode
+	if deterministic {
+		mode = deterministicMode
+	}
+	return strings.HasPrefix(value, encryptedPrefix+mode+":"+k.primary+":")
+}
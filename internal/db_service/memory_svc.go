@@ -0,0 +1,344 @@
+package db_service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+)
+
+type MemoryServiceConfig struct {
+	// SeedFile is an optional path to a JSON array of documents loaded at startup
+	SeedFile string
+}
+
+// memorySvc keeps documents serialized in memory, so every read and write works
+// with a deep copy and callers can never modify the stored state by accident.
+type memorySvc[DocType interface{}] struct {
+	MemoryServiceConfig
+	lock      sync.RWMutex
+	documents map[string][]byte
+	// ids keeps the insertion order of documents for ListDocuments
+	ids []string
+}
+
+// NewMemoryService creates the storage loaded with the documents of the seed file, a seed file
+// that cannot be loaded fails the creation, so that the service does not start with missing data
+func NewMemoryService[DocType interface{}](config MemoryServiceConfig) (DbService[DocType], error) {
+	svc := &memorySvc[DocType]{
+		documents: map[string][]byte{},
+	}
+	svc.MemoryServiceConfig = config
+
+	if svc.SeedFile != "" {
+		if err := svc.seed(svc.SeedFile); err != nil {
+			return nil, fmt.Errorf("failed to load seed file %v: %w", svc.SeedFile, err)
+		}
+	}
+
+	log.Printf("In-memory storage with %v seeded documents", len(svc.ids))
+	return svc, nil
+}
+
+func (m *memorySvc[DocType]) seed(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var documents []json.RawMessage
+	if err := json.Unmarshal(content, &documents); err != nil {
+		return err
+	}
+	for _, raw := range documents {
+		id, err := documentId(raw)
+		if err != nil {
+			return err
+		}
+		// round trip through DocType so that the stored form matches the written one
+		var document DocType
+		if err := json.Unmarshal(raw, &document); err != nil {
+			return err
+		}
+		if err := m.CreateDocument(context.Background(), id, &document); err != nil {
+			return fmt.Errorf("document %v: %w", id, err)
+		}
+	}
+	return nil
+}
+
+func (m *memorySvc[DocType]) Disconnect(ctx context.Context) error {
+	return nil
+}
+
+func (m *memorySvc[DocType]) CreateDocument(ctx context.Context, id string, document *DocType) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.documents[id]; exists {
+		return ErrConflict
+	}
+	m.store(id, data)
+	return nil
+}
+
+func (m *memorySvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	m.lock.RLock()
+	data, exists := m.documents[id]
+	m.lock.RUnlock()
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return decodeDocument[DocType](data)
+}
+
+func (m *memorySvc[DocType]) FindDocumentWith(ctx context.Context, id string, options ReadOptions) (*DocType, error) {
+	m.lock.RLock()
+	data, exists := m.documents[id]
+	m.lock.RUnlock()
+	if !exists {
+		return nil, ErrNotFound
+	}
+	data, err := options.projectJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	return decodeDocument[DocType](data)
+}
+
+func (m *memorySvc[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.documents[id]; !exists {
+		return ErrNotFound
+	}
+	m.store(id, data)
+	return nil
+}
+
+func (m *memorySvc[DocType]) CompareAndSwapDocument(ctx context.Context, id string, expectedVersion int64, document *DocType) error {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	stored, exists := m.documents[id]
+	if !exists {
+		return ErrNotFound
+	}
+	version, err := documentVersion(stored)
+	if err != nil {
+		return err
+	}
+	if version != expectedVersion {
+		return ErrVersionMismatch
+	}
+	m.store(id, data)
+	return nil
+}
+
+func (m *memorySvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, exists := m.documents[id]; !exists {
+		return ErrNotFound
+	}
+	m.remove(id)
+	return nil
+}
+
+func (m *memorySvc[DocType]) ListDocuments(ctx context.Context) ([]DocType, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var results []DocType
+	for _, id := range m.ids {
+		document, err := decodeDocument[DocType](m.documents[id])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *document)
+	}
+	return results, nil
+}
+
+func (m *memorySvc[DocType]) FindDocuments(ctx context.Context, query Query) ([]DocType, error) {
+	m.lock.RLock()
+	documents := make([][]byte, 0, len(m.ids))
+	for _, id := range m.ids {
+		documents = append(documents, m.documents[id])
+	}
+	m.lock.RUnlock()
+
+	selected, err := query.selectJSON(documents)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []DocType
+	for _, data := range selected {
+		document, err := decodeDocument[DocType](data)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, *document)
+	}
+	return results, nil
+}
+
+func (m *memorySvc[DocType]) CountDocuments(ctx context.Context, query Query) (int64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var count int64
+	for _, id := range m.ids {
+		matches, err := query.matchesJSON(m.documents[id])
+		if err != nil {
+			return 0, err
+		}
+		if matches {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *memorySvc[DocType]) CountDocumentsBy(ctx context.Context, query Query, field string) (map[string]int64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	counts := map[string]int64{}
+	for _, id := range m.ids {
+		value, ok, err := query.groupJSON(m.documents[id], field)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			counts[value]++
+		}
+	}
+	return counts, nil
+}
+
+func (m *memorySvc[DocType]) DeleteDocuments(ctx context.Context, query Query) (int64, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	var matching []string
+	for _, id := range m.ids {
+		matches, err := query.matchesJSON(m.documents[id])
+		if err != nil {
+			return 0, err
+		}
+		if matches {
+			matching = append(matching, id)
+		}
+	}
+	for _, id := range matching {
+		m.remove(id)
+	}
+	return int64(len(matching)), nil
+}
+
+// UpdateDocuments holds the write lock while the updater runs, so the updater
+// must not call back into the service.
+func (m *memorySvc[DocType]) UpdateDocuments(ctx context.Context, ids []string, updater DocumentsUpdater[DocType]) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	originals := make([]*DocType, len(ids))
+	for i, id := range ids {
+		if data, exists := m.documents[id]; exists {
+			document, err := decodeDocument[DocType](data)
+			if err != nil {
+				return err
+			}
+			originals[i] = document
+		}
+	}
+
+	updated, err := applyUpdater(ids, originals, updater)
+	if err != nil {
+		return err
+	}
+
+	// serialize everything first, so that a failure leaves the storage untouched
+	serialized := make([][]byte, len(ids))
+	for i, document := range updated {
+		if document == nil {
+			continue
+		}
+		if serialized[i], err = json.Marshal(document); err != nil {
+			return err
+		}
+	}
+
+	for i, id := range ids {
+		switch {
+		case serialized[i] != nil:
+			m.store(id, serialized[i])
+		case originals[i] != nil:
+			m.remove(id)
+		}
+	}
+	return nil
+}
+
+// store must be called with the write lock held
+func (m *memorySvc[DocType]) store(id string, data []byte) {
+	if _, exists := m.documents[id]; !exists {
+		m.ids = append(m.ids, id)
+	}
+	m.documents[id] = data
+}
+
+// remove must be called with the write lock held
+func (m *memorySvc[DocType]) remove(id string) {
+	delete(m.documents, id)
+	m.ids = slices.DeleteFunc(m.ids, func(candidate string) bool {
+		return candidate == id
+	})
+}
+
+func decodeDocument[DocType interface{}](data []byte) (*DocType, error) {
+	var document DocType
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+	return &document, nil
+}
+
+// documentId reads the id field of the serialized document
+func documentId(data []byte) (string, error) {
+	var header struct {
+		Id string `json:"id"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return "", err
+	}
+	if header.Id == "" {
+		return "", fmt.Errorf("document has no id")
+	}
+	return header.Id, nil
+}
+
+// documentVersion reads the version field of the serialized document, 0 if it is missing
+func documentVersion(data []byte) (int64, error) {
+	var header struct {
+		Version int64 `json:"version"`
+	}
+	if err := json.Unmarshal(data, &header); err != nil {
+		return 0, err
+	}
+	return header.Version, nil
+}
@@ -0,0 +1,73 @@
+package db_service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type testDocument struct {
+	Id      string     `json:"id"`
+	Name    string     `json:"name"`
+	Rank    int        `json:"rank,omitempty"`
+	Tags    []string   `json:"tags,omitempty"`
+	Owner   testOwner  `json:"owner"`
+	Items   []testItem `json:"items,omitempty"`
+	Version int64      `json:"version,omitempty"`
+}
+
+type testItem struct {
+	Id    string `json:"id"`
+	Value string `json:"value"`
+}
+
+type testOwner struct {
+	GroupId string `json:"groupId"`
+}
+
+func newTestMemoryService(t *testing.T) DbService[testDocument] {
+	svc, err := NewMemoryService[testDocument](MemoryServiceConfig{})
+	require.NoError(t, err)
+	return svc
+}
+
+func TestMemoryService_SeedFile_DocumentsLoaded(t *testing.T) {
+	seedFile := filepath.Join(t.TempDir(), "seed.json")
+	require.NoError(t, os.WriteFile(seedFile, []byte(`[{"id": "a", "name": "A"}, {"id": "b", "name": "B"}]`), 0o600))
+
+	svc, err := NewMemoryService[testDocument](MemoryServiceConfig{SeedFile: seedFile})
+	require.NoError(t, err)
+
+	documents, err := svc.ListDocuments(t.Context())
+	require.NoError(t, err)
+	assert.Equal(t, []testDocument{{Id: "a", Name: "A"}, {Id: "b", Name: "B"}}, documents)
+}
+
+func TestMemoryService_InvalidSeedFile_Failed(t *testing.T) {
+	seedFile := filepath.Join(t.TempDir(), "seed.json")
+	require.NoError(t, os.WriteFile(seedFile, []byte(`[{"id": "a", "name": "A"}, {"id": "a", "name": "B"}]`), 0o600))
+
+	_, err := NewMemoryService[testDocument](MemoryServiceConfig{SeedFile: seedFile})
+	assert.ErrorIs(t, err, ErrConflict)
+
+	_, err = NewMemoryService[testDocument](MemoryServiceConfig{SeedFile: filepath.Join(t.TempDir(), "missing.json")})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestMemoryService_ReturnedDocument_IsCopy(t *testing.T) {
+	svc := newTestMemoryService(t)
+	document := &testDocument{Id: "a", Tags: []string{"x"}}
+	require.NoError(t, svc.CreateDocument(t.Context(), "a", document))
+
+	document.Tags[0] = "changed"
+	found, err := svc.FindDocument(t.Context(), "a")
+	require.NoError(t, err)
+	found.Tags[0] = "changed too"
+
+	found, err = svc.FindDocument(t.Context(), "a")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"x"}, found.Tags)
+}
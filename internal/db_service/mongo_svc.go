@@ -2,10 +2,14 @@ package db_service
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"os"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -18,14 +22,39 @@ import (
 type DbService[DocType interface{}] interface {
 	CreateDocument(ctx context.Context, id string, document *DocType) error
 	FindDocument(ctx context.Context, id string) (*DocType, error)
+	// FindDocumentWith returns the document narrowed by the read options
+	FindDocumentWith(ctx context.Context, id string, options ReadOptions) (*DocType, error)
 	UpdateDocument(ctx context.Context, id string, document *DocType) error
+	// CompareAndSwapDocument replaces the document only if its stored version equals expectedVersion,
+	// otherwise ErrVersionMismatch is returned. The caller is responsible for setting the new version.
+	CompareAndSwapDocument(ctx context.Context, id string, expectedVersion int64, document *DocType) error
 	DeleteDocument(ctx context.Context, id string) error
 	Disconnect(ctx context.Context) error
 	ListDocuments(ctx context.Context) ([]DocType, error)
+	// FindDocuments returns the page of sorted and projected documents matching the query
+	FindDocuments(ctx context.Context, query Query) ([]DocType, error)
+	// CountDocuments returns the number of documents matching the query, ignoring its sorting and paging
+	CountDocuments(ctx context.Context, query Query) (int64, error)
+	// CountDocumentsBy returns the number of documents matching the query for each string value
+	// of the field, ignoring its sorting and paging. Documents without such a value are not counted.
+	CountDocumentsBy(ctx context.Context, query Query, field string) (map[string]int64, error)
+	// DeleteDocuments removes all documents matching the query and returns their count
+	DeleteDocuments(ctx context.Context, query Query) (int64, error)
+	// UpdateDocuments atomically applies the updater to the documents with the given ids.
+	// The updater receives the current documents in the order of ids, nil for missing ones,
+	// and returns their new state, where nil removes the document or leaves it absent.
+	// Either all returned documents are stored or none of them; an updater error aborts the update.
+	UpdateDocuments(ctx context.Context, ids []string, updater DocumentsUpdater[DocType]) error
 }
 
+type DocumentsUpdater[DocType interface{}] func(documents []*DocType) ([]*DocType, error)
+
 var ErrNotFound = fmt.Errorf("document not found")
 var ErrConflict = fmt.Errorf("conflict: document already exists")
+var ErrVersionMismatch = fmt.Errorf("conflict: document was modified concurrently")
+
+// VersionField is the name of the document field holding the document revision
+const VersionField = "version"
 
 type MongoServiceConfig struct {
 	ServerHost string
@@ -35,6 +64,11 @@ type MongoServiceConfig struct {
 	DbName     string
 	Collection string
 	Timeout    time.Duration
+	// Indexes lists JSON field paths to be indexed in addition to the id field
+	Indexes []string
+	// ExpireAfterField is the JSON field path of a date, if set the documents are removed
+	// by MongoDB once the date has passed
+	ExpireAfterField string
 }
 
 type mongoSvc[DocType interface{}] struct {
@@ -43,6 +77,73 @@ type mongoSvc[DocType interface{}] struct {
 	clientLock sync.Mutex
 }
 
+// bsonPath translates JSON field path to the document field path. Documents are stored with
+// the default bson naming - lower cased Go field names, which are the lower cased JSON names.
+func bsonPath(path string) string {
+	return strings.ToLower(path)
+}
+
+func (q Query) mongoFilter() bson.D {
+	conditions := map[string]bson.D{}
+	for path, value := range q.Equal {
+		conditions[path] = append(conditions[path], bson.E{Key: "$eq", Value: value})
+	}
+	for path, values := range q.In {
+		conditions[path] = append(conditions[path], bson.E{Key: "$in", Value: bson.A(values)})
+	}
+	for path, value := range q.GreaterOrEqual {
+		conditions[path] = append(conditions[path], bson.E{Key: "$gte", Value: value})
+	}
+	for path, value := range q.Less {
+		conditions[path] = append(conditions[path], bson.E{Key: "$lt", Value: value})
+	}
+	for path, substring := range q.Contains {
+		conditions[path] = append(conditions[path],
+			bson.E{Key: "$regex", Value: regexp.QuoteMeta(substring)},
+			bson.E{Key: "$options", Value: "i"})
+	}
+
+	paths := make([]string, 0, len(conditions))
+	for path := range conditions {
+		paths = append(paths, path)
+	}
+	slices.Sort(paths)
+
+	filter := bson.D{}
+	for _, path := range paths {
+		filter = append(filter, bson.E{Key: bsonPath(path), Value: conditions[path]})
+	}
+	return filter
+}
+
+func (q Query) mongoFindOptions() *options.FindOptions {
+	findOptions := options.Find()
+	if len(q.Sort) > 0 || q.Skip > 0 || q.Limit > 0 {
+		sort := bson.D{}
+		for _, field := range q.Sort {
+			path, descending := strings.CutPrefix(field, "-")
+			direction := 1
+			if descending {
+				direction = -1
+			}
+			sort = append(sort, bson.E{Key: bsonPath(path), Value: direction})
+		}
+		// ties, or the whole result if not sorted, are ordered by id, so that pages do not overlap
+		sort = append(sort, bson.E{Key: "_id", Value: 1})
+		findOptions.SetSort(sort)
+	}
+	if q.Skip > 0 {
+		findOptions.SetSkip(q.Skip)
+	}
+	if q.Limit > 0 {
+		findOptions.SetLimit(q.Limit)
+	}
+	if len(q.Fields) > 0 {
+		findOptions.SetProjection(ReadOptions{Fields: q.Fields}.mongoProjection())
+	}
+	return findOptions
+}
+
 func NewMongoService[DocType interface{}](config MongoServiceConfig) DbService[DocType] {
 	enviro := func(name string, defaultValue string) string {
 		if value, ok := os.LookupEnv(name); ok {
@@ -133,11 +234,79 @@ func (m *mongoSvc[DocType]) connect(ctx context.Context) (*mongo.Client, error)
 	if client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri).SetConnectTimeout(10*time.Second)); err != nil {
 		return nil, err
 	} else {
+		if err := m.ensureIndexes(ctx, client); err != nil {
+			// the next call connects again and retries the creation of the indexes
+			if err := client.Disconnect(ctx); err != nil {
+				log.Printf("Failed to disconnect from MongoDB: %v", err)
+			}
+			return nil, err
+		}
 		m.client.Store(client)
 		return client, nil
 	}
 }
 
+// ensureIndexes creates the indexes of the collection, the service refuses to work without
+// the unique id index only
+func (m *mongoSvc[DocType]) ensureIndexes(ctx context.Context, client *mongo.Client) error {
+	collection := client.Database(m.DbName).Collection(m.Collection)
+	if err := m.ensureUniqueIdIndex(ctx, collection); err != nil {
+		return fmt.Errorf("failed to create unique id index of collection %v: %w", m.Collection, err)
+	}
+
+	models := []mongo.IndexModel{}
+	for _, path := range m.Indexes {
+		models = append(models, mongo.IndexModel{Keys: bson.D{{Key: bsonPath(path), Value: 1}}})
+	}
+	if m.ExpireAfterField != "" {
+		models = append(models, mongo.IndexModel{
+			Keys:    bson.D{{Key: bsonPath(m.ExpireAfterField), Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(0),
+		})
+	}
+	if len(models) == 0 {
+		return nil
+	}
+	if _, err := collection.Indexes().CreateMany(ctx, models); err != nil {
+		// the service still works without indexes, only slower
+		log.Printf("Failed to create indexes of collection %v: %v", m.Collection, err)
+	}
+	return nil
+}
+
+// ensureUniqueIdIndex lets the server reject a second document with the same id, so that
+// concurrent creations cannot both succeed. Collections created before the index was unique
+// have a plain index on the id, which is replaced.
+func (m *mongoSvc[DocType]) ensureUniqueIdIndex(ctx context.Context, collection *mongo.Collection) error {
+	model := mongo.IndexModel{Keys: bson.D{{Key: "id", Value: 1}}, Options: options.Index().SetUnique(true)}
+	_, err := collection.Indexes().CreateOne(ctx, model)
+	if isIndexConflict(err) {
+		if _, err = collection.Indexes().DropOne(ctx, "id_1"); err == nil {
+			_, err = collection.Indexes().CreateOne(ctx, model)
+		}
+	}
+	return err
+}
+
+// isIndexConflict detects the IndexOptionsConflict and IndexKeySpecsConflict errors returned
+// for an index differing from the existing one only in its options
+func isIndexConflict(err error) bool {
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) {
+		return commandErr.Code == 85 || commandErr.Code == 86
+	}
+	return false
+}
+
+// insertDocument maps the duplicate key error of the unique id index to ErrConflict
+func insertDocument(ctx context.Context, collection *mongo.Collection, document interface{}) error {
+	_, err := collection.InsertOne(ctx, document)
+	if mongo.IsDuplicateKeyError(err) {
+		return ErrConflict
+	}
+	return err
+}
+
 func (m *mongoSvc[DocType]) Disconnect(ctx context.Context) error {
 	client := m.client.Load()
 
@@ -165,21 +334,35 @@ func (m *mongoSvc[DocType]) CreateDocument(ctx context.Context, id string, docum
 	}
 	db := client.Database(m.DbName)
 	collection := db.Collection(m.Collection)
+	// the unique id index rejects the document atomically if it already exists
+	return insertDocument(ctx, collection, document)
+}
+
+func (m *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
 	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
 	switch result.Err() {
-	case nil: // no error means there is conflicting document
-		return ErrConflict
+	case nil:
 	case mongo.ErrNoDocuments:
-		// do nothing, this is expected
+		return nil, ErrNotFound
 	default: // other errors - return them
-		return result.Err()
+		return nil, result.Err()
 	}
-
-	_, err = collection.InsertOne(ctx, document)
-	return err
+	var document *DocType
+	if err := result.Decode(&document); err != nil {
+		return nil, err
+	}
+	return document, nil
 }
 
-func (m *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+func (m *mongoSvc[DocType]) FindDocumentWith(ctx context.Context, id string, readOptions ReadOptions) (*DocType, error) {
 	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
 	defer contextCancel()
 	client, err := m.connect(ctx)
@@ -188,7 +371,12 @@ func (m *mongoSvc[DocType]) FindDocument(ctx context.Context, id string) (*DocTy
 	}
 	db := client.Database(m.DbName)
 	collection := db.Collection(m.Collection)
-	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
+
+	findOptions := options.FindOne()
+	if readOptions.projected() {
+		findOptions.SetProjection(readOptions.mongoProjection())
+	}
+	result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}}, findOptions)
 	switch result.Err() {
 	case nil:
 	case mongo.ErrNoDocuments:
@@ -224,6 +412,49 @@ func (m *mongoSvc[DocType]) UpdateDocument(ctx context.Context, id string, docum
 	return err
 }
 
+// versionFilter selects the document with the id only in the given version
+func versionFilter(id string, version int64) bson.D {
+	if version == 0 {
+		// documents stored before versioning was introduced have no version field
+		return bson.D{
+			{Key: "id", Value: id},
+			{Key: "$or", Value: bson.A{
+				bson.D{{Key: VersionField, Value: 0}},
+				bson.D{{Key: VersionField, Value: bson.D{{Key: "$exists", Value: false}}}},
+			}},
+		}
+	}
+	return bson.D{{Key: "id", Value: id}, {Key: VersionField, Value: version}}
+}
+
+func (m *mongoSvc[DocType]) CompareAndSwapDocument(ctx context.Context, id string, expectedVersion int64, document *DocType) error {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return err
+	}
+	db := client.Database(m.DbName)
+	collection := db.Collection(m.Collection)
+
+	result, err := collection.ReplaceOne(ctx, versionFilter(id, expectedVersion), document)
+	if err != nil {
+		return err
+	}
+	if result.MatchedCount == 0 {
+		count, err := collection.CountDocuments(ctx, bson.D{{Key: "id", Value: id}})
+		switch {
+		case err != nil:
+			return err
+		case count == 0:
+			return ErrNotFound
+		default:
+			return ErrVersionMismatch
+		}
+	}
+	return nil
+}
+
 func (m *mongoSvc[DocType]) DeleteDocument(ctx context.Context, id string) error {
 	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
 	defer contextCancel()
@@ -276,3 +507,249 @@ func (m *mongoSvc[DocType]) ListDocuments(ctx context.Context) ([]DocType, error
 	}
 	return results, nil
 }
+
+func (m *mongoSvc[DocType]) FindDocuments(ctx context.Context, query Query) ([]DocType, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Database(m.DbName).Collection(m.Collection)
+
+	cursor, err := collection.Find(ctx, query.mongoFilter(), query.mongoFindOptions())
+	if err != nil {
+		return nil, err
+	}
+	defer cursor.Close(ctx)
+
+	var results []DocType
+	if err := cursor.All(ctx, &results); err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func (m *mongoSvc[DocType]) CountDocuments(ctx context.Context, query Query) (int64, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	collection := client.Database(m.DbName).Collection(m.Collection)
+	return collection.CountDocuments(ctx, query.mongoFilter())
+}
+
+func (m *mongoSvc[DocType]) CountDocumentsBy(ctx context.Context, query Query, field string) (map[string]int64, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	collection := client.Database(m.DbName).Collection(m.Collection)
+
+	cursor, err := collection.Aggregate(ctx, mongo.Pipeline{
+		{{Key: "$match", Value: query.mongoFilter()}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$" + bsonPath(field)},
+			{Key: "count", Value: bson.D{{Key: "$sum", Value: 1}}},
+		}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	var groups []struct {
+		Value interface{} `bson:"_id"`
+		Count int64       `bson:"count"`
+	}
+	if err := cursor.All(ctx, &groups); err != nil {
+		return nil, err
+	}
+	counts := make(map[string]int64, len(groups))
+	for _, group := range groups {
+		if value, ok := group.Value.(string); ok {
+			counts[value] = group.Count
+		}
+	}
+	return counts, nil
+}
+
+func (m *mongoSvc[DocType]) DeleteDocuments(ctx context.Context, query Query) (int64, error) {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return 0, err
+	}
+	collection := client.Database(m.DbName).Collection(m.Collection)
+
+	result, err := collection.DeleteMany(ctx, query.mongoFilter())
+	if err != nil {
+		return 0, err
+	}
+	return result.DeletedCount, nil
+}
+
+func (m *mongoSvc[DocType]) UpdateDocuments(ctx context.Context, ids []string, updater DocumentsUpdater[DocType]) error {
+	ctx, contextCancel := context.WithTimeout(ctx, m.Timeout)
+	defer contextCancel()
+	client, err := m.connect(ctx)
+	if err != nil {
+		return err
+	}
+	collection := client.Database(m.DbName).Collection(m.Collection)
+
+	session, err := client.StartSession()
+	if err != nil {
+		return err
+	}
+	defer session.EndSession(ctx)
+
+	_, err = session.WithTransaction(ctx, func(sessionCtx mongo.SessionContext) (interface{}, error) {
+		originals, err := m.loadDocuments(sessionCtx, collection, ids)
+		if err != nil {
+			return nil, err
+		}
+		updated, err := applyUpdater(ids, originals, updater)
+		if err != nil {
+			return nil, err
+		}
+		for i, id := range ids {
+			if err := m.storeDocument(sessionCtx, collection, id, originals[i], updated[i]); err != nil {
+				return nil, err
+			}
+		}
+		return nil, nil
+	})
+	if isTransactionNotSupported(err) {
+		log.Printf("Transactions are not supported by the server, using compensating updates")
+		return m.updateDocumentsWithCompensation(ctx, collection, ids, updater)
+	}
+	return err
+}
+
+// updateDocumentsWithCompensation is used on standalone servers without transaction support.
+// Documents are stored one by one and already stored documents are restored if a later one fails,
+// a document changed concurrently since it was loaded fails the update with ErrVersionMismatch.
+func (m *mongoSvc[DocType]) updateDocumentsWithCompensation(
+	ctx context.Context,
+	collection *mongo.Collection,
+	ids []string,
+	updater DocumentsUpdater[DocType],
+) error {
+	originals, err := m.loadDocuments(ctx, collection, ids)
+	if err != nil {
+		return err
+	}
+	updated, err := applyUpdater(ids, originals, updater)
+	if err != nil {
+		return err
+	}
+	for i, id := range ids {
+		if err := m.storeDocument(ctx, collection, id, originals[i], updated[i]); err != nil {
+			for j := i - 1; j >= 0; j-- {
+				if restoreErr := m.storeDocument(ctx, collection, ids[j], updated[j], originals[j]); restoreErr != nil {
+					log.Printf("Failed to restore document %v after failed update: %v", ids[j], restoreErr)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mongoSvc[DocType]) loadDocuments(ctx context.Context, collection *mongo.Collection, ids []string) ([]*DocType, error) {
+	documents := make([]*DocType, len(ids))
+	for i, id := range ids {
+		result := collection.FindOne(ctx, bson.D{{Key: "id", Value: id}})
+		switch result.Err() {
+		case nil:
+		case mongo.ErrNoDocuments:
+			continue
+		default: // other errors - return them
+			return nil, result.Err()
+		}
+		if err := result.Decode(&documents[i]); err != nil {
+			return nil, err
+		}
+	}
+	return documents, nil
+}
+
+// storeDocument moves the stored document from the original state to the updated one. Without
+// a transaction the document may change in between, so it is stored only if it is still in the
+// original version, otherwise ErrVersionMismatch is returned.
+func (m *mongoSvc[DocType]) storeDocument(ctx context.Context, collection *mongo.Collection, id string, original *DocType, updated *DocType) error {
+	if original == nil {
+		if updated == nil {
+			return nil
+		}
+		err := insertDocument(ctx, collection, updated)
+		if err == ErrConflict {
+			// created concurrently
+			return ErrVersionMismatch
+		}
+		return err
+	}
+
+	version, err := bsonVersion(original)
+	if err != nil {
+		return err
+	}
+	var matched int64
+	if updated == nil {
+		result, err := collection.DeleteOne(ctx, versionFilter(id, version))
+		if err != nil {
+			return err
+		}
+		matched = result.DeletedCount
+	} else {
+		result, err := collection.ReplaceOne(ctx, versionFilter(id, version), updated)
+		if err != nil {
+			return err
+		}
+		matched = result.MatchedCount
+	}
+	if matched == 0 {
+		return ErrVersionMismatch
+	}
+	return nil
+}
+
+// bsonVersion reads the version field of the document as stored, missing version is zero
+func bsonVersion[DocType interface{}](document *DocType) (int64, error) {
+	data, err := bson.Marshal(document)
+	if err != nil {
+		return 0, err
+	}
+	value, err := bson.Raw(data).LookupErr(VersionField)
+	if err != nil {
+		return 0, nil
+	}
+	if version, ok := value.AsInt64OK(); ok {
+		return version, nil
+	}
+	return 0, fmt.Errorf("version of document is not an integer: %v", value)
+}
+
+func applyUpdater[DocType interface{}](ids []string, documents []*DocType, updater DocumentsUpdater[DocType]) ([]*DocType, error) {
+	updated, err := updater(documents)
+	if err != nil {
+		return nil, err
+	}
+	if len(updated) != len(ids) {
+		return nil, fmt.Errorf("updater returned %v documents, expected %v", len(updated), len(ids))
+	}
+	return updated, nil
+}
+
+// isTransactionNotSupported detects the IllegalOperation error returned by standalone servers
+func isTransactionNotSupported(err error) bool {
+	var commandErr mongo.CommandError
+	if errors.As(err, &commandErr) {
+		return commandErr.Code == 20
+	}
+	return false
+}
@@ -0,0 +1,15 @@
+package db_service
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestMongoFindOptions_PagesOrderedById(t *testing.T) {
+	assert.Nil(t, Query{}.mongoFindOptions().Sort)
+	assert.Equal(t, bson.D{{Key: "_id", Value: 1}}, Query{Limit: 10}.mongoFindOptions().Sort)
+	assert.Equal(t, bson.D{{Key: "_id", Value: 1}}, Query{Skip: 10}.mongoFindOptions().Sort)
+	assert.Equal(t, bson.D{{Key: "name", Value: -1}, {Key: "_id", Value: 1}}, Query{Sort: []string{"-name"}}.mongoFindOptions().Sort)
+}
@@ -0,0 +1,300 @@
+package db_service
+
+import (
+	"encoding/json"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Query selects documents by their fields. Fields are addressed by dot separated
+// paths of JSON field names, for example "entry.role.code".
+type Query struct {
+	// Equal requires the fields to have exactly the given values. Like in MongoDB, paths crossing
+	// arrays match if any of the elements has the value.
+	Equal map[string]interface{}
+
+	// In requires the fields to have one of the given values, paths crossing arrays match like in Equal.
+	// An empty list of values matches no document.
+	In map[string][]interface{}
+
+	// GreaterOrEqual requires the fields to be greater than or equal to the given values
+	GreaterOrEqual map[string]interface{}
+
+	// Less requires the fields to be less than the given values
+	Less map[string]interface{}
+
+	// Contains requires the string fields to contain the given substrings, ignoring case
+	Contains map[string]string
+
+	// Sort lists the fields to order the documents by, a field prefixed with "-" is sorted
+	// in descending order. Documents are returned in the storage order if not set.
+	Sort []string
+
+	// Skip and Limit select a page of the sorted documents, zero Limit means no limit
+	Skip  int64
+	Limit int64
+
+	// Fields lists dot separated paths of the fields to return, documents are returned complete if empty
+	Fields []string
+}
+
+// matchesJSON evaluates the query against a JSON serialized document,
+// it is used by the implementations storing documents as JSON.
+func (q Query) matchesJSON(data []byte) (bool, error) {
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return false, err
+	}
+	return q.matches(document)
+}
+
+// groupJSON returns the string value of the field of a JSON serialized document matching the query,
+// it is used to count the documents by the field
+func (q Query) groupJSON(data []byte, field string) (string, bool, error) {
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return "", false, err
+	}
+	matches, err := q.matches(document)
+	if err != nil || !matches {
+		return "", false, err
+	}
+	value, _ := lookupJSONPath(document, field)
+	text, ok := value.(string)
+	return text, ok, nil
+}
+
+func (q Query) matches(document interface{}) (bool, error) {
+	for path, value := range q.Equal {
+		expected, err := jsonValue(value)
+		if err != nil {
+			return false, err
+		}
+		actual := lookupJSONValues(document, path)
+		if len(actual) == 0 {
+			// missing fields equal null
+			actual = []interface{}{nil}
+		}
+		if !slices.ContainsFunc(actual, func(value interface{}) bool { return reflect.DeepEqual(value, expected) }) {
+			return false, nil
+		}
+	}
+	for path, values := range q.In {
+		actual := lookupJSONValues(document, path)
+		if len(actual) == 0 {
+			actual = []interface{}{nil}
+		}
+		matched := false
+		for _, value := range values {
+			expected, err := jsonValue(value)
+			if err != nil {
+				return false, err
+			}
+			if slices.ContainsFunc(actual, func(value interface{}) bool { return reflect.DeepEqual(value, expected) }) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false, nil
+		}
+	}
+	for path, value := range q.GreaterOrEqual {
+		bound, err := jsonValue(value)
+		if err != nil {
+			return false, err
+		}
+		actual, ok := lookupJSONPath(document, path)
+		// like in MongoDB, only values of the same type are compared
+		if !ok || jsonTypeOrder(actual) != jsonTypeOrder(bound) || compareJSON(actual, bound) < 0 {
+			return false, nil
+		}
+	}
+	for path, value := range q.Less {
+		bound, err := jsonValue(value)
+		if err != nil {
+			return false, err
+		}
+		actual, ok := lookupJSONPath(document, path)
+		if !ok || jsonTypeOrder(actual) != jsonTypeOrder(bound) || compareJSON(actual, bound) >= 0 {
+			return false, nil
+		}
+	}
+	for path, substring := range q.Contains {
+		actual, _ := lookupJSONPath(document, path)
+		text, ok := actual.(string)
+		if !ok || !strings.Contains(strings.ToLower(text), strings.ToLower(substring)) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// selectJSON applies the query, including sorting, paging and projection, to the JSON serialized documents
+func (q Query) selectJSON(documents [][]byte) ([][]byte, error) {
+	type candidate struct {
+		data     []byte
+		document interface{}
+	}
+
+	var selected []candidate
+	for _, data := range documents {
+		var document interface{}
+		if err := json.Unmarshal(data, &document); err != nil {
+			return nil, err
+		}
+		matches, err := q.matches(document)
+		if err != nil {
+			return nil, err
+		}
+		if matches {
+			selected = append(selected, candidate{data, document})
+		}
+	}
+
+	if len(q.Sort) > 0 {
+		sort.SliceStable(selected, func(i, j int) bool {
+			for _, field := range q.Sort {
+				path, descending := strings.CutPrefix(field, "-")
+				left, _ := lookupJSONPath(selected[i].document, path)
+				right, _ := lookupJSONPath(selected[j].document, path)
+				order := compareJSON(left, right)
+				if descending {
+					order = -order
+				}
+				if order != 0 {
+					return order < 0
+				}
+			}
+			return false
+		})
+	}
+
+	start := min(q.Skip, int64(len(selected)))
+	end := int64(len(selected))
+	if q.Limit > 0 {
+		end = min(start+q.Limit, end)
+	}
+
+	projection := ReadOptions{Fields: q.Fields}
+	results := make([][]byte, 0, end-start)
+	for _, candidate := range selected[start:end] {
+		data, err := projection.projectJSON(candidate.data)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, data)
+	}
+	return results, nil
+}
+
+// jsonTypeOrder ranks the generic JSON values by type, following the MongoDB comparison order
+func jsonTypeOrder(value interface{}) int {
+	switch value.(type) {
+	case nil:
+		return 0
+	case float64:
+		return 1
+	case string:
+		return 2
+	case map[string]interface{}:
+		return 3
+	case []interface{}:
+		return 4
+	case bool:
+		return 5
+	default:
+		return 6
+	}
+}
+
+// compareJSON orders the generic JSON values, values of different types are ordered by jsonTypeOrder
+func compareJSON(left interface{}, right interface{}) int {
+	if order := jsonTypeOrder(left) - jsonTypeOrder(right); order != 0 {
+		return order
+	}
+	switch left := left.(type) {
+	case float64:
+		right := right.(float64)
+		switch {
+		case left < right:
+			return -1
+		case left > right:
+			return 1
+		}
+	case string:
+		return strings.Compare(left, right.(string))
+	case bool:
+		switch right := right.(bool); {
+		case !left && right:
+			return -1
+		case left && !right:
+			return 1
+		}
+	}
+	return 0
+}
+
+// jsonValue converts the value to its generic JSON form, as produced by json.Unmarshal into interface{}
+func jsonValue(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	var result interface{}
+	err = json.Unmarshal(data, &result)
+	return result, err
+}
+
+// lookupJSONPath resolves the dot separated path in the generic JSON document
+func lookupJSONPath(document interface{}, path string) (interface{}, bool) {
+	current := document
+	for _, segment := range strings.Split(path, ".") {
+		object, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if current, ok = object[segment]; !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// lookupJSONValues resolves the dot separated path in the generic JSON document like lookupJSONPath,
+// but continues into the elements of the arrays on the path. The values found are returned
+// together with the elements of the arrays found.
+func lookupJSONValues(document interface{}, path string) []interface{} {
+	values := []interface{}{document}
+	for _, segment := range strings.Split(path, ".") {
+		var next []interface{}
+		for _, value := range values {
+			next = append(next, jsonFieldValues(value, segment)...)
+		}
+		values = next
+	}
+	for _, value := range values {
+		if array, ok := value.([]interface{}); ok {
+			values = append(values, array...)
+		}
+	}
+	return values
+}
+
+func jsonFieldValues(value interface{}, field string) []interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		if child, ok := value[field]; ok {
+			return []interface{}{child}
+		}
+	case []interface{}:
+		var values []interface{}
+		for _, element := range value {
+			values = append(values, jsonFieldValues(element, field)...)
+		}
+		return values
+	}
+	return nil
+}
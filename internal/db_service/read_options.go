@@ -0,0 +1,65 @@
+package db_service
+
+import (
+	"encoding/json"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// ReadOptions narrows the document returned by FindDocumentWith, so that reading a single
+// item does not transfer the complete document
+type ReadOptions struct {
+	// Fields lists dot separated paths of the fields to return. The document is returned
+	// complete if Fields is not set.
+	Fields []string
+}
+
+func (o ReadOptions) projected() bool {
+	return len(o.Fields) > 0
+}
+
+func (o ReadOptions) mongoProjection() bson.D {
+	projection := bson.D{}
+	for _, field := range o.Fields {
+		projection = append(projection, bson.E{Key: bsonPath(field), Value: 1})
+	}
+	return projection
+}
+
+// projectJSON applies the options to the JSON serialized document,
+// it is used by the implementations storing documents as JSON.
+func (o ReadOptions) projectJSON(data []byte) ([]byte, error) {
+	if !o.projected() {
+		return data, nil
+	}
+
+	var document interface{}
+	if err := json.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	projection := map[string]interface{}{}
+	for _, field := range o.Fields {
+		if value, ok := lookupJSONPath(document, field); ok {
+			setJSONPath(projection, field, value)
+		}
+	}
+	return json.Marshal(projection)
+}
+
+// setJSONPath stores the value at the dot separated path of the generic JSON object,
+// creating the missing parent objects
+func setJSONPath(document map[string]interface{}, path string, value interface{}) {
+	segments := strings.Split(path, ".")
+	parent := document
+	for _, segment := range segments[:len(segments)-1] {
+		child, ok := parent[segment].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			parent[segment] = child
+		}
+		parent = child
+	}
+	parent[segments[len(segments)-1]] = value
+}
@@ -0,0 +1,116 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+)
+
+// legacyActivityDateLayouts are the DD/MM/YY formats of activity dates used by earlier versions
+// of the service, with and without the leading zeros
+var legacyActivityDateLayouts = []string{"02/01/06", "2/1/06"}
+
+// ActivityTime is a point in time of a performance. It is serialized as RFC 3339 timestamp
+// in UTC with second precision, so the serialized values are ordered like the times, and
+// stored as a date in MongoDB. Plain dates and legacy DD/MM/YY dates are accepted on input.
+type ActivityTime struct {
+	time.Time
+
+	// legacy marks values read in the DD/MM/YY format, see MigrateActivityDates
+	legacy bool
+
+	// unparsed keeps a stored text which is not a date at all, so that the document can still be
+	// loaded and storing it back does not lose the text
+	unparsed string
+}
+
+func NewActivityTime(t time.Time) ActivityTime {
+	return ActivityTime{Time: t.UTC().Truncate(time.Second)}
+}
+
+// parseActivityTime reads the timestamp, date or legacy date, empty text is the zero time
+func parseActivityTime(text string) (ActivityTime, error) {
+	if text == "" {
+		return ActivityTime{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return NewActivityTime(t), nil
+	}
+	if t, err := time.Parse(time.DateOnly, text); err == nil {
+		return NewActivityTime(t), nil
+	}
+	for _, layout := range legacyActivityDateLayouts {
+		if t, err := time.Parse(layout, text); err == nil {
+			activityTime := NewActivityTime(t)
+			activityTime.legacy = true
+			return activityTime, nil
+		}
+	}
+	return ActivityTime{}, fmt.Errorf("invalid activity time %q, expected RFC 3339 timestamp or YYYY-MM-DD date", text)
+}
+
+// MarshalJSON serializes the zero time as null, so that it is reported as missing by validation.
+// The unparsed text is kept, so that it survives the storages serializing the documents as JSON.
+func (t ActivityTime) MarshalJSON() ([]byte, error) {
+	if t.unparsed != "" {
+		return json.Marshal(t.unparsed)
+	}
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Truncate(time.Second).Format(time.RFC3339))
+}
+
+// UnmarshalJSON keeps the text which is not a date unparsed like UnmarshalBSONValue, the requests
+// including it are rejected by the validation against the specification
+func (t *ActivityTime) UnmarshalJSON(data []byte) error {
+	var text *string
+	if err := json.Unmarshal(data, &text); err != nil {
+		return err
+	}
+	if text == nil {
+		*t = ActivityTime{}
+		return nil
+	}
+	parsed, err := parseActivityTime(*text)
+	if err != nil {
+		parsed = ActivityTime{unparsed: *text}
+	}
+	*t = parsed
+	return nil
+}
+
+func (t ActivityTime) MarshalBSONValue() (bsontype.Type, []byte, error) {
+	if t.unparsed != "" {
+		return bson.MarshalValue(t.unparsed)
+	}
+	if t.IsZero() {
+		return bson.TypeNull, nil, nil
+	}
+	return bson.MarshalValue(t.UTC().Truncate(time.Second))
+}
+
+// UnmarshalBSONValue reads dates as well as the strings stored by earlier versions of the service.
+// Strings which are not dates are kept unparsed as the zero time, a single malformed value must not
+// prevent loading the other documents.
+func (t *ActivityTime) UnmarshalBSONValue(valueType bsontype.Type, data []byte) error {
+	raw := bson.RawValue{Type: valueType, Value: data}
+	switch valueType {
+	case bson.TypeNull, bson.TypeUndefined:
+		*t = ActivityTime{}
+	case bson.TypeDateTime:
+		*t = NewActivityTime(raw.Time())
+	case bson.TypeString:
+		parsed, err := parseActivityTime(raw.StringValue())
+		if err != nil {
+			parsed = ActivityTime{unparsed: raw.StringValue()}
+		}
+		*t = parsed
+	default:
+		return fmt.Errorf("cannot decode %v into activity time", valueType)
+	}
+	return nil
+}
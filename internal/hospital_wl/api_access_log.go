@@ -0,0 +1,22 @@
+/*
+ * Employee List Api
+ *
+ * Hospital Employee Administration for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xkello@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package hospital_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+type AccessLogAPI interface {
+
+	// GetAccessLog Get /api/access-log/:hospitalId
+	// Provides the accesses to patient data in the hospital
+	GetAccessLog(c *gin.Context)
+}
@@ -32,6 +32,10 @@ type HospitalEmployeeListAPI interface {
 	// Provides details about employee list entry
 	GetEmployeeListEntry(c *gin.Context)
 
+	// PatchEmployeeListEntry Patch /api/employee-list/:hospitalId/entries/:entryId
+	// Partially updates specific entry
+	PatchEmployeeListEntry(c *gin.Context)
+
 	// TransferEmployeeListEntry Post /api/employee-list/:hospitalId/entries/:entryId/transfer
 	// Transfer an employee entry to another hospital
 	TransferEmployeeListEntry(c *gin.Context)
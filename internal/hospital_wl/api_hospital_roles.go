@@ -16,9 +16,23 @@ import (
 
 type HospitalRolesAPI interface {
 
+	// CreateRole Post /api/employee-list/:hospitalId/role
+	// Adds new predefined role to the hospital
+	CreateRole(c *gin.Context)
 
-    // GetRoles Get /api/employee-list/:hospitalId/role
-    // Provides the list of roles associated with hospital 
-     GetRoles(c *gin.Context)
+	// DeleteRole Delete /api/employee-list/:hospitalId/role/:roleCode
+	// Removes predefined role from the hospital
+	DeleteRole(c *gin.Context)
 
-}
\ No newline at end of file
+	// GetRoles Get /api/employee-list/:hospitalId/role
+	// Provides the list of roles associated with hospital
+	GetRoles(c *gin.Context)
+
+	// ReorderRoles Post /api/employee-list/:hospitalId/role/reorder
+	// Changes the order of predefined roles
+	ReorderRoles(c *gin.Context)
+
+	// UpdateRole Put /api/employee-list/:hospitalId/role/:roleCode
+	// Updates predefined role of the hospital
+	UpdateRole(c *gin.Context)
+}
@@ -27,4 +27,16 @@ type HospitalsAPI interface {
 	// GetHospital Get /api/hospital
 	// Provides the hospital list
 	GetHospital(c *gin.Context)
+
+	// GetHospitalDetail Get /api/hospital/:hospitalId
+	// Provides details of specific hospital
+	GetHospitalDetail(c *gin.Context)
+
+	// PatchHospital Patch /api/hospital/:hospitalId
+	// Partially updates specific hospital
+	PatchHospital(c *gin.Context)
+
+	// UpdateHospital Put /api/hospital/:hospitalId
+	// Replaces details of specific hospital
+	UpdateHospital(c *gin.Context)
 }
@@ -0,0 +1,52 @@
+package hospital_wl
+
+import (
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// Store provides the collections the APIs work with
+type Store struct {
+	Hospitals db_service.DbService[Hospital]
+	Employees db_service.DbService[EmployeeDocument]
+	// Performances keeps the performances of the employees, one document per performance
+	Performances db_service.DbService[PerformanceDocument]
+	// Idempotency keeps the responses to requests with the Idempotency-Key header
+	Idempotency db_service.DbService[IdempotencyRecord]
+	// AccessLog keeps the records of the responses including patient data, it is append-only
+	AccessLog db_service.DbService[AccessRecord]
+}
+
+// Clock provides the current time, tests may replace it with a fixed time
+type Clock func() time.Time
+
+// IdGenerator provides unique ids of the resources created without a client provided id
+type IdGenerator func() string
+
+// SystemClock is the clock of the running service
+var SystemClock Clock = time.Now
+
+// UuidGenerator generates random UUIDs, the ids of the running service
+var UuidGenerator IdGenerator = uuid.NewString
+
+// apiDependencies are shared by the implementations of the APIs, the helpers accessing
+// the storage are its methods
+type apiDependencies struct {
+	store  Store
+	clock  Clock
+	newId  IdGenerator
+	logger *log.Logger
+}
+
+func newApiDependencies(store Store, clock Clock, idGenerator IdGenerator, logger *log.Logger) apiDependencies {
+	return apiDependencies{
+		store:  store,
+		clock:  clock,
+		newId:  idGenerator,
+		logger: logger,
+	}
+}
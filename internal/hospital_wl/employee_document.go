@@ -0,0 +1,65 @@
+package hospital_wl
+
+import (
+	"github.com/gin-gonic/gin"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// EmployeeDocument is the stored form of an employee list entry. Employees are kept
+// in their own collection, so that the hospital document does not grow with them.
+type EmployeeDocument struct {
+	// Unique id of the document across all hospitals, see employeeDocumentId
+	Id string `json:"id"`
+
+	// Hospital the employee belongs to
+	HospitalId string `json:"hospitalId"`
+
+	// Entry without its performances, they are stored as PerformanceDocument
+	Entry EmployeeListEntry `json:"entry"`
+
+	// Revision of the document, incremented on every change
+	Version int64 `json:"version,omitempty"`
+}
+
+// employeeDocumentId combines hospital and entry ids, entry ids are unique only within a hospital
+func employeeDocumentId(hospitalId string, entryId string) string {
+	return hospitalId + "/" + entryId
+}
+
+// newEmployeeDocument creates the stored form of the entry, its performances are stored separately
+func newEmployeeDocument(hospitalId string, entry EmployeeListEntry) *EmployeeDocument {
+	entry.Performances = nil
+	return &EmployeeDocument{
+		Id:         employeeDocumentId(hospitalId, entry.Id),
+		HospitalId: hospitalId,
+		Entry:      entry,
+	}
+}
+
+// hospitalEmployeesQuery selects all employees of the hospital
+func hospitalEmployeesQuery(hospitalId string) db_service.Query {
+	return db_service.Query{Equal: map[string]interface{}{"hospitalId": hospitalId}}
+}
+
+// findHospitalEmployees loads all employee list entries of the hospital including their performances
+func (d *apiDependencies) findHospitalEmployees(ctx *gin.Context, hospitalId string) ([]EmployeeListEntry, error) {
+	return d.findEmployees(ctx, hospitalId, hospitalEmployeesQuery(hospitalId))
+}
+
+// findEmployees loads the employee list entries of the hospital selected by the query
+// including their performances
+func (d *apiDependencies) findEmployees(ctx *gin.Context, hospitalId string, query db_service.Query) ([]EmployeeListEntry, error) {
+	documents, err := d.store.Employees.FindDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]EmployeeListEntry, 0, len(documents))
+	for _, document := range documents {
+		entries = append(entries, document.Entry)
+	}
+	if err := d.attachPerformances(ctx, hospitalId, entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,53 @@
+package hospital_wl
+
+import (
+	"time"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// idempotencyBodyPath addresses the stored response within IdempotencyRecord
+const idempotencyBodyPath = "body"
+
+// IdempotencyRecord keeps the response to a request sent with the Idempotency-Key header,
+// so that retries of the request receive the same response instead of repeating its effect
+type IdempotencyRecord struct {
+	// Unique id of the record, see idempotencyRecordId
+	Id string `json:"id"`
+
+	// Hospital the request addressed, empty for requests creating hospitals
+	HospitalId string `json:"hospitalId"`
+
+	Key string `json:"key"`
+
+	// RequestHash identifies the method, path and body of the request, a key may be reused
+	// only for the same request
+	RequestHash string `json:"requestHash"`
+
+	// Status of the response, zero while the request is being processed
+	Status int `json:"status,omitempty"`
+
+	ContentType string `json:"contentType,omitempty"`
+	ETag        string `json:"etag,omitempty"`
+
+	// Body of the response, a string so that it can be stored encrypted, see IdempotencyEncryptionConfig
+	Body string `json:"body,omitempty"`
+
+	// ExpiresAt is the time after which the key may be used for another request, expired
+	// records are removed by the MongoDB TTL index and replaced by the other storages
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// Version of the record, an expired record is replaced only at the version it was read
+	Version int64 `json:"version"`
+}
+
+// idempotencyRecordId combines hospital and key, keys are unique only within a hospital
+func idempotencyRecordId(hospitalId string, key string) string {
+	return hospitalId + "/" + key
+}
+
+// IdempotencyEncryptionConfig encrypts the stored responses, which include the patient data
+// of the created performances
+func IdempotencyEncryptionConfig(keyring *db_service.Keyring) db_service.EncryptionConfig {
+	return db_service.EncryptionConfig{Keyring: keyring, Fields: []string{idempotencyBodyPath}}
+}
@@ -0,0 +1,42 @@
+package hospital_wl
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type implAccessLogAPI struct {
+	apiDependencies
+}
+
+func NewAccessLogApi(store Store, clock Clock, idGenerator IdGenerator, logger *log.Logger) AccessLogAPI {
+	return &implAccessLogAPI{newApiDependencies(store, clock, idGenerator, logger)}
+}
+
+// GetAccessLog lists the accesses to patient data in the hospital, the records of deleted
+// hospitals are kept, so the hospital is not required to exist
+func (o *implAccessLogAPI) GetAccessLog(c *gin.Context) {
+	query, ok := accessLogQuery(c, c.Param("hospitalId"))
+	if !ok {
+		return
+	}
+
+	records, err := o.store.AccessLog.FindDocuments(c, query)
+	if err != nil {
+		abortWithStorageFailure(c, o.logger, "Failed to load access log from database", err)
+		return
+	}
+
+	total := int64(len(records))
+	if paged(query) {
+		if total, err = o.store.AccessLog.CountDocuments(c, query); err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to count access log records in database", err)
+			return
+		}
+	}
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	c.JSON(http.StatusOK, records)
+}
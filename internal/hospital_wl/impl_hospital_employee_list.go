@@ -1,461 +1,575 @@
 package hospital_wl
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"strconv"
 
 	"github.com/xkello/ambulance-otapi/internal/db_service"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
-	"slices"
 )
 
 type implHospitalEmployeeListAPI struct {
+	apiDependencies
 }
 
-func NewHospitalEmployeeListApi() HospitalEmployeeListAPI {
-	return &implHospitalEmployeeListAPI{}
+// errUpdateRejected aborts the update transaction without storing any change
+var errUpdateRejected = errors.New("update rejected")
+
+func NewHospitalEmployeeListApi(store Store, clock Clock, idGenerator IdGenerator, logger *log.Logger) HospitalEmployeeListAPI {
+	return &implHospitalEmployeeListAPI{newApiDependencies(store, clock, idGenerator, logger)}
 }
 
 func (o *implHospitalEmployeeListAPI) CreateEmployeeListEntry(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
-		var entry EmployeeListEntry
+	o.idempotentFunc(c, c.Param("hospitalId"), replayedAs[EmployeeListEntry], o.createEmployeeListEntry)
+}
 
-		if err := c.ShouldBindJSON(&entry); err != nil {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			}, http.StatusBadRequest
-		}
+func (o *implHospitalEmployeeListAPI) createEmployeeListEntry(c *gin.Context) {
+	hospitalId := c.Param("hospitalId")
+	roles, ok := o.findHospitalRoles(c, hospitalId)
+	if !ok {
+		return
+	}
 
-		if entry.Id == "" || entry.Id == "@new" {
-			entry.Id = uuid.NewString()
-		}
+	var entry EmployeeListEntry
 
-		conflictIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entry.Id == employee.Id
-		})
+	if err := c.ShouldBindJSON(&entry); err != nil {
+		respondWithProblem(c, invalidBodyProblem(err))
+		return
+	}
 
-		if conflictIndx >= 0 {
-			return nil, gin.H{
-				"status":  http.StatusConflict,
-				"message": "Entry already exists",
-			}, http.StatusConflict
-		}
+	if entry.Id == "" || entry.Id == "@new" {
+		entry.Id = o.newId()
+	}
+	if problem := o.assignPerformanceIds(entry.Performances); problem != nil {
+		respondWithProblem(c, problem)
+		return
+	}
 
-		hospital.EmployeeList = append(hospital.EmployeeList, entry)
-		entryIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entry.Id == employee.Id
-		})
-		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusInternalServerError,
-				"message": "Failed to save entry",
-			}, http.StatusInternalServerError
+	if problem := validatePayload("EmployeeListEntry", "", entry); problem != nil {
+		respondWithProblem(c, problem)
+		return
+	}
+
+	if entry.Role, ok = resolveRole(roles, entry.Role); !ok {
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRole, "Role code is not one of the predefined roles of the hospital")
+		return
+	}
+
+	documentId := employeeDocumentId(hospitalId, entry.Id)
+	var err error
+	if isDryRun(c) {
+		// the conflict the storage would report is detected by the lookup
+		if _, err = o.store.Employees.FindDocumentWith(c, documentId, db_service.ReadOptions{Fields: []string{"id"}}); err == nil {
+			err = db_service.ErrConflict
+		} else if err == db_service.ErrNotFound {
+			reportChange(c, changeCreate, "entry", documentId)
+			reportPerformanceChanges(c, changeCreate, documentId, entry.Performances)
+			err = nil
+		}
+	} else if err = o.store.Employees.CreateDocument(c, documentId, newEmployeeDocument(hospitalId, entry)); err == nil {
+		if err = o.createPerformances(c, hospitalId, entry.Id, entry.Performances); err != nil {
+			if deleteErr := o.store.Employees.DeleteDocument(c, documentId); deleteErr != nil {
+				o.logger.Printf("Failed to remove entry %v after its performances could not be stored: %v", documentId, deleteErr)
+			}
 		}
-		return hospital, hospital.EmployeeList[entryIndx], http.StatusOK
-	})
+	}
+	switch err {
+	case nil:
+		respondWithETag(c, http.StatusOK, entry)
+	case db_service.ErrConflict:
+		abortWithProblem(c, http.StatusConflict, codeAlreadyExists, "Entry already exists")
+	default:
+		abortWithStorageFailure(c, o.logger, "Failed to save entry", err)
+	}
 }
 
 func (o *implHospitalEmployeeListAPI) DeleteEmployeeListEntry(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
-		entryId := c.Param("entryId")
-
-		if entryId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID is required",
-			}, http.StatusBadRequest
-		}
+	hospitalId, entryId := c.Param("hospitalId"), c.Param("entryId")
 
-		entryIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entryId == employee.Id
-		})
+	if entryId == "" {
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Entry ID is required")
+		return
+	}
 
-		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
-		}
+	// the entry is removed first, so that a failed precondition leaves the data untouched,
+	// its performances left behind by a failure are removed by a retried request
+	documentId := employeeDocumentId(hospitalId, entryId)
+	conditional := c.GetHeader("If-Match") != ""
+	var version int64
+	if conditional || isDryRun(c) {
+		document, err := o.store.Employees.FindDocument(c, documentId)
+		switch err {
+		case nil:
+		case db_service.ErrNotFound:
+			abortWithProblem(c, http.StatusNotFound, codeEntryNotFound, "Entry not found")
+			return
+		default:
+			abortWithStorageFailure(c, o.logger, "Failed to load entry from database", err)
+			return
+		}
+		if document.Entry.Performances, err = o.findPerformances(c, documentId); err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to load performances from database", err)
+			return
+		}
+		if !ifMatchSatisfied(c, document.Entry) {
+			abortWithProblem(c, http.StatusPreconditionFailed, codePreconditionFailed, "Entry was modified")
+			return
+		}
+		if isDryRun(c) {
+			reportChange(c, changeDelete, "entry", documentId)
+			reportPerformanceChanges(c, changeDelete, documentId, document.Entry.Performances)
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		version = document.Version
+	}
 
-		hospital.EmployeeList = append(hospital.EmployeeList[:entryIndx], hospital.EmployeeList[entryIndx+1:]...)
-		return hospital, nil, http.StatusNoContent
-	})
+	var err error
+	if !conditional {
+		err = o.store.Employees.DeleteDocument(c, documentId)
+	} else {
+		// the entry is removed only in the state the precondition was evaluated against
+		err = o.store.Employees.UpdateDocuments(c, []string{documentId}, func(documents []*EmployeeDocument) ([]*EmployeeDocument, error) {
+			switch {
+			case documents[0] == nil:
+				return nil, db_service.ErrNotFound
+			case documents[0].Version != version:
+				return nil, errUpdateRejected
+			}
+			return []*EmployeeDocument{nil}, nil
+		})
+	}
+
+	switch {
+	case err == nil, errors.Is(err, db_service.ErrNotFound):
+		if err := o.deletePerformances(c, hospitalId, entryId); err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to delete performances from database", err)
+			return
+		}
+		if err != nil {
+			abortWithProblem(c, http.StatusNotFound, codeEntryNotFound, "Entry not found")
+			return
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	case errors.Is(err, errUpdateRejected):
+		abortWithProblem(c, http.StatusPreconditionFailed, codePreconditionFailed, "Entry was modified while processing the request")
+	default:
+		abortWithStorageFailure(c, o.logger, "Failed to delete entry", err)
+	}
 }
 
 func (o *implHospitalEmployeeListAPI) GetEmployeeListEntries(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
-		result := hospital.EmployeeList
-		if result == nil {
-			result = []EmployeeListEntry{}
-		}
-		return nil, result, http.StatusOK
-	})
-}
+	hospitalId := c.Param("hospitalId")
+	if !o.hospitalExists(c, hospitalId) {
+		return
+	}
 
-func (o *implHospitalEmployeeListAPI) GetEmployeeListEntry(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
-		entryId := c.Param("entryId")
-
-		if entryId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID is required",
-			}, http.StatusBadRequest
+	query, patient, ok := employeeListQuery(c, hospitalId)
+	if !ok {
+		return
+	}
+
+	if patient != "" {
+		employeeIds, err := o.findPatientEmployees(c, hospitalId, patient)
+		if errors.Is(err, db_service.ErrNotSearchable) {
+			respondInvalidQuery(c, "patient cannot be searched, the patient names are not encrypted deterministically")
+			return
 		}
+		if err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to load performances from database", err)
+			return
+		}
+		query.In = map[string][]interface{}{"id": employeeIds}
+	}
 
-		entryIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entryId == employee.Id
-		})
+	result, err := o.findEmployees(c, hospitalId, query)
+	if err != nil {
+		abortWithStorageFailure(c, o.logger, "Failed to load entries from database", err)
+		return
+	}
 
-		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
+	total := int64(len(result))
+	if paged(query) {
+		if total, err = o.store.Employees.CountDocuments(c, query); err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to count entries in database", err)
+			return
 		}
+	}
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	respondWithETag(c, http.StatusOK, result)
+}
 
-		return nil, hospital.EmployeeList[entryIndx], http.StatusOK
-	})
+func (o *implHospitalEmployeeListAPI) GetEmployeeListEntry(c *gin.Context) {
+	entry, ok := o.findEmployeeEntry(c)
+	if !ok {
+		return
+	}
+	respondWithETag(c, http.StatusOK, entry)
 }
 
 func (o *implHospitalEmployeeListAPI) UpdateEmployeeListEntry(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
+	roles, ok := o.findHospitalRoles(c, c.Param("hospitalId"))
+	if !ok {
+		return
+	}
+
+	o.updateEmployeeFunc(c, func(c *gin.Context, current *EmployeeListEntry) (*EmployeeListEntry, interface{}, int) {
 		var entry EmployeeListEntry
 
-		if err := c.ShouldBindJSON(&entry); err != nil {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			}, http.StatusBadRequest
+		if err := c.ShouldBindBodyWithJSON(&entry); err != nil {
+			return nil, invalidBodyProblem(err), http.StatusBadRequest
 		}
+		return o.replaceEmployeeEntry(c, roles, current, entry)
+	})
+}
 
-		entryId := c.Param("entryId")
+func (o *implHospitalEmployeeListAPI) PatchEmployeeListEntry(c *gin.Context) {
+	roles, ok := o.findHospitalRoles(c, c.Param("hospitalId"))
+	if !ok {
+		return
+	}
 
-		if entryId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID is required",
-			}, http.StatusBadRequest
+	o.updateEmployeeFunc(c, func(c *gin.Context, current *EmployeeListEntry) (*EmployeeListEntry, interface{}, int) {
+		var entry EmployeeListEntry
+		// the patch is applied to the entry as the caller sees it, so that its tests do not disclose masked data
+		switch err := applyPatch(c, redactFor(c, current), &entry); err {
+		case nil:
+			return o.replaceEmployeeEntry(c, roles, current, entry)
+		case errUnsupportedPatch:
+			return nil, newProblem(http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+				"Entry supports JSON merge patch and JSON patch"), http.StatusUnsupportedMediaType
+		case errPatchTestFailed:
+			return nil, newProblem(http.StatusConflict, codePatchTestFailed,
+				"Entry does not satisfy the test operation of the patch"), http.StatusConflict
+		default:
+			return nil, newProblem(http.StatusBadRequest, codeInvalidRequest, "Invalid patch: "+err.Error()), http.StatusBadRequest
 		}
+	})
+}
 
-		entryIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entryId == employee.Id
-		})
-
-		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
-		}
+// replaceEmployeeEntry validates the new state of the entry, which replaces the current one
+// completely, so omitted fields are cleared. The id is kept, entries are moved only by transfer.
+func (o *implHospitalEmployeeListAPI) replaceEmployeeEntry(
+	c *gin.Context,
+	roles []Role,
+	current *EmployeeListEntry,
+	entry EmployeeListEntry,
+) (*EmployeeListEntry, interface{}, int) {
+	if entry.Id != "" && entry.Id != current.Id {
+		return nil, newProblem(http.StatusBadRequest, codeInvalidRequest, "Entry ID in path does not match ID in body"), http.StatusBadRequest
+	}
 
-		if entry.Id != "" {
-			hospital.EmployeeList[entryIndx].Id = entry.Id
-		}
-		if entry.Name != "" {
-			hospital.EmployeeList[entryIndx].Name = entry.Name
-		}
-		if entry.Role.Value != "" {
-			hospital.EmployeeList[entryIndx].Role.Value = entry.Role.Value
-		}
-		if entry.Role.Code != "" {
-			hospital.EmployeeList[entryIndx].Role.Code = entry.Role.Code
-		}
+	if !ifMatchSatisfied(c, *current) {
+		return nil, newProblem(http.StatusPreconditionFailed, codePreconditionFailed, "Entry was modified"), http.StatusPreconditionFailed
+	}
 
-		// Update performance rating
-		hospital.EmployeeList[entryIndx].Performance = entry.Performance
+	entry.Id = current.Id
+	preserveClinicalData(c, current.Performances, entry.Performances)
+	if problem := o.assignPerformanceIds(entry.Performances); problem != nil {
+		return nil, problem, int(problem.Status)
+	}
+	if problem := validatePayload("EmployeeListEntry", "", entry); problem != nil {
+		return nil, problem, int(problem.Status)
+	}
 
-		// Update performances array
-		if entry.Performances != nil {
-			hospital.EmployeeList[entryIndx].Performances = entry.Performances
-		}
-		
-		return hospital, hospital.EmployeeList[entryIndx], http.StatusOK
-	})
+	role, ok := resolveRole(roles, entry.Role)
+	if !ok {
+		return nil, newProblem(http.StatusBadRequest, codeInvalidRole,
+			"Role code is not one of the predefined roles of the hospital"), http.StatusBadRequest
+	}
+	entry.Role = role
+	return &entry, &entry, http.StatusOK
 }
 
 func (o *implHospitalEmployeeListAPI) TransferEmployeeListEntry(c *gin.Context) {
 	srcHospID := c.Param("hospitalId")
 	entryID := c.Param("entryId")
 	if srcHospID == "" || entryID == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "hospitalId and entryId are required"})
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Hospital ID and entry ID are required")
 		return
 	}
 
-	var req struct {
-		TargetHospitalId string `json:"targetHospitalId"`
+	var req TransferEmployeeListEntryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		respondWithProblem(c, invalidBodyProblem(err))
+		return
 	}
-	if err := c.ShouldBindJSON(&req); err != nil || req.TargetHospitalId == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"status": http.StatusBadRequest, "message": "Invalid or missing targetHospitalId", "error": err.Error()})
+	if req.TargetHospitalId == "" {
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Missing targetHospitalId")
 		return
 	}
-
-	val, ok := c.Get("db_service")
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": "db_service not found"})
+	if req.TargetHospitalId == srcHospID {
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Target hospital is the same as the source hospital")
 		return
 	}
-	dbSvc, ok := val.(db_service.DbService[Hospital])
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"status": http.StatusInternalServerError, "message": "invalid db_service type"})
+	// the authorizer checked the source hospital, the entry is created in the target hospital
+	if !authorized(c, req.TargetHospitalId, PermissionEditEmployees) {
+		abortWithProblem(c, http.StatusForbidden, codeForbidden, "Permission "+string(PermissionEditEmployees)+" is required in target hospital")
 		return
 	}
 
-	srcHosp, err := dbSvc.FindDocument(c, srcHospID)
+	// check the target first, so that a missing target never touches the source hospital
+	targetHospital, err := o.store.Hospitals.FindDocumentWith(c, req.TargetHospitalId, db_service.ReadOptions{Fields: []string{"predefinedRoles"}})
 	if err != nil {
 		if err == db_service.ErrNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "source hospital not found"})
+			abortWithProblem(c, http.StatusNotFound, codeHospitalNotFound, "Target hospital not found")
 		} else {
-			c.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway, "message": err.Error()})
+			abortWithStorageFailure(c, o.logger, "Failed to load target hospital from database", err)
 		}
 		return
 	}
 
-	idx := slices.IndexFunc(srcHosp.EmployeeList, func(e EmployeeListEntry) bool {
-		return e.Id == entryID
-	})
-	if idx < 0 {
-		c.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "entry not found in source hospital"})
-		return
-	}
-	entry := srcHosp.EmployeeList[idx]
-
-	srcHosp.EmployeeList = append(srcHosp.EmployeeList[:idx], srcHosp.EmployeeList[idx+1:]...)
-	if err := dbSvc.UpdateDocument(c, srcHospID, srcHosp); err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway, "message": "failed to update source hospital", "error": err.Error()})
-		return
+	targetRoles := rolesOf(targetHospital)
+	var entry EmployeeListEntry
+	var rejection *Problem
+	targetExists := false
+	ids := []string{employeeDocumentId(srcHospID, entryID), employeeDocumentId(req.TargetHospitalId, entryID)}
+	// transfer moves the entry, or only checks that it can be moved
+	transfer := func(move bool) error {
+		return o.store.Employees.UpdateDocuments(c, ids, func(documents []*EmployeeDocument) ([]*EmployeeDocument, error) {
+			source, target := documents[0], documents[1]
+			if source == nil {
+				rejection = newProblem(http.StatusNotFound, codeEntryNotFound, "Entry not found in source hospital")
+				return nil, errUpdateRejected
+			}
+			if targetExists = target != nil; targetExists {
+				rejection = newProblem(http.StatusConflict, codeAlreadyExists, "Entry with the same id already exists in target hospital")
+				return nil, errUpdateRejected
+			}
+
+			entry = source.Entry
+			if _, ok := resolveRole(targetRoles, entry.Role); !ok {
+				rejection = newProblem(http.StatusConflict, codeInvalidRole, "Role of the entry is not defined in target hospital")
+				return nil, errUpdateRejected
+			}
+			if !move {
+				return nil, errDryRun
+			}
+			return []*EmployeeDocument{nil, newEmployeeDocument(req.TargetHospitalId, entry)}, nil
+		})
 	}
 
-	dstHosp, err := dbSvc.FindDocument(c, req.TargetHospitalId)
-	if err != nil {
-		if err == db_service.ErrNotFound {
-			c.JSON(http.StatusNotFound, gin.H{"status": http.StatusNotFound, "message": "target hospital not found"})
-		} else {
-			c.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway, "message": err.Error()})
+	// the performances are copied into the target hospital before the entry is moved and removed from
+	// the source hospital after, so that the entry is never separated from them. The copies left behind
+	// by a failure belong to no entry and are dropped when an entry with the same id is created.
+	var performances []PerformanceEntry
+	err = transfer(false)
+	if errors.Is(err, errDryRun) {
+		performances, err = o.findPerformances(c, ids[0])
+		switch {
+		case err != nil:
+			abortWithStorageFailure(c, o.logger, "Failed to load performances from database", err)
+			return
+		case isDryRun(c):
+			entry.Performances = performances
+			reportChange(c, changeDelete, "entry", ids[0])
+			reportChange(c, changeCreate, "entry", ids[1])
+			reportPerformanceChanges(c, changeDelete, ids[0], entry.Performances)
+			reportPerformanceChanges(c, changeCreate, ids[1], entry.Performances)
+			respondAudited(c, http.StatusOK, entry)
+			return
+		}
+		if err = o.createPerformances(c, req.TargetHospitalId, entryID, performances); err != nil {
+			if cleanupErr := o.deletePerformances(c, req.TargetHospitalId, entryID); cleanupErr != nil {
+				err = errors.Join(err, cleanupErr)
+			}
+			abortWithStorageFailure(c, o.logger, "Failed to copy performances of the entry into target hospital", err)
+			return
+		}
+		// the performances of an entry created in the target hospital meanwhile are not removed
+		if err = transfer(true); err != nil && !targetExists {
+			if cleanupErr := o.deletePerformances(c, req.TargetHospitalId, entryID); cleanupErr != nil {
+				abortWithStorageFailure(c, o.logger, "Failed to remove performances copied for the transfer", errors.Join(err, cleanupErr))
+				return
+			}
 		}
-		return
 	}
 
-	dstHosp.EmployeeList = append(dstHosp.EmployeeList, entry)
-	if err := dbSvc.UpdateDocument(c, req.TargetHospitalId, dstHosp); err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"status": http.StatusBadGateway, "message": "failed to update target hospital", "error": err.Error()})
-		return
+	switch {
+	case err == nil:
+		if err := o.deletePerformances(c, srcHospID, entryID); err != nil {
+			abortWithStorageFailure(c, o.logger, "Entry was transferred, but its performances were not removed from source hospital", err)
+			return
+		}
+		entry.Performances = performances
+		respondAudited(c, http.StatusOK, entry)
+	case errors.Is(err, errUpdateRejected):
+		respondWithProblem(c, rejection)
+	case errors.Is(err, db_service.ErrVersionMismatch):
+		abortWithProblem(c, http.StatusConflict, codeConcurrentModification, "Entry is being modified concurrently, try again later")
+	default:
+		abortWithStorageFailure(c, o.logger, "Failed to transfer entry", err)
 	}
-
-	c.JSON(http.StatusOK, entry)
 }
 
 func (o *implHospitalEmployeeListAPI) GetPerformanceEntries(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
-		entryId := c.Param("entryId")
-
-		if entryId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID is required",
-			}, http.StatusBadRequest
-		}
-
-		entryIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entryId == employee.Id
-		})
+	filter, ok := bindPerformanceFilter(c)
+	if !ok {
+		return
+	}
 
-		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
-		}
+	entry, ok := o.findEmployeeEntry(c)
+	if !ok {
+		return
+	}
 
-		performances := hospital.EmployeeList[entryIndx].Performances
-		if performances == nil {
-			performances = []PerformanceEntry{}
+	performances := []PerformanceEntry{}
+	for _, performance := range entry.Performances {
+		if filter.matches(performance) {
+			performances = append(performances, performance)
 		}
+	}
 
-		return nil, performances, http.StatusOK
-	})
+	respondWithETag(c, http.StatusOK, performances)
 }
 
 func (o *implHospitalEmployeeListAPI) CreatePerformanceEntry(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
-		entryId := c.Param("entryId")
-
-		if entryId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID is required",
-			}, http.StatusBadRequest
-		}
-
-		entryIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entryId == employee.Id
-		})
+	o.idempotentFunc(c, c.Param("hospitalId"), replayedAs[PerformanceEntry], o.createPerformanceEntry)
+}
 
-		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
-		}
+func (o *implHospitalEmployeeListAPI) createPerformanceEntry(c *gin.Context) {
+	var performance PerformanceEntry
+	if err := c.ShouldBindJSON(&performance); err != nil {
+		respondWithProblem(c, invalidBodyProblem(err))
+		return
+	}
 
-		var performance PerformanceEntry
-		if err := c.ShouldBindJSON(&performance); err != nil {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			}, http.StatusBadRequest
-		}
+	if performance.Id == "" {
+		performance.Id = o.newId()
+	}
 
-		if performance.Id == "" {
-			performance.Id = uuid.NewString()
-		}
+	if problem := validatePayload("PerformanceEntry", "", performance); problem != nil {
+		respondWithProblem(c, problem)
+		return
+	}
 
-		if hospital.EmployeeList[entryIndx].Performances == nil {
-			hospital.EmployeeList[entryIndx].Performances = []PerformanceEntry{}
-		}
+	if !o.employeeExists(c) {
+		return
+	}
 
-		hospital.EmployeeList[entryIndx].Performances = append(hospital.EmployeeList[entryIndx].Performances, performance)
-		return hospital, performance, http.StatusOK
-	})
+	hospitalId, entryId := c.Param("hospitalId"), c.Param("entryId")
+	documentId := performanceDocumentId(employeeDocumentId(hospitalId, entryId), performance.Id)
+	var err error
+	if isDryRun(c) {
+		// the conflict the storage would report is detected by the lookup
+		if _, err = o.store.Performances.FindDocumentWith(c, documentId, db_service.ReadOptions{Fields: []string{"id"}}); err == nil {
+			err = db_service.ErrConflict
+		} else if err == db_service.ErrNotFound {
+			reportChange(c, changeCreate, "performance", documentId)
+			err = nil
+		}
+	} else {
+		err = o.store.Performances.CreateDocument(c, documentId, newPerformanceDocument(hospitalId, entryId, performance))
+	}
+	switch err {
+	case nil:
+		respondWithETag(c, http.StatusOK, performance)
+	case db_service.ErrConflict:
+		abortWithProblem(c, http.StatusConflict, codeAlreadyExists, "Performance entry already exists")
+	default:
+		abortWithStorageFailure(c, o.logger, "Failed to save performance entry", err)
+	}
 }
 
 func (o *implHospitalEmployeeListAPI) GetPerformanceEntry(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
-		entryId := c.Param("entryId")
-		performanceId := c.Param("performanceId")
-
-		if entryId == "" || performanceId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID and Performance ID are required",
-			}, http.StatusBadRequest
-		}
-
-		entryIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entryId == employee.Id
-		})
+	performanceId := c.Param("performanceId")
 
-		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
-		}
-
-		performanceIndx := slices.IndexFunc(hospital.EmployeeList[entryIndx].Performances, func(perf PerformanceEntry) bool {
-			return performanceId == perf.Id
-		})
-
-		if performanceIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Performance entry not found",
-			}, http.StatusNotFound
-		}
+	if performanceId == "" {
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Entry ID and Performance ID are required")
+		return
+	}
 
-		return nil, hospital.EmployeeList[entryIndx].Performances[performanceIndx], http.StatusOK
-	})
+	employeeId := employeeDocumentId(c.Param("hospitalId"), c.Param("entryId"))
+	document, err := o.store.Performances.FindDocument(c, performanceDocumentId(employeeId, performanceId))
+	switch err {
+	case nil:
+		respondWithETag(c, http.StatusOK, document.Performance)
+	case db_service.ErrNotFound:
+		o.abortWithPerformanceNotFound(c)
+	default:
+		abortWithStorageFailure(c, o.logger, "Failed to load performance entry from database", err)
+	}
 }
 
 func (o *implHospitalEmployeeListAPI) UpdatePerformanceEntry(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
-		entryId := c.Param("entryId")
-		performanceId := c.Param("performanceId")
-
-		if entryId == "" || performanceId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID and Performance ID are required",
-			}, http.StatusBadRequest
-		}
+	performanceId := c.Param("performanceId")
 
-		entryIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entryId == employee.Id
-		})
-
-		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
-		}
+	if performanceId == "" {
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Entry ID and Performance ID are required")
+		return
+	}
 
-		performanceIndx := slices.IndexFunc(hospital.EmployeeList[entryIndx].Performances, func(perf PerformanceEntry) bool {
-			return performanceId == perf.Id
-		})
+	var performance PerformanceEntry
+	if err := c.ShouldBindBodyWithJSON(&performance); err != nil {
+		respondWithProblem(c, invalidBodyProblem(err))
+		return
+	}
 
-		if performanceIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Performance entry not found",
-			}, http.StatusNotFound
-		}
+	// Ensure the ID in the path matches the ID in the body
+	if performance.Id != performanceId {
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Performance ID in path does not match ID in body")
+		return
+	}
 
-		var performance PerformanceEntry
-		if err := c.ShouldBindJSON(&performance); err != nil {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			}, http.StatusBadRequest
-		}
+	if problem := validatePayload("PerformanceEntry", "", performance); problem != nil {
+		respondWithProblem(c, problem)
+		return
+	}
 
-		// Ensure the ID in the path matches the ID in the body
-		if performance.Id != performanceId {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Performance ID in path does not match ID in body",
-			}, http.StatusBadRequest
+	// the clinical data masked for the caller are kept as loaded
+	o.updatePerformanceFunc(c, func(c *gin.Context, current *PerformanceEntry) (*PerformanceEntry, interface{}, int) {
+		if !ifMatchSatisfied(c, *current) {
+			return nil, newProblem(http.StatusPreconditionFailed, codePreconditionFailed, "Performance entry was modified"), http.StatusPreconditionFailed
 		}
 
-		hospital.EmployeeList[entryIndx].Performances[performanceIndx] = performance
-		return hospital, performance, http.StatusOK
+		updated := []PerformanceEntry{performance}
+		preserveClinicalData(c, []PerformanceEntry{*current}, updated)
+		return &updated[0], updated[0], http.StatusOK
 	})
 }
 
 func (o *implHospitalEmployeeListAPI) DeletePerformanceEntry(c *gin.Context) {
-	updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
-		entryId := c.Param("entryId")
-		performanceId := c.Param("performanceId")
-
-		if entryId == "" || performanceId == "" {
-			return nil, gin.H{
-				"status":  http.StatusBadRequest,
-				"message": "Entry ID and Performance ID are required",
-			}, http.StatusBadRequest
-		}
+	performanceId := c.Param("performanceId")
 
-		entryIndx := slices.IndexFunc(hospital.EmployeeList, func(employee EmployeeListEntry) bool {
-			return entryId == employee.Id
-		})
-
-		if entryIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Entry not found",
-			}, http.StatusNotFound
-		}
+	if performanceId == "" {
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Entry ID and Performance ID are required")
+		return
+	}
 
-		performanceIndx := slices.IndexFunc(hospital.EmployeeList[entryIndx].Performances, func(perf PerformanceEntry) bool {
-			return performanceId == perf.Id
+	documentId := performanceDocumentId(employeeDocumentId(c.Param("hospitalId"), c.Param("entryId")), performanceId)
+	dryRun := isDryRun(c)
+	var err error
+	if c.GetHeader("If-Match") == "" && !dryRun {
+		err = o.store.Performances.DeleteDocument(c, documentId)
+	} else {
+		// the precondition is evaluated against the loaded performance, so the delete must be atomic
+		// with the load, dry runs abort the transaction after the checks
+		err = o.store.Performances.UpdateDocuments(c, []string{documentId}, func(documents []*PerformanceDocument) ([]*PerformanceDocument, error) {
+			switch {
+			case documents[0] == nil:
+				return nil, db_service.ErrNotFound
+			case !ifMatchSatisfied(c, documents[0].Performance):
+				return nil, errUpdateRejected
+			case dryRun:
+				return nil, errDryRun
+			}
+			return []*PerformanceDocument{nil}, nil
 		})
+	}
 
-		if performanceIndx < 0 {
-			return nil, gin.H{
-				"status":  http.StatusNotFound,
-				"message": "Performance entry not found",
-			}, http.StatusNotFound
-		}
-
-		hospital.EmployeeList[entryIndx].Performances = append(
-			hospital.EmployeeList[entryIndx].Performances[:performanceIndx],
-			hospital.EmployeeList[entryIndx].Performances[performanceIndx+1:]...
-		)
-		return hospital, nil, http.StatusNoContent
-	})
+	switch {
+	case err == nil:
+		c.AbortWithStatus(http.StatusNoContent)
+	case errors.Is(err, errDryRun):
+		reportChange(c, changeDelete, "performance", documentId)
+		c.AbortWithStatus(http.StatusNoContent)
+	case errors.Is(err, db_service.ErrNotFound):
+		o.abortWithPerformanceNotFound(c)
+	case errors.Is(err, errUpdateRejected):
+		abortWithProblem(c, http.StatusPreconditionFailed, codePreconditionFailed, "Performance entry was modified")
+	default:
+		abortWithStorageFailure(c, o.logger, "Failed to delete performance entry", err)
+	}
 }
-
@@ -2,20 +2,27 @@ package hospital_wl
 
 import (
 	"context"
+	"encoding/json"
+	"io"
+	"log"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 
 	"github.com/xkello/ambulance-otapi/internal/db_service"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/mock"
+	"go.mongodb.org/mongo-driver/bson"
 )
 
 type HospitalWlSuite struct {
 	suite.Suite
-	dbServiceMock *DbServiceMock[Hospital]
+	dbServiceMock            *DbServiceMock[Hospital]
+	employeeDbServiceMock    *DbServiceMock[EmployeeDocument]
+	performanceDbServiceMock *DbServiceMock[PerformanceDocument]
 }
 
 func TestHospitalWlSuite(t *testing.T) {
@@ -41,16 +48,51 @@ func (this *DbServiceMock[DocType]) FindDocument(ctx context.Context, id string)
 	return args.Get(0).(*DocType), args.Error(1)
 }
 
+func (this *DbServiceMock[DocType]) FindDocumentWith(ctx context.Context, id string, options db_service.ReadOptions) (*DocType, error) {
+	args := this.Called(ctx, id, options)
+	return args.Get(0).(*DocType), args.Error(1)
+}
+
 func (this *DbServiceMock[DocType]) UpdateDocument(ctx context.Context, id string, document *DocType) error {
 	args := this.Called(ctx, id, document)
 	return args.Error(0)
 }
 
+func (this *DbServiceMock[DocType]) CompareAndSwapDocument(ctx context.Context, id string, expectedVersion int64, document *DocType) error {
+	args := this.Called(ctx, id, expectedVersion, document)
+	return args.Error(0)
+}
+
 func (this *DbServiceMock[DocType]) DeleteDocument(ctx context.Context, id string) error {
 	args := this.Called(ctx, id)
 	return args.Error(0)
 }
 
+func (this *DbServiceMock[DocType]) FindDocuments(ctx context.Context, query db_service.Query) ([]DocType, error) {
+	args := this.Called(ctx, query)
+	return args.Get(0).([]DocType), args.Error(1)
+}
+
+func (this *DbServiceMock[DocType]) CountDocuments(ctx context.Context, query db_service.Query) (int64, error) {
+	args := this.Called(ctx, query)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (this *DbServiceMock[DocType]) CountDocumentsBy(ctx context.Context, query db_service.Query, field string) (map[string]int64, error) {
+	args := this.Called(ctx, query, field)
+	return args.Get(0).(map[string]int64), args.Error(1)
+}
+
+func (this *DbServiceMock[DocType]) DeleteDocuments(ctx context.Context, query db_service.Query) (int64, error) {
+	args := this.Called(ctx, query)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (this *DbServiceMock[DocType]) UpdateDocuments(ctx context.Context, ids []string, updater db_service.DocumentsUpdater[DocType]) error {
+	args := this.Called(ctx, ids, updater)
+	return args.Error(0)
+}
+
 func (this *DbServiceMock[DocType]) Disconnect(ctx context.Context) error {
 	args := this.Called(ctx)
 	return args.Error(0)
@@ -58,26 +100,52 @@ func (this *DbServiceMock[DocType]) Disconnect(ctx context.Context) error {
 
 func (suite *HospitalWlSuite) SetupTest() {
 	suite.dbServiceMock = &DbServiceMock[Hospital]{}
+	suite.employeeDbServiceMock = &DbServiceMock[EmployeeDocument]{}
+	suite.performanceDbServiceMock = &DbServiceMock[PerformanceDocument]{}
 
 	var _ db_service.DbService[Hospital] = suite.dbServiceMock
+	var _ db_service.DbService[EmployeeDocument] = suite.employeeDbServiceMock
+	var _ db_service.DbService[PerformanceDocument] = suite.performanceDbServiceMock
 
 	suite.dbServiceMock.
-		On("FindDocument", mock.Anything, mock.Anything).
+		On("FindDocumentWith", mock.Anything, mock.Anything, mock.Anything).
 		Return(
 			&Hospital{
 				Id: "test-hospital",
-				EmployeeList: []EmployeeListEntry{
-					{
-						Id: "test-entry",
-					},
+			},
+			nil,
+		)
+
+	suite.employeeDbServiceMock.
+		On("FindDocument", mock.Anything, mock.Anything).
+		Return(
+			&EmployeeDocument{
+				Id:         "test-hospital/test-entry",
+				HospitalId: "test-hospital",
+				Entry: EmployeeListEntry{
+					Id: "test-entry",
 				},
 			},
 			nil,
 		)
+
+	suite.performanceDbServiceMock.
+		On("FindDocuments", mock.Anything, mock.Anything).
+		Return([]PerformanceDocument{}, nil)
+}
+
+// employeeListApi creates the API backed by the mocks, with fixed time and generated ids
+func (suite *HospitalWlSuite) employeeListApi() HospitalEmployeeListAPI {
+	return NewHospitalEmployeeListApi(
+		Store{Hospitals: suite.dbServiceMock, Employees: suite.employeeDbServiceMock, Performances: suite.performanceDbServiceMock},
+		func() time.Time { return time.Date(2023, 5, 1, 12, 0, 0, 0, time.UTC) },
+		func() string { return "generated-id" },
+		log.New(io.Discard, "", 0),
+	)
 }
 
 func (suite *HospitalWlSuite) Test_UpdateWl_DbServiceUpdateCalled() {
-	suite.dbServiceMock.On("UpdateDocument", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	suite.employeeDbServiceMock.On("CompareAndSwapDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
 
 	json := `{
         "id": "test-entry"
@@ -86,15 +154,297 @@ func (suite *HospitalWlSuite) Test_UpdateWl_DbServiceUpdateCalled() {
 	gin.SetMode(gin.TestMode)
 	recorder := httptest.NewRecorder()
 	ctx, _ := gin.CreateTestContext(recorder)
-	ctx.Set("db_service", suite.dbServiceMock)
 	ctx.Params = []gin.Param{
 		{Key: "hospitalId", Value: "test-hospital"},
 		{Key: "entryId", Value: "test-entry"},
 	}
 	ctx.Request = httptest.NewRequest("POST", "/api/hospital/test-hospital/employeelist/test-entry", strings.NewReader(json))
 
-	sut := &implHospitalEmployeeListAPI{} //TODO
+	sut := suite.employeeListApi()
+
+	sut.UpdateEmployeeListEntry(ctx)
+	suite.employeeDbServiceMock.AssertCalled(suite.T(), "CompareAndSwapDocument", mock.Anything, "test-hospital/test-entry", int64(0), mock.Anything)
+}
+
+func (suite *HospitalWlSuite) Test_UpdateWl_IfMatchMismatch_PreconditionFailed() {
+	json := `{
+        "id": "test-entry",
+        "name": "Changed"
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Params = []gin.Param{
+		{Key: "hospitalId", Value: "test-hospital"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("PUT", "/api/employee-list/test-hospital/entries/test-entry", strings.NewReader(json))
+	ctx.Request.Header.Set("If-Match", `"stale"`)
+
+	sut := suite.employeeListApi()
+
+	sut.UpdateEmployeeListEntry(ctx)
+	suite.Equal(412, recorder.Code)
+	suite.employeeDbServiceMock.AssertNotCalled(suite.T(), "CompareAndSwapDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HospitalWlSuite) Test_UpdateWl_VersionMismatch_Retried() {
+	suite.employeeDbServiceMock.On("CompareAndSwapDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(db_service.ErrVersionMismatch).Once()
+	suite.employeeDbServiceMock.On("CompareAndSwapDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).
+		Return(nil).Once()
+
+	json := `{
+        "id": "test-entry"
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Params = []gin.Param{
+		{Key: "hospitalId", Value: "test-hospital"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("PUT", "/api/employee-list/test-hospital/entries/test-entry", strings.NewReader(json))
+
+	sut := suite.employeeListApi()
 
 	sut.UpdateEmployeeListEntry(ctx)
-	suite.dbServiceMock.AssertCalled(suite.T(), "UpdateDocument", mock.Anything, "test-hospital", mock.Anything)
+	suite.Equal(200, recorder.Code)
+	suite.NotEmpty(recorder.Header().Get("ETag"))
+	suite.employeeDbServiceMock.AssertNumberOfCalls(suite.T(), "CompareAndSwapDocument", 2)
+}
+
+func (suite *HospitalWlSuite) Test_TransferWl_TargetConflict_NothingStored() {
+	var stored []*EmployeeDocument
+	suite.employeeDbServiceMock.
+		On("UpdateDocuments", mock.Anything, []string{"test-hospital/test-entry", "target-hospital/test-entry"}, mock.Anything).
+		Run(func(args mock.Arguments) {
+			updater := args.Get(2).(db_service.DocumentsUpdater[EmployeeDocument])
+			stored, _ = updater([]*EmployeeDocument{
+				newEmployeeDocument("test-hospital", EmployeeListEntry{Id: "test-entry"}),
+				newEmployeeDocument("target-hospital", EmployeeListEntry{Id: "test-entry"}),
+			})
+		}).
+		Return(errUpdateRejected)
+
+	json := `{
+        "targetHospitalId": "target-hospital"
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Params = []gin.Param{
+		{Key: "hospitalId", Value: "test-hospital"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("POST", "/api/employee-list/test-hospital/entries/test-entry/transfer", strings.NewReader(json))
+	ctx.Set(grantsKey, grants{allHospitals: allPermissions})
+
+	sut := suite.employeeListApi()
+
+	sut.TransferEmployeeListEntry(ctx)
+	suite.Equal(409, recorder.Code)
+	suite.Nil(stored)
+	suite.employeeDbServiceMock.AssertNotCalled(suite.T(), "UpdateDocument", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HospitalWlSuite) Test_TransferWl_ConcurrentChange_Conflict() {
+	suite.employeeDbServiceMock.
+		On("UpdateDocuments", mock.Anything, mock.Anything, mock.Anything).
+		Return(db_service.ErrVersionMismatch)
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Params = []gin.Param{
+		{Key: "hospitalId", Value: "test-hospital"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("POST", "/api/employee-list/test-hospital/entries/test-entry/transfer",
+		strings.NewReader(`{"targetHospitalId": "target-hospital"}`))
+	ctx.Set(grantsKey, grants{allHospitals: allPermissions})
+
+	sut := suite.employeeListApi()
+
+	sut.TransferEmployeeListEntry(ctx)
+	suite.Equal(409, recorder.Code)
+	suite.Contains(recorder.Body.String(), codeConcurrentModification)
+	suite.performanceDbServiceMock.AssertNotCalled(suite.T(), "UpdateDocuments", mock.Anything, mock.Anything, mock.Anything)
+}
+
+// mockEntryExists lets the entry be found by the lookups checking only its existence
+func (suite *HospitalWlSuite) mockEntryExists() {
+	suite.employeeDbServiceMock.
+		On("FindDocumentWith", mock.Anything, "test-hospital/test-entry", mock.Anything).
+		Return(&EmployeeDocument{Id: "test-hospital/test-entry"}, nil)
+}
+
+func (suite *HospitalWlSuite) Test_CreatePerformance_CreatedWithoutReplacingEntry() {
+	suite.mockEntryExists()
+	suite.performanceDbServiceMock.On("CreateDocument", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	json := `{
+        "id": "test-performance",
+        "activityType": "surgery",
+        "activityDate": "2023-05-01T08:30:00Z"
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Params = []gin.Param{
+		{Key: "hospitalId", Value: "test-hospital"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("POST", "/api/employee-list/test-hospital/entries/test-entry/performances", strings.NewReader(json))
+
+	sut := suite.employeeListApi()
+
+	sut.CreatePerformanceEntry(ctx)
+	suite.Equal(200, recorder.Code)
+	suite.performanceDbServiceMock.AssertCalled(suite.T(), "CreateDocument", mock.Anything, "test-hospital/test-entry/test-performance",
+		&PerformanceDocument{
+			Id:         "test-hospital/test-entry/test-performance",
+			HospitalId: "test-hospital",
+			EmployeeId: "test-hospital/test-entry",
+			Performance: PerformanceEntry{
+				Id:           "test-performance",
+				ActivityType: "surgery",
+				ActivityDate: NewActivityTime(time.Date(2023, 5, 1, 8, 30, 0, 0, time.UTC)),
+			},
+		})
+	suite.employeeDbServiceMock.AssertNotCalled(suite.T(), "CompareAndSwapDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *HospitalWlSuite) Test_CreatePerformance_IdGenerated() {
+	suite.mockEntryExists()
+	suite.performanceDbServiceMock.On("CreateDocument", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	json := `{
+        "activityType": "surgery",
+        "patientName": "John Doe",
+        "activityDate": "2023-05-01T08:30:00Z",
+        "details": "Routine"
+    }`
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Params = []gin.Param{
+		{Key: "hospitalId", Value: "test-hospital"},
+		{Key: "entryId", Value: "test-entry"},
+	}
+	ctx.Request = httptest.NewRequest("POST", "/api/employee-list/test-hospital/entries/test-entry/performances", strings.NewReader(json))
+
+	sut := suite.employeeListApi()
+
+	sut.CreatePerformanceEntry(ctx)
+	suite.Equal(200, recorder.Code)
+	suite.performanceDbServiceMock.AssertCalled(suite.T(), "CreateDocument", mock.Anything, "test-hospital/test-entry/generated-id",
+		mock.MatchedBy(func(document *PerformanceDocument) bool { return document.Performance.Id == "generated-id" }))
+}
+
+func (suite *HospitalWlSuite) Test_DeletePerformance_Missing_NotFound() {
+	suite.mockEntryExists()
+	suite.performanceDbServiceMock.On("DeleteDocument", mock.Anything, mock.Anything).Return(db_service.ErrNotFound)
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Params = []gin.Param{
+		{Key: "hospitalId", Value: "test-hospital"},
+		{Key: "entryId", Value: "test-entry"},
+		{Key: "performanceId", Value: "test-performance"},
+	}
+	ctx.Request = httptest.NewRequest("DELETE", "/api/employee-list/test-hospital/entries/test-entry/performances/test-performance", nil)
+
+	sut := suite.employeeListApi()
+
+	sut.DeletePerformanceEntry(ctx)
+	suite.Equal(404, recorder.Code)
+	suite.Contains(recorder.Body.String(), codePerformanceNotFound)
+	suite.performanceDbServiceMock.AssertCalled(suite.T(), "DeleteDocument", mock.Anything, "test-hospital/test-entry/test-performance")
+}
+
+func (suite *HospitalWlSuite) Test_GetPerformance_SingleDocumentRead() {
+	suite.performanceDbServiceMock.On("FindDocument", mock.Anything, mock.Anything).
+		Return(
+			&PerformanceDocument{Id: "test-hospital/test-entry/test-performance", Performance: PerformanceEntry{Id: "test-performance"}},
+			nil,
+		)
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Params = []gin.Param{
+		{Key: "hospitalId", Value: "test-hospital"},
+		{Key: "entryId", Value: "test-entry"},
+		{Key: "performanceId", Value: "test-performance"},
+	}
+	ctx.Request = httptest.NewRequest("GET", "/api/employee-list/test-hospital/entries/test-entry/performances/test-performance", nil)
+
+	sut := suite.employeeListApi()
+
+	sut.GetPerformanceEntry(ctx)
+	suite.Equal(200, recorder.Code)
+	suite.performanceDbServiceMock.AssertCalled(suite.T(), "FindDocument", mock.Anything, "test-hospital/test-entry/test-performance")
+	suite.employeeDbServiceMock.AssertNotCalled(suite.T(), "FindDocument", mock.Anything, mock.Anything)
+}
+
+func (suite *HospitalWlSuite) Test_MigrateActivityDates_OnlyLegacyDocumentsRewritten() {
+	var documents []PerformanceDocument
+	suite.Require().NoError(json.Unmarshal([]byte(`[
+		{"id": "test-hospital/test-entry/legacy", "performance": {"id": "legacy", "activityDate": "01/05/23"}},
+		{"id": "test-hospital/test-entry/current", "performance": {"id": "current", "activityDate": "2023-05-01T00:00:00Z"}}
+	]`), &documents))
+	suite.performanceDbServiceMock.On("ListDocuments", mock.Anything).Return(documents, nil)
+	suite.performanceDbServiceMock.On("CompareAndSwapDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	suite.Require().NoError(MigrateActivityDates(context.Background(), suite.performanceDbServiceMock))
+
+	suite.performanceDbServiceMock.AssertNumberOfCalls(suite.T(), "CompareAndSwapDocument", 1)
+	suite.performanceDbServiceMock.AssertCalled(suite.T(), "CompareAndSwapDocument", mock.Anything, "test-hospital/test-entry/legacy", int64(0),
+		mock.MatchedBy(func(document *PerformanceDocument) bool {
+			return document.Version == 1 &&
+				document.Performance.ActivityDate.Equal(time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC))
+		}))
+}
+
+func (suite *HospitalWlSuite) Test_MigrateActivityDates_MalformedDocumentsSkipped() {
+	var documents []PerformanceDocument
+	for id, activityDate := range map[string]string{"short": "1/5/23", "malformed": "yesterday"} {
+		data, err := bson.Marshal(bson.M{"id": "test-hospital/test-entry/" + id, "performance": bson.M{
+			"id":           id,
+			"activitydate": activityDate,
+		}})
+		suite.Require().NoError(err)
+		var document PerformanceDocument
+		suite.Require().NoError(bson.Unmarshal(data, &document))
+		documents = append(documents, document)
+	}
+	suite.performanceDbServiceMock.On("ListDocuments", mock.Anything).Return(documents, nil)
+	suite.performanceDbServiceMock.On("CompareAndSwapDocument", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+
+	suite.Require().NoError(MigrateActivityDates(context.Background(), suite.performanceDbServiceMock))
+
+	suite.performanceDbServiceMock.AssertNumberOfCalls(suite.T(), "CompareAndSwapDocument", 1)
+	suite.performanceDbServiceMock.AssertCalled(suite.T(), "CompareAndSwapDocument", mock.Anything, "test-hospital/test-entry/short", int64(0),
+		mock.MatchedBy(func(document *PerformanceDocument) bool {
+			return document.Performance.ActivityDate.Equal(time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC))
+		}))
+}
+
+func (suite *HospitalWlSuite) Test_ActivityTime_MalformedStoredTextKept() {
+	data, err := bson.Marshal(bson.M{"activitydate": "yesterday"})
+	suite.Require().NoError(err)
+	var performance PerformanceEntry
+	suite.Require().NoError(bson.Unmarshal(data, &performance))
+	suite.True(performance.ActivityDate.IsZero())
+
+	data, err = bson.Marshal(performance)
+	suite.Require().NoError(err)
+	suite.Equal("yesterday", bson.Raw(data).Lookup("activitydate").StringValue())
 }
@@ -1,27 +1,220 @@
 package hospital_wl
 
 import (
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
 )
 
 type implHospitalRolesAPI struct {
+	apiDependencies
 }
 
-func NewHospitalRolesApi() HospitalRolesAPI {
-	return &implHospitalRolesAPI{}
+func NewHospitalRolesApi(store Store, clock Clock, idGenerator IdGenerator, logger *log.Logger) HospitalRolesAPI {
+	return &implHospitalRolesAPI{newApiDependencies(store, clock, idGenerator, logger)}
 }
 
 func (o *implHospitalRolesAPI) GetRoles(c *gin.Context) {
-	updateHospitalFunc(c, func(
-		c *gin.Context,
-		hospital *Hospital,
-	) (updatedHospital *Hospital, responseContent interface{}, status int) {
-		result := hospital.PredefinedRoles
-		if result == nil {
-			result = []Role{}
-		}
-		return nil, result, http.StatusOK
+	hospital, err := o.store.Hospitals.FindDocumentWith(c, c.Param("hospitalId"), db_service.ReadOptions{
+		Fields: []string{"predefinedRoles"},
+	})
+	switch err {
+	case nil:
+	case db_service.ErrNotFound:
+		abortWithProblem(c, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
+		return
+	default:
+		abortWithStorageFailure(c, o.logger, "Failed to load hospital from database", err)
+		return
+	}
+
+	result := hospital.PredefinedRoles
+	if result == nil {
+		result = []Role{}
+	}
+	respondWithETag(c, http.StatusOK, result)
+}
+
+func (o *implHospitalRolesAPI) CreateRole(c *gin.Context) {
+	o.updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
+		var role Role
+		if err := c.ShouldBindBodyWithJSON(&role); err != nil {
+			return nil, invalidBodyProblem(err), http.StatusBadRequest
+		}
+
+		if message, ok := validateRoles([]Role{role}); !ok {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidRole, message), http.StatusBadRequest
+		}
+
+		if _, exists := findRole(hospital.PredefinedRoles, role.Code); exists {
+			return nil, newProblem(http.StatusConflict, codeAlreadyExists, "Role with the same code already exists"), http.StatusConflict
+		}
+
+		if !ifMatchSatisfied(c, rolesOf(hospital)) {
+			return nil, newProblem(http.StatusPreconditionFailed, codePreconditionFailed, "Roles were modified"), http.StatusPreconditionFailed
+		}
+
+		hospital.PredefinedRoles = append(hospital.PredefinedRoles, role)
+		return hospital, role, http.StatusCreated
+	})
+}
+
+func (o *implHospitalRolesAPI) UpdateRole(c *gin.Context) {
+	hospitalId := c.Param("hospitalId")
+	code := c.Param("roleCode")
+	var updated Role
+	o.updateHospitalThenFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
+		var role Role
+		if err := c.ShouldBindBodyWithJSON(&role); err != nil {
+			return nil, invalidBodyProblem(err), http.StatusBadRequest
+		}
+
+		if role.Code != "" && role.Code != code {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidRequest, "Role code cannot be changed"), http.StatusBadRequest
+		}
+		role.Code = code
+
+		if message, ok := validateRoles([]Role{role}); !ok {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidRole, message), http.StatusBadRequest
+		}
+
+		index := slices.IndexFunc(hospital.PredefinedRoles, func(role Role) bool { return role.Code == code })
+		if index < 0 {
+			return nil, newProblem(http.StatusNotFound, codeRoleNotFound, "Role not found"), http.StatusNotFound
+		}
+
+		if !ifMatchSatisfied(c, hospital.PredefinedRoles[index]) {
+			return nil, newProblem(http.StatusPreconditionFailed, codePreconditionFailed, "Role was modified"), http.StatusPreconditionFailed
+		}
+
+		hospital.PredefinedRoles[index] = role
+		updated = role
+		if problem, status, ok := o.reportRoleUsers(c, hospitalId, code); !ok {
+			return nil, problem, status
+		}
+		return hospital, role, http.StatusOK
+	}, func(c *gin.Context) bool {
+		// employees keep a copy of the role, the client repeats the update if they cannot be updated
+		if err := reassignRole(c, o.store.Employees, hospitalId, code, updated); err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to update role of employees in database", err)
+			return false
+		}
+		return true
+	})
+}
+
+func (o *implHospitalRolesAPI) DeleteRole(c *gin.Context) {
+	hospitalId := c.Param("hospitalId")
+	code := c.Param("roleCode")
+	reassignTo := c.Query("reassignTo")
+
+	// employees assigned to the role in the meantime keep the removed role until they are updated
+	if reassignTo == "" {
+		roles, ok := o.findHospitalRoles(c, hospitalId)
+		if !ok {
+			return
+		}
+		if _, exists := findRole(roles, code); !exists {
+			abortWithProblem(c, http.StatusNotFound, codeRoleNotFound, "Role not found")
+			return
+		}
+		count, err := o.store.Employees.CountDocuments(c, roleUsageQuery(hospitalId, code))
+		if err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to load employees from database", err)
+			return
+		}
+		if count > 0 {
+			abortWithProblem(c, http.StatusConflict, codeRoleInUse,
+				"Role is assigned to "+strconv.FormatInt(count, 10)+" employees, reassign them with the reassignTo parameter")
+			return
+		}
+	}
+
+	var target Role
+	o.updateHospitalThenFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
+		index := slices.IndexFunc(hospital.PredefinedRoles, func(role Role) bool { return role.Code == code })
+		if index < 0 {
+			return nil, newProblem(http.StatusNotFound, codeRoleNotFound, "Role not found"), http.StatusNotFound
+		}
+
+		if reassignTo != "" {
+			var exists bool
+			target, exists = findRole(hospital.PredefinedRoles, reassignTo)
+			if !exists || reassignTo == code {
+				return nil, newProblem(http.StatusBadRequest, codeInvalidRole, "Role to reassign the employees to must be another predefined role"), http.StatusBadRequest
+			}
+		}
+
+		if !ifMatchSatisfied(c, hospital.PredefinedRoles[index]) {
+			return nil, newProblem(http.StatusPreconditionFailed, codePreconditionFailed, "Role was modified"), http.StatusPreconditionFailed
+		}
+
+		if reassignTo != "" {
+			if problem, status, ok := o.reportRoleUsers(c, hospitalId, code); !ok {
+				return nil, problem, status
+			}
+		}
+		hospital.PredefinedRoles = slices.Delete(hospital.PredefinedRoles, index, index+1)
+		return hospital, nil, http.StatusNoContent
+	}, func(c *gin.Context) bool {
+		// the employees are reassigned only once the role is removed, if they cannot be,
+		// they keep the removed role until they are updated
+		if reassignTo == "" {
+			return true
+		}
+		if err := reassignRole(c, o.store.Employees, hospitalId, code, target); err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to reassign employees in database", err)
+			return false
+		}
+		return true
+	})
+}
+
+// reportRoleUsers reports the employees having the role as updated by a dry run
+func (o *implHospitalRolesAPI) reportRoleUsers(c *gin.Context, hospitalId string, code string) (*Problem, int, bool) {
+	if !isDryRun(c) {
+		return nil, 0, true
+	}
+	ids, err := roleUsers(c, o.store.Employees, hospitalId, code)
+	if err != nil {
+		o.logger.Printf("Failed to load employees with role %v of hospital %v: %v", code, hospitalId, err)
+		return newProblem(http.StatusBadGateway, codeStorageFailure, "Failed to load employees from database"), http.StatusBadGateway, false
+	}
+	for _, id := range ids {
+		reportChange(c, changeUpdate, "entry", id)
+	}
+	return nil, 0, true
+}
+
+func (o *implHospitalRolesAPI) ReorderRoles(c *gin.Context) {
+	o.updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
+		var codes []string
+		if err := c.ShouldBindBodyWithJSON(&codes); err != nil {
+			return nil, invalidBodyProblem(err), http.StatusBadRequest
+		}
+
+		if !ifMatchSatisfied(c, rolesOf(hospital)) {
+			return nil, newProblem(http.StatusPreconditionFailed, codePreconditionFailed, "Roles were modified"), http.StatusPreconditionFailed
+		}
+
+		reordered := make([]Role, 0, len(codes))
+		for _, code := range codes {
+			role, exists := findRole(hospital.PredefinedRoles, code)
+			if !exists || slices.ContainsFunc(reordered, func(role Role) bool { return role.Code == code }) {
+				break
+			}
+			reordered = append(reordered, role)
+		}
+		if len(reordered) != len(codes) || len(reordered) != len(hospital.PredefinedRoles) {
+			return nil, newProblem(http.StatusBadRequest, codeInvalidRequest, "Codes must list every predefined role exactly once"), http.StatusBadRequest
+		}
+
+		hospital.PredefinedRoles = reordered
+		return hospital, rolesOf(hospital), http.StatusOK
 	})
 }
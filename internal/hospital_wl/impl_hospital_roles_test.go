@@ -0,0 +1,124 @@
+package hospital_wl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+func (suite *HospitalApiSuite) createHospitalWithRoles() {
+	suite.request(http.MethodPost, "/api/hospital", `{
+		"id": "hospital-ba",
+		"name": "Hospital BA",
+		"predefinedRoles": [{"value": "Nurse", "code": "nurse"}, {"value": "Doctor", "code": "doctor"}]
+	}`)
+}
+
+func (suite *HospitalApiSuite) Test_CreateRole_Listed() {
+	suite.createHospitalWithRoles()
+
+	recorder := suite.request(http.MethodPost, "/api/employee-list/hospital-ba/role", `{"value": "Surgeon", "code": "surgeon"}`)
+	suite.Equal(http.StatusCreated, recorder.Code)
+	suite.Equal(http.StatusConflict, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/role", `{"value": "Other", "code": "nurse"}`).Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/role", `{"value": "No code"}`).Code)
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/role", "")
+	suite.JSONEq(`[
+		{"value": "Nurse", "code": "nurse"},
+		{"value": "Doctor", "code": "doctor"},
+		{"value": "Surgeon", "code": "surgeon"}
+	]`, recorder.Body.String())
+}
+
+func (suite *HospitalApiSuite) Test_UpdateRole_EmployeesUpdated() {
+	suite.createHospitalWithRoles()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "e-1", "name": "Jozko", "role": {"code": "nurse"}}`)
+
+	recorder := suite.request(http.MethodPut, "/api/employee-list/hospital-ba/role/nurse", `{"value": "Head Nurse", "code": "nurse"}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPut, "/api/employee-list/hospital-ba/role/nurse", `{"value": "Nurse", "code": "other"}`).Code)
+	suite.Equal(http.StatusNotFound, suite.request(http.MethodPut, "/api/employee-list/hospital-ba/role/janitor", `{"value": "Janitor", "code": "janitor"}`).Code)
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/e-1", "")
+	var entry EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entry))
+	suite.Equal(Role{Value: "Head Nurse", Code: "nurse"}, entry.Role)
+}
+
+func (suite *HospitalApiSuite) Test_DeleteRole_InUse_RefusedUnlessReassigned() {
+	suite.createHospitalWithRoles()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "e-1", "name": "Jozko", "role": {"code": "nurse"}}`)
+
+	suite.Equal(http.StatusConflict, suite.request(http.MethodDelete, "/api/employee-list/hospital-ba/role/nurse", "").Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodDelete, "/api/employee-list/hospital-ba/role/nurse?reassignTo=janitor", "").Code)
+	suite.Equal(http.StatusNoContent, suite.request(http.MethodDelete, "/api/employee-list/hospital-ba/role/nurse?reassignTo=doctor", "").Code)
+
+	recorder := suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/e-1", "")
+	var entry EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entry))
+	suite.Equal(Role{Value: "Doctor", Code: "doctor"}, entry.Role)
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/role", "")
+	suite.JSONEq(`[{"value": "Doctor", "code": "doctor"}]`, recorder.Body.String())
+}
+
+func (suite *HospitalApiSuite) Test_UpdateRole_EmployeesNotUpdated_Failed() {
+	suite.createHospitalWithRoles()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "e-1", "name": "Jozko", "role": {"code": "nurse"}}`)
+	suite.employeeDbService = failingUpdatesDbService[EmployeeDocument]{suite.employeeDbService}
+	suite.setupRouter()
+
+	recorder := suite.request(http.MethodPut, "/api/employee-list/hospital-ba/role/nurse", `{"value": "Head Nurse", "code": "nurse"}`)
+	suite.Equal(http.StatusBadGateway, recorder.Code)
+}
+
+func (suite *HospitalApiSuite) Test_DeleteRole_PreconditionFailed_EmployeesKeepRole() {
+	suite.createHospitalWithRoles()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "e-1", "name": "Jozko", "role": {"code": "nurse"}}`)
+
+	header := http.Header{"Content-Type": {"application/json"}, "If-Match": {`"stale"`}}
+	recorder := suite.requestWithHeader(http.MethodDelete, "/api/employee-list/hospital-ba/role/nurse?reassignTo=doctor", header, "")
+	suite.Equal(http.StatusPreconditionFailed, recorder.Code)
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/e-1", "")
+	var entry EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entry))
+	suite.Equal(Role{Value: "Nurse", Code: "nurse"}, entry.Role)
+}
+
+// failingUpdatesDbService fails the updates of several documents
+type failingUpdatesDbService[DocType interface{}] struct {
+	db_service.DbService[DocType]
+}
+
+func (s failingUpdatesDbService[DocType]) UpdateDocuments(context.Context, []string, db_service.DocumentsUpdater[DocType]) error {
+	return errors.New("storage unavailable")
+}
+
+func (suite *HospitalApiSuite) Test_ReorderRoles_Reordered() {
+	suite.createHospitalWithRoles()
+
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/role/reorder", `["doctor"]`).Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/role/reorder", `["doctor", "doctor"]`).Code)
+
+	recorder := suite.request(http.MethodPost, "/api/employee-list/hospital-ba/role/reorder", `["doctor", "nurse"]`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.JSONEq(`[{"value": "Doctor", "code": "doctor"}, {"value": "Nurse", "code": "nurse"}]`, recorder.Body.String())
+}
+
+func (suite *HospitalApiSuite) Test_CreateEntry_UnknownRole_Rejected() {
+	suite.createHospitalWithRoles()
+
+	suite.Equal(http.StatusBadRequest,
+		suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "e-1", "role": {"value": "Janitor", "code": "janitor"}}`).Code)
+	suite.Equal(http.StatusBadRequest,
+		suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "e-1", "role": {"value": "Nurse"}}`).Code)
+
+	recorder := suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "e-1", "role": {"code": "nurse"}}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal(http.StatusBadRequest,
+		suite.request(http.MethodPut, "/api/employee-list/hospital-ba/entries/e-1", `{"role": {"code": "janitor"}}`).Code)
+}
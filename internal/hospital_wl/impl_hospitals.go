@@ -1,162 +1,419 @@
 package hospital_wl
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
 
 	"github.com/xkello/ambulance-otapi/internal/db_service"
 	"github.com/gin-gonic/gin"
-	"github.com/google/uuid"
 )
 
 type implHospitalsAPI struct {
+	apiDependencies
 }
 
-func NewHospitalsApi() HospitalsAPI {
-	return &implHospitalsAPI{}
+func NewHospitalsApi(store Store, clock Clock, idGenerator IdGenerator, logger *log.Logger) HospitalsAPI {
+	return &implHospitalsAPI{newApiDependencies(store, clock, idGenerator, logger)}
 }
 
 func (o *implHospitalsAPI) GetHospital(c *gin.Context) {
-	v, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_service not found"})
-		return
+	query := db_service.Query{Fields: []string{"id", "name", "address"}}
+	expandEmployees := false
+	if expand := c.Query("expand"); expand != "" {
+		for _, field := range strings.Split(expand, ",") {
+			switch field {
+			case "employeeList":
+				expandEmployees = true
+			case "predefinedRoles":
+				query.Fields = append(query.Fields, "predefinedRoles")
+			default:
+				abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Unsupported expand value "+field)
+				return
+			}
+		}
 	}
-	db, ok := v.(db_service.DbService[Hospital])
-	if !ok {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "db_service has wrong type"})
+	if !bindPage(c, &query) {
 		return
 	}
+	// unlike the employee lists, the hospital list is always paged, it grows with the deployment
+	if query.Limit == 0 {
+		query.Limit = defaultPageSize
+	}
 
-	hospitals, err := db.ListDocuments(c)
+	hospitals, err := o.store.Hospitals.FindDocuments(c, query)
 	if err != nil {
-		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		abortWithStorageFailure(c, o.logger, "Failed to load hospitals from database", err)
 		return
 	}
-	if hospitals == nil {
-		hospitals = []Hospital{}
+	total, err := o.store.Hospitals.CountDocuments(c, query)
+	if err != nil {
+		abortWithStorageFailure(c, o.logger, "Failed to count hospitals in database", err)
+		return
 	}
-	c.JSON(http.StatusOK, hospitals)
-}
 
-func (o *implHospitalsAPI) CreateHospital(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db not found",
-				"error":   "db not found",
-			})
+	// the employees of the whole page are counted at once
+	hospitalIds := make([]interface{}, 0, len(hospitals))
+	for _, hospital := range hospitals {
+		hospitalIds = append(hospitalIds, hospital.Id)
+	}
+	counts, err := o.store.Employees.CountDocumentsBy(c, db_service.Query{In: map[string][]interface{}{"hospitalId": hospitalIds}}, "hospitalId")
+	if err != nil {
+		abortWithStorageFailure(c, o.logger, "Failed to count employees in database", err)
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Hospital])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db context is not of required type",
-				"error":   "cannot cast db context to db_service.DbService",
-			})
-		return
+	summaries := make([]HospitalSummary, 0, len(hospitals))
+	for _, hospital := range hospitals {
+		summary := HospitalSummary{
+			Id:              hospital.Id,
+			Name:            hospital.Name,
+			Address:         hospital.Address,
+			PredefinedRoles: hospital.PredefinedRoles,
+		}
+		count := counts[hospital.Id]
+		// the list is open to all callers, employees are listed only to the callers allowed to read them
+		if expandEmployees && authorized(c, hospital.Id, PermissionReadEmployees) {
+			if summary.EmployeeList, err = o.findHospitalEmployees(c, hospital.Id); err != nil {
+				abortWithStorageFailure(c, o.logger, "Failed to load employees from database", err)
+				return
+			}
+			count = int64(len(summary.EmployeeList))
+		}
+		summary.EmployeeCount = int32(count)
+		summaries = append(summaries, summary)
 	}
+	c.Header("X-Total-Count", strconv.FormatInt(total, 10))
+	respondAudited(c, http.StatusOK, summaries)
+}
 
+func (o *implHospitalsAPI) CreateHospital(c *gin.Context) {
+	// the hospital does not exist yet, so the keys are not scoped by hospital
+	o.idempotentFunc(c, "", replayedAs[Hospital], o.createHospital)
+}
+
+func (o *implHospitalsAPI) createHospital(c *gin.Context) {
 	hospital := Hospital{}
-	err := c.BindJSON(&hospital)
+	err := c.ShouldBindJSON(&hospital)
 	if err != nil {
-		c.JSON(
-			http.StatusBadRequest,
-			gin.H{
-				"status":  "Bad Request",
-				"message": "Invalid request body",
-				"error":   err.Error(),
-			})
+		respondWithProblem(c, invalidBodyProblem(err))
 		return
 	}
 
 	if hospital.Id == "" {
-		hospital.Id = uuid.New().String()
+		hospital.Id = o.newId()
+	}
+
+	if message, ok := validateRoles(hospital.PredefinedRoles); !ok {
+		abortWithProblem(c, http.StatusBadRequest, codeInvalidRole, message)
+		return
+	}
+
+	for i := range hospital.EmployeeList {
+		if hospital.EmployeeList[i].Id == "" || hospital.EmployeeList[i].Id == "@new" {
+			hospital.EmployeeList[i].Id = o.newId()
+		}
+		if problem := o.assignPerformanceIds(hospital.EmployeeList[i].Performances); problem != nil {
+			respondWithProblem(c, problem)
+			return
+		}
+	}
+	if len(hospital.EmployeeList) > 0 {
+		if problem := validatePayloadItems("EmployeeListEntry", "/employeeList", hospital.EmployeeList); problem != nil {
+			respondWithProblem(c, problem)
+			return
+		}
+	}
+
+	// employees are stored in their own collection
+	employees := make([]*EmployeeDocument, 0, len(hospital.EmployeeList))
+	employeeIds := make([]string, 0, len(hospital.EmployeeList))
+	for i := range hospital.EmployeeList {
+		var ok bool
+		if hospital.EmployeeList[i].Role, ok = resolveRole(hospital.PredefinedRoles, hospital.EmployeeList[i].Role); !ok {
+			abortWithProblem(c, http.StatusBadRequest, codeInvalidRole,
+				"Role of entry "+hospital.EmployeeList[i].Id+" is not one of the predefined roles of the hospital")
+			return
+		}
+		document := newEmployeeDocument(hospital.Id, hospital.EmployeeList[i])
+		if slices.Contains(employeeIds, document.Id) {
+			abortWithProblem(c, http.StatusBadRequest, codeInvalidRequest, "Duplicate employee list entry id "+document.Entry.Id)
+			return
+		}
+		employees = append(employees, document)
+		employeeIds = append(employeeIds, document.Id)
 	}
+	stored := hospital
+	stored.EmployeeList = nil
 
-	err = db.CreateDocument(c, hospital.Id, &hospital)
+	if isDryRun(c) {
+		err = o.previewHospitalCreation(c, hospital, employeeIds)
+	} else {
+		err = o.store.Hospitals.CreateDocument(c, hospital.Id, &stored)
+	}
+
+	if err == nil && len(employees) > 0 && !isDryRun(c) {
+		err = o.store.Employees.UpdateDocuments(c, employeeIds, func(existing []*EmployeeDocument) ([]*EmployeeDocument, error) {
+			if slices.ContainsFunc(existing, func(document *EmployeeDocument) bool { return document != nil }) {
+				// employees left over from a deleted hospital with the same id
+				return nil, db_service.ErrConflict
+			}
+			return employees, nil
+		})
+		if err == nil {
+			if err = o.createHospitalPerformances(c, hospital); err != nil {
+				o.removeHospitalEmployees(c, hospital.Id)
+			}
+		}
+		if err != nil {
+			if deleteErr := o.store.Hospitals.DeleteDocument(c, hospital.Id); deleteErr != nil {
+				o.logger.Printf("Failed to remove hospital %v after its employees could not be stored: %v", hospital.Id, deleteErr)
+			}
+		}
+	}
 
 	switch err {
 	case nil:
-		c.JSON(
-			http.StatusCreated,
-			hospital,
-		)
+		c.Header("ETag", etagOf(stored))
+		respondAudited(c, http.StatusCreated, hospital)
 	case db_service.ErrConflict:
-		c.JSON(
-			http.StatusConflict,
-			gin.H{
-				"status":  "Conflict",
-				"message": "Hospital already exists",
-				"error":   err.Error(),
-			},
-		)
+		abortWithProblem(c, http.StatusConflict, codeAlreadyExists, "Hospital already exists")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to create hospital in database",
-				"error":   err.Error(),
-			},
-		)
+		abortWithStorageFailure(c, o.logger, "Failed to create hospital in database", err)
 	}
 }
 
-func (o *implHospitalsAPI) DeleteHospital(c *gin.Context) {
-	value, exists := c.Get("db_service")
-	if !exists {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
+// createHospitalPerformances stores the performances of the employees of a created hospital,
+// the performances left behind by a hospital with the same id are dropped
+func (o *implHospitalsAPI) createHospitalPerformances(c *gin.Context, hospital Hospital) error {
+	if _, err := o.store.Performances.DeleteDocuments(c, hospitalPerformancesQuery(hospital.Id)); err != nil {
+		return err
+	}
+	for _, entry := range hospital.EmployeeList {
+		if err := o.replacePerformances(c, hospital.Id, entry.Id, nil, entry.Performances); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeHospitalEmployees removes the employees of a hospital which could not be created completely,
+// the failures are only logged
+func (o *implHospitalsAPI) removeHospitalEmployees(c *gin.Context, hospitalId string) {
+	if _, err := o.store.Performances.DeleteDocuments(c, hospitalPerformancesQuery(hospitalId)); err != nil {
+		o.logger.Printf("Failed to remove performances of hospital %v which could not be created: %v", hospitalId, err)
+	}
+	if _, err := o.store.Employees.DeleteDocuments(c, hospitalEmployeesQuery(hospitalId)); err != nil {
+		o.logger.Printf("Failed to remove employees of hospital %v which could not be created: %v", hospitalId, err)
+	}
+}
+
+// previewHospitalCreation checks the conflicts the creation of the hospital would run into
+// and reports the documents it would create
+func (o *implHospitalsAPI) previewHospitalCreation(c *gin.Context, hospital Hospital, employeeIds []string) error {
+	_, err := o.store.Hospitals.FindDocumentWith(c, hospital.Id, db_service.ReadOptions{Fields: []string{"id"}})
+	switch err {
+	case nil:
+		return db_service.ErrConflict
+	case db_service.ErrNotFound:
+		// continue
+	default:
+		return err
+	}
+
+	if len(employeeIds) > 0 {
+		err = o.store.Employees.UpdateDocuments(c, employeeIds, func(existing []*EmployeeDocument) ([]*EmployeeDocument, error) {
+			if slices.ContainsFunc(existing, func(document *EmployeeDocument) bool { return document != nil }) {
+				return nil, db_service.ErrConflict
+			}
+			return nil, errDryRun
+		})
+		if !errors.Is(err, errDryRun) {
+			return err
+		}
+	}
+
+	reportChange(c, changeCreate, "hospital", hospital.Id)
+	for _, id := range employeeIds {
+		reportChange(c, changeCreate, "entry", id)
+	}
+	for _, entry := range hospital.EmployeeList {
+		reportPerformanceChanges(c, changeCreate, employeeDocumentId(hospital.Id, entry.Id), entry.Performances)
+	}
+	return nil
+}
+
+// previewHospitalDeletion reports the hospital, its employees and their performances as deleted
+// without deleting them
+func (o *implHospitalsAPI) previewHospitalDeletion(c *gin.Context, hospitalId string) {
+	if !o.hospitalExists(c, hospitalId) {
 		return
 	}
 
-	db, ok := value.(db_service.DbService[Hospital])
-	if !ok {
-		c.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
+	query := hospitalEmployeesQuery(hospitalId)
+	query.Fields = []string{"id"}
+	documents, err := o.store.Employees.FindDocuments(c, query)
+	if err != nil {
+		abortWithStorageFailure(c, o.logger, "Failed to load employees from database", err)
 		return
 	}
 
+	performanceQuery := hospitalPerformancesQuery(hospitalId)
+	performanceQuery.Fields = []string{"id"}
+	performances, err := o.store.Performances.FindDocuments(c, performanceQuery)
+	if err != nil {
+		abortWithStorageFailure(c, o.logger, "Failed to load performances from database", err)
+		return
+	}
+
+	reportChange(c, changeDelete, "hospital", hospitalId)
+	for _, document := range documents {
+		reportChange(c, changeDelete, "entry", document.Id)
+	}
+	for _, document := range performances {
+		reportChange(c, changeDelete, "performance", document.Id)
+	}
+	c.AbortWithStatus(http.StatusNoContent)
+}
+
+func (o *implHospitalsAPI) DeleteHospital(c *gin.Context) {
 	hospitalId := c.Param("hospitalId")
-	err := db.DeleteDocument(c, hospitalId)
+	conditional := c.GetHeader("If-Match") != ""
+
+	// the hospital is removed first, so that a failed precondition leaves the data untouched,
+	// its performances and employees left behind by a failure are removed by a retried request
+	var version int64
+	if conditional || isDryRun(c) {
+		hospital, err := o.store.Hospitals.FindDocument(c, hospitalId)
+		switch err {
+		case nil:
+		case db_service.ErrNotFound:
+			abortWithProblem(c, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
+			return
+		default:
+			abortWithStorageFailure(c, o.logger, "Failed to load hospital from database", err)
+			return
+		}
+		if !ifMatchSatisfied(c, hospital) {
+			abortWithProblem(c, http.StatusPreconditionFailed, codePreconditionFailed, "Hospital was modified")
+			return
+		}
+		if isDryRun(c) {
+			o.previewHospitalDeletion(c, hospitalId)
+			return
+		}
+		version = hospital.Version
+	}
+
+	var err error
+	if !conditional {
+		err = o.store.Hospitals.DeleteDocument(c, hospitalId)
+	} else {
+		// the hospital is removed only in the state the precondition was evaluated against
+		err = o.store.Hospitals.UpdateDocuments(c, []string{hospitalId}, func(hospitals []*Hospital) ([]*Hospital, error) {
+			switch {
+			case hospitals[0] == nil:
+				return nil, db_service.ErrNotFound
+			case hospitals[0].Version != version:
+				return nil, errUpdateRejected
+			}
+			return []*Hospital{nil}, nil
+		})
+	}
+
+	switch {
+	case err == nil, errors.Is(err, db_service.ErrNotFound):
+		if _, err := o.store.Performances.DeleteDocuments(c, hospitalPerformancesQuery(hospitalId)); err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to delete performances from database", err)
+			return
+		}
+		if _, err := o.store.Employees.DeleteDocuments(c, hospitalEmployeesQuery(hospitalId)); err != nil {
+			abortWithStorageFailure(c, o.logger, "Failed to delete employees from database", err)
+			return
+		}
+		if err != nil {
+			abortWithProblem(c, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
+			return
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	case errors.Is(err, errUpdateRejected):
+		abortWithProblem(c, http.StatusPreconditionFailed, codePreconditionFailed, "Hospital was modified while processing the request")
+	default:
+		abortWithStorageFailure(c, o.logger, "Failed to delete hospital from database", err)
+	}
+}
 
+func (o *implHospitalsAPI) GetHospitalDetail(c *gin.Context) {
+	hospital, err := o.store.Hospitals.FindDocument(c, c.Param("hospitalId"))
 	switch err {
 	case nil:
-		c.AbortWithStatus(http.StatusNoContent)
+		respondWithETag(c, http.StatusOK, hospital)
 	case db_service.ErrNotFound:
-		c.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Hospital not found",
-				"error":   err.Error(),
-			},
-		)
+		abortWithProblem(c, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
 	default:
-		c.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to delete hospital from database",
-				"error":   err.Error(),
-			},
-		)
+		abortWithStorageFailure(c, o.logger, "Failed to load hospital from database", err)
 	}
 }
+
+func (o *implHospitalsAPI) UpdateHospital(c *gin.Context) {
+	o.updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
+		var update Hospital
+		if err := c.ShouldBindBodyWithJSON(&update); err != nil {
+			return nil, invalidBodyProblem(err), http.StatusBadRequest
+		}
+		return replaceHospital(c, hospital, update)
+	})
+}
+
+func (o *implHospitalsAPI) PatchHospital(c *gin.Context) {
+	if c.ContentType() == jsonPatchContentType {
+		abortWithProblem(c, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Hospital supports only JSON merge patch")
+		return
+	}
+
+	o.updateHospitalFunc(c, func(c *gin.Context, hospital *Hospital) (*Hospital, interface{}, int) {
+		var update Hospital
+		switch err := applyPatch(c, hospital, &update); err {
+		case nil:
+			return replaceHospital(c, hospital, update)
+		case errUnsupportedPatch:
+			return nil, newProblem(http.StatusUnsupportedMediaType, codeUnsupportedMediaType,
+				"Hospital supports only JSON merge patch"), http.StatusUnsupportedMediaType
+		default:
+			return nil, newProblem(http.StatusBadRequest, codeInvalidRequest,
+				"Invalid merge patch: "+err.Error()), http.StatusBadRequest
+		}
+	})
+}
+
+// replaceHospital validates the new state of the hospital, the id and version are kept
+// and employees and roles are managed only by their own endpoints
+func replaceHospital(c *gin.Context, current *Hospital, update Hospital) (*Hospital, interface{}, int) {
+	if update.Id != "" && update.Id != current.Id {
+		return nil, newProblem(http.StatusBadRequest, codeInvalidRequest, "Hospital id cannot be changed"), http.StatusBadRequest
+	}
+
+	if strings.TrimSpace(update.Name) == "" {
+		return nil, newProblem(http.StatusBadRequest, codeInvalidRequest, "Hospital name must not be empty"), http.StatusBadRequest
+	}
+
+	if len(update.EmployeeList) > 0 {
+		return nil, newProblem(http.StatusBadRequest, codeInvalidRequest, "Employee list is managed by the employee list endpoints"), http.StatusBadRequest
+	}
+
+	if update.PredefinedRoles != nil && !slices.Equal(update.PredefinedRoles, current.PredefinedRoles) {
+		return nil, newProblem(http.StatusBadRequest, codeInvalidRequest, "Predefined roles are managed by the role endpoints"), http.StatusBadRequest
+	}
+
+	if !ifMatchSatisfied(c, current) {
+		return nil, newProblem(http.StatusPreconditionFailed, codePreconditionFailed, "Hospital was modified"), http.StatusPreconditionFailed
+	}
+
+	update.Id = current.Id
+	update.PredefinedRoles = current.PredefinedRoles
+	update.Version = current.Version
+	return &update, &update, http.StatusOK
+}
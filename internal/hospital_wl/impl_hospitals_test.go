@@ -0,0 +1,653 @@
+package hospital_wl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// HospitalApiSuite exercises the complete router backed by the in-memory storage
+type HospitalApiSuite struct {
+	suite.Suite
+	dbService         db_service.DbService[Hospital]
+	employeeDbService db_service.DbService[EmployeeDocument]
+	// performanceDbService keeps the performances of the employees
+	performanceDbService db_service.DbService[PerformanceDocument]
+	// idempotencyDbService keeps the responses to the requests with Idempotency-Key
+	idempotencyDbService db_service.DbService[IdempotencyRecord]
+	// accessLogDbService keeps the access log, the API gets it append-only
+	accessLogDbService db_service.DbService[AccessRecord]
+	router             *gin.Engine
+	// now is the time of the API clock
+	now time.Time
+	// principal is the caller of the requests, the developer unless the test sets another
+	principal *Principal
+}
+
+// testActivityDate is the activity date of performances created by the tests
+var testActivityDate = NewActivityTime(time.Date(2023, 5, 1, 0, 0, 0, 0, time.UTC))
+
+func TestHospitalApiSuite(t *testing.T) {
+	suite.Run(t, new(HospitalApiSuite))
+}
+
+func newMemoryService[DocType interface{}](t *testing.T) db_service.DbService[DocType] {
+	svc, err := db_service.NewMemoryService[DocType](db_service.MemoryServiceConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return svc
+}
+
+func (suite *HospitalApiSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.dbService = newMemoryService[Hospital](suite.T())
+	suite.employeeDbService = newMemoryService[EmployeeDocument](suite.T())
+	suite.performanceDbService = newMemoryService[PerformanceDocument](suite.T())
+	suite.idempotencyDbService = newMemoryService[IdempotencyRecord](suite.T())
+	suite.accessLogDbService = newMemoryService[AccessRecord](suite.T())
+	suite.now = time.Now()
+	suite.principal = developmentPrincipal
+	suite.setupRouter()
+}
+
+// setupRouter routes the requests to the API using the suite storages
+func (suite *HospitalApiSuite) setupRouter() {
+	suite.router = gin.New()
+	store := Store{
+		Hospitals:    suite.dbService,
+		Employees:    suite.employeeDbService,
+		Performances: suite.performanceDbService,
+		Idempotency:  suite.idempotencyDbService,
+		AccessLog:    db_service.NewAppendOnlyService(suite.accessLogDbService),
+	}
+	clock := func() time.Time { return suite.now }
+	handleFunctions := ApiHandleFunctions{
+		AccessLogAPI:            NewAccessLogApi(store, clock, UuidGenerator, log.Default()),
+		HospitalEmployeeListAPI: NewHospitalEmployeeListApi(store, clock, UuidGenerator, log.Default()),
+		HospitalRolesAPI:        NewHospitalRolesApi(store, clock, UuidGenerator, log.Default()),
+		HospitalsAPI:            NewHospitalsApi(store, clock, UuidGenerator, log.Default()),
+	}
+	// responses violating the specification are logged, like during development
+	validator, err := NewOpenApiValidator(handleFunctions, OpenApiValidatorConfig{ValidateResponses: true})
+	suite.Require().NoError(err)
+	authorizer, err := NewAuthorizer(handleFunctions, DefaultPolicy)
+	suite.Require().NoError(err)
+	// the caller is set instead of authenticating the requests
+	auditor := NewAccessAuditor(store, clock, UuidGenerator, log.Default())
+	suite.router.Use(func(ctx *gin.Context) { ctx.Set(principalKey, suite.principal) }, authorizer, auditor, validator)
+	NewRouterWithGinEngine(suite.router, handleFunctions)
+}
+
+func (suite *HospitalApiSuite) request(method string, path string, body string) *httptest.ResponseRecorder {
+	return suite.requestWithType(method, path, "application/json", body)
+}
+
+func (suite *HospitalApiSuite) requestWithType(method string, path string, contentType string, body string) *httptest.ResponseRecorder {
+	return suite.requestWithHeader(method, path, http.Header{"Content-Type": {contentType}}, body)
+}
+
+func (suite *HospitalApiSuite) requestWithHeader(method string, path string, header http.Header, body string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(method, path, strings.NewReader(body))
+	request.Header = header
+	suite.router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *HospitalApiSuite) Test_CreateHospital_Listed() {
+	recorder := suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.Equal(http.StatusCreated, recorder.Code)
+
+	recorder = suite.request(http.MethodGet, "/api/hospital", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	var hospitals []Hospital
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &hospitals))
+	suite.Len(hospitals, 1)
+	suite.Equal("Hospital BA", hospitals[0].Name)
+}
+
+func (suite *HospitalApiSuite) Test_TransferEntry_MovedToTarget() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-nr", "name": "Hospital NR"}`)
+	recorder := suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries",
+		`{"id": "entry-1", "name": "Jozko", "performances": [{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01", "patientName": "John Doe", "details": "Routine"}]}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+
+	recorder = suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/transfer", `{"targetHospitalId": "hospital-nr"}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Contains(recorder.Body.String(), `"p-1"`)
+
+	_, err := suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-ba/entry-1")
+	suite.Equal(db_service.ErrNotFound, err)
+	target, err := suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-nr/entry-1")
+	suite.Require().NoError(err)
+	suite.Equal("hospital-nr", target.HospitalId)
+	suite.Equal("Jozko", target.Entry.Name)
+	suite.Empty(suite.storedPerformances("hospital-ba/entry-1"))
+	suite.Equal([]PerformanceEntry{{Id: "p-1", ActivityType: "checkup", ActivityDate: testActivityDate, PatientName: "John Doe", Details: "Routine"}},
+		suite.storedPerformances("hospital-nr/entry-1"))
+}
+
+func (suite *HospitalApiSuite) Test_TransferEntry_MissingTarget_SourceUntouched() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+
+	recorder := suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/transfer", `{"targetHospitalId": "hospital-xx"}`)
+	suite.Equal(http.StatusNotFound, recorder.Code)
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+}
+
+func (suite *HospitalApiSuite) Test_TransferEntry_PerformancesNotCopied_EntryKept() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-nr", "name": "Hospital NR"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries",
+		`{"id": "entry-1", "name": "Jozko", "performances": [{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01"}]}`)
+	suite.performanceDbService = failingUpdatesDbService[PerformanceDocument]{suite.performanceDbService}
+	suite.setupRouter()
+
+	recorder := suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/transfer", `{"targetHospitalId": "hospital-nr"}`)
+	suite.Equal(http.StatusBadGateway, recorder.Code)
+	suite.Equal(http.StatusOK, suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1", "").Code)
+	suite.Len(suite.storedPerformances("hospital-ba/entry-1"), 1)
+	suite.Equal(http.StatusNotFound, suite.request(http.MethodGet, "/api/employee-list/hospital-nr/entries/entry-1", "").Code)
+}
+
+func (suite *HospitalApiSuite) Test_TransferEntry_EntryNotMoved_CopiesRemoved() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-nr", "name": "Hospital NR"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries",
+		`{"id": "entry-1", "name": "Jozko", "performances": [{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01"}]}`)
+	employees := suite.employeeDbService
+	suite.employeeDbService = failingWritesDbService[EmployeeDocument]{employees}
+	suite.setupRouter()
+
+	recorder := suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/transfer", `{"targetHospitalId": "hospital-nr"}`)
+	suite.Equal(http.StatusBadGateway, recorder.Code)
+	suite.Len(suite.storedPerformances("hospital-ba/entry-1"), 1)
+	suite.Empty(suite.storedPerformances("hospital-nr/entry-1"))
+
+	suite.employeeDbService = employees
+	suite.setupRouter()
+	suite.Equal(http.StatusOK, suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1", "").Code)
+}
+
+// failingWritesDbService fails the updates which would change the documents, the documents can still be read
+type failingWritesDbService[DocType interface{}] struct {
+	db_service.DbService[DocType]
+}
+
+func (s failingWritesDbService[DocType]) UpdateDocuments(ctx context.Context, ids []string, updater db_service.DocumentsUpdater[DocType]) error {
+	return s.DbService.UpdateDocuments(ctx, ids, func(documents []*DocType) ([]*DocType, error) {
+		if _, err := updater(documents); err != nil {
+			return nil, err
+		}
+		return nil, errors.New("storage unavailable")
+	})
+}
+
+func (suite *HospitalApiSuite) Test_CreateHospital_EmployeesListedSeparately() {
+	recorder := suite.request(http.MethodPost, "/api/hospital",
+		`{"id": "hospital-ba", "name": "Hospital BA", "employeeList": [{"id": "entry-1", "name": "Jozko"}]}`)
+	suite.Equal(http.StatusCreated, recorder.Code)
+
+	stored, err := suite.dbService.FindDocument(suite.T().Context(), "hospital-ba")
+	suite.Require().NoError(err)
+	suite.Empty(stored.EmployeeList)
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	var entries []EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entries))
+	suite.Len(entries, 1)
+	suite.Equal("Jozko", entries[0].Name)
+}
+
+func (suite *HospitalApiSuite) Test_DeleteHospital_IfMatchEvaluated() {
+	recorder := suite.request(http.MethodPost, "/api/hospital",
+		`{"id": "hospital-ba", "name": "Hospital BA", "employeeList": [{"id": "entry-1", "name": "Jozko", "performances": [{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01", "patientName": "John Doe", "details": "Routine"}]}]}`)
+	etag := recorder.Header().Get("ETag")
+	suite.Len(suite.storedPerformances("hospital-ba/entry-1"), 1)
+
+	header := http.Header{"If-Match": {`"stale"`}}
+	suite.Equal(http.StatusPreconditionFailed, suite.requestWithHeader(http.MethodDelete, "/api/hospital/hospital-ba", header, "").Code)
+	header = http.Header{"If-Match": {"W/" + etag}}
+	suite.Equal(http.StatusPreconditionFailed, suite.requestWithHeader(http.MethodDelete, "/api/hospital/hospital-ba", header, "").Code,
+		"weak validator must not match")
+	suite.Equal(http.StatusOK, suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1", "").Code)
+
+	header = http.Header{"If-Match": {etag}}
+	suite.Equal(http.StatusNoContent, suite.requestWithHeader(http.MethodDelete, "/api/hospital/hospital-ba", header, "").Code)
+	suite.Equal(http.StatusNotFound, suite.requestWithHeader(http.MethodDelete, "/api/hospital/hospital-ba", header, "").Code)
+	count, err := suite.employeeDbService.CountDocuments(suite.T().Context(), hospitalEmployeesQuery("hospital-ba"))
+	suite.NoError(err)
+	suite.Zero(count)
+	suite.Empty(suite.storedPerformances("hospital-ba/entry-1"))
+}
+
+func (suite *HospitalApiSuite) Test_DeleteHospital_ChangedAfterPrecondition_DataKept() {
+	recorder := suite.request(http.MethodPost, "/api/hospital",
+		`{"id": "hospital-ba", "name": "Hospital BA", "employeeList": [{"id": "entry-1", "name": "Jozko", "performances": [{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01"}]}]}`)
+	etag := recorder.Header().Get("ETag")
+	hospitals := suite.dbService
+	suite.dbService = &changedAfterReadDbService[Hospital]{DbService: hospitals, change: func(ctx context.Context) {
+		hospital, err := hospitals.FindDocument(ctx, "hospital-ba")
+		suite.Require().NoError(err)
+		hospital.Version++
+		suite.Require().NoError(hospitals.UpdateDocument(ctx, "hospital-ba", hospital))
+	}}
+	suite.setupRouter()
+
+	header := http.Header{"If-Match": {etag}}
+	suite.Equal(http.StatusPreconditionFailed, suite.requestWithHeader(http.MethodDelete, "/api/hospital/hospital-ba", header, "").Code)
+	suite.Equal(http.StatusOK, suite.request(http.MethodGet, "/api/hospital/hospital-ba", "").Code)
+	suite.Equal(http.StatusOK, suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1", "").Code)
+	suite.Len(suite.storedPerformances("hospital-ba/entry-1"), 1)
+}
+
+func (suite *HospitalApiSuite) Test_DeleteEntry_ChangedAfterPrecondition_PerformancesKept() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries",
+		`{"id": "entry-1", "name": "Jozko", "performances": [{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01"}]}`)
+	path := "/api/employee-list/hospital-ba/entries/entry-1"
+	etag := suite.request(http.MethodGet, path, "").Header().Get("ETag")
+	employees := suite.employeeDbService
+	suite.employeeDbService = &changedAfterReadDbService[EmployeeDocument]{DbService: employees, change: func(ctx context.Context) {
+		document, err := employees.FindDocument(ctx, "hospital-ba/entry-1")
+		suite.Require().NoError(err)
+		document.Version++
+		suite.Require().NoError(employees.UpdateDocument(ctx, document.Id, document))
+	}}
+	suite.setupRouter()
+
+	header := http.Header{"If-Match": {etag}}
+	suite.Equal(http.StatusPreconditionFailed, suite.requestWithHeader(http.MethodDelete, path, header, "").Code)
+	suite.Equal(http.StatusOK, suite.request(http.MethodGet, path, "").Code)
+	suite.Len(suite.storedPerformances("hospital-ba/entry-1"), 1)
+}
+
+func (suite *HospitalApiSuite) Test_DeleteHospital_EmployeesNotDeleted_RemovedByRetry() {
+	suite.request(http.MethodPost, "/api/hospital",
+		`{"id": "hospital-ba", "name": "Hospital BA", "employeeList": [{"id": "entry-1", "name": "Jozko"}]}`)
+	employees := suite.employeeDbService
+	suite.employeeDbService = failingDeletesDbService[EmployeeDocument]{employees}
+	suite.setupRouter()
+
+	suite.Equal(http.StatusBadGateway, suite.request(http.MethodDelete, "/api/hospital/hospital-ba", "").Code)
+	suite.Equal(http.StatusNotFound, suite.request(http.MethodGet, "/api/hospital/hospital-ba", "").Code)
+
+	suite.employeeDbService = employees
+	suite.setupRouter()
+	suite.Equal(http.StatusNotFound, suite.request(http.MethodDelete, "/api/hospital/hospital-ba", "").Code)
+	count, err := suite.employeeDbService.CountDocuments(suite.T().Context(), hospitalEmployeesQuery("hospital-ba"))
+	suite.NoError(err)
+	suite.Zero(count)
+	suite.Equal(http.StatusCreated, suite.request(http.MethodPost, "/api/hospital",
+		`{"id": "hospital-ba", "name": "Hospital BA", "employeeList": [{"id": "entry-1", "name": "Jozko"}]}`).Code)
+}
+
+// changedAfterReadDbService changes the stored documents once after the first document is read,
+// like a concurrent request
+type changedAfterReadDbService[DocType interface{}] struct {
+	db_service.DbService[DocType]
+	change func(ctx context.Context)
+}
+
+func (s *changedAfterReadDbService[DocType]) FindDocument(ctx context.Context, id string) (*DocType, error) {
+	document, err := s.DbService.FindDocument(ctx, id)
+	if s.change != nil {
+		s.change(ctx)
+		s.change = nil
+	}
+	return document, err
+}
+
+// failingDeletesDbService fails the removal of the documents matching a query
+type failingDeletesDbService[DocType interface{}] struct {
+	db_service.DbService[DocType]
+}
+
+func (s failingDeletesDbService[DocType]) DeleteDocuments(context.Context, db_service.Query) (int64, error) {
+	return 0, errors.New("storage unavailable")
+}
+
+func (suite *HospitalApiSuite) Test_MigrateEmbeddedEmployees_Moved() {
+	ctx := suite.T().Context()
+	suite.Require().NoError(suite.dbService.CreateDocument(ctx, "hospital-ba", &Hospital{
+		Id:           "hospital-ba",
+		Name:         "Hospital BA",
+		EmployeeList: []EmployeeListEntry{{Id: "entry-1", Name: "Jozko"}, {Name: "Ferko"}},
+	}))
+
+	suite.Require().NoError(MigrateEmbeddedEmployees(ctx, suite.dbService, suite.employeeDbService, suite.performanceDbService))
+	// repeated migration must not duplicate the employees
+	suite.Require().NoError(MigrateEmbeddedEmployees(ctx, suite.dbService, suite.employeeDbService, suite.performanceDbService))
+
+	stored, err := suite.dbService.FindDocument(ctx, "hospital-ba")
+	suite.Require().NoError(err)
+	suite.Empty(stored.EmployeeList)
+	suite.Equal(int64(1), stored.Version)
+
+	recorder := suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries", "")
+	var entries []EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entries))
+	suite.Len(entries, 2)
+	for _, entry := range entries {
+		suite.NotEmpty(entry.Id)
+	}
+}
+
+func (suite *HospitalApiSuite) Test_Performances_UpdatedInPlace() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+	base := "/api/employee-list/hospital-ba/entries/entry-1/performances"
+
+	suite.Equal(http.StatusOK, suite.request(http.MethodPost, base, `{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01"}`).Code)
+	suite.Equal(http.StatusOK, suite.request(http.MethodPost, base, `{"id": "p-2", "activityType": "surgery", "activityDate": "2023-05-01"}`).Code)
+	suite.Equal(http.StatusOK, suite.request(http.MethodPut, base+"/p-1", `{"id": "p-1", "activityType": "examination", "activityDate": "2023-05-01"}`).Code)
+	suite.Equal(http.StatusNoContent, suite.request(http.MethodDelete, base+"/p-2", "").Code)
+	suite.Equal(http.StatusNotFound, suite.request(http.MethodDelete, base+"/p-2", "").Code)
+
+	stored, err := suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-ba/entry-1")
+	suite.Require().NoError(err)
+	suite.Empty(stored.Entry.Performances, "performances are stored apart from the entry")
+	suite.Equal(int64(0), stored.Version)
+
+	performances, err := suite.performanceDbService.ListDocuments(suite.T().Context())
+	suite.Require().NoError(err)
+	suite.Equal([]PerformanceDocument{{
+		Id:          "hospital-ba/entry-1/p-1",
+		HospitalId:  "hospital-ba",
+		EmployeeId:  "hospital-ba/entry-1",
+		Performance: PerformanceEntry{Id: "p-1", ActivityType: "examination", ActivityDate: testActivityDate},
+		Version:     1,
+	}}, performances)
+}
+
+func (suite *HospitalApiSuite) Test_GetPerformance_SingleEntryReturned() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA", "predefinedRoles": [{"value": "Nurse", "code": "nurse"}]}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+	base := "/api/employee-list/hospital-ba/entries/entry-1/performances"
+	suite.request(http.MethodPost, base, `{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01"}`)
+	suite.request(http.MethodPost, base, `{"id": "p-2", "activityType": "surgery", "activityDate": "2023-05-01"}`)
+
+	recorder := suite.request(http.MethodGet, base+"/p-2", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	var performance PerformanceEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &performance))
+	suite.Equal(PerformanceEntry{Id: "p-2", ActivityType: "surgery", ActivityDate: testActivityDate}, performance)
+	suite.Equal(http.StatusNotFound, suite.request(http.MethodGet, base+"/p-3", "").Code)
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/role", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.JSONEq(`[{"value": "Nurse", "code": "nurse"}]`, recorder.Body.String())
+}
+
+func (suite *HospitalApiSuite) Test_GetEntries_FilteredSortedAndPaged() {
+	suite.request(http.MethodPost, "/api/hospital",
+		`{"id": "hospital-ba", "name": "Hospital BA", "predefinedRoles": [{"value": "Nurse", "code": "nurse"}, {"value": "Doctor", "code": "doctor"}]}`)
+	for _, entry := range []string{
+		`{"id": "e-1", "name": "Jozko", "role": {"code": "nurse"}, "performance": 7}`,
+		`{"id": "e-2", "name": "Ferko", "role": {"code": "doctor"}, "performance": 9}`,
+		`{"id": "e-3", "name": "Janko", "role": {"code": "nurse"}, "performance": 8}`,
+		`{"id": "e-4", "name": "Jozefina", "role": {"code": "nurse"}, "performance": 3}`,
+	} {
+		suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", entry)
+	}
+
+	recorder := suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?role=Nurse&minPerformance=5&sort=-performance&pageSize=1&page=2", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("2", recorder.Header().Get("X-Total-Count"))
+	var entries []EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entries))
+	suite.Len(entries, 1)
+	suite.Equal("e-1", entries[0].Id)
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?q=joz&sort=name", "")
+	suite.Equal("2", recorder.Header().Get("X-Total-Count"))
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entries))
+	suite.Equal([]string{"Jozefina", "Jozko"}, []string{entries[0].Name, entries[1].Name})
+
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?sort=salary", "").Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?pageSize=1000", "").Code)
+}
+
+func (suite *HospitalApiSuite) Test_GetHospitals_SummariesUnlessExpanded() {
+	suite.request(http.MethodPost, "/api/hospital",
+		`{"id": "hospital-ba", "name": "Hospital BA", "address": "Bratislava", "predefinedRoles": [{"value": "Nurse", "code": "nurse"}],
+		  "employeeList": [{"id": "e-1", "name": "Jozko"}, {"id": "e-2", "name": "Ferko"}]}`)
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-nr", "name": "Hospital NR"}`)
+
+	recorder := suite.request(http.MethodGet, "/api/hospital", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("2", recorder.Header().Get("X-Total-Count"))
+	suite.JSONEq(`[
+		{"id": "hospital-ba", "name": "Hospital BA", "address": "Bratislava", "employeeCount": 2},
+		{"id": "hospital-nr", "name": "Hospital NR", "employeeCount": 0}
+	]`, recorder.Body.String())
+
+	recorder = suite.request(http.MethodGet, "/api/hospital?expand=employeeList,predefinedRoles&pageSize=1", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("2", recorder.Header().Get("X-Total-Count"))
+	var hospitals []HospitalSummary
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &hospitals))
+	suite.Len(hospitals, 1)
+	suite.Len(hospitals[0].EmployeeList, 2)
+	suite.Equal([]Role{{Value: "Nurse", Code: "nurse"}}, hospitals[0].PredefinedRoles)
+
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodGet, "/api/hospital?expand=secrets", "").Code)
+}
+
+func (suite *HospitalApiSuite) Test_GetHospitals_PagedByDefault() {
+	for i := range defaultPageSize + 1 {
+		suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-`+strconv.Itoa(i)+`", "name": "Hospital"}`)
+	}
+	suite.request(http.MethodPost, "/api/employee-list/hospital-0/entries", `{"id": "entry-1", "name": "Jozko"}`)
+
+	recorder := suite.request(http.MethodGet, "/api/hospital", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal(strconv.Itoa(defaultPageSize+1), recorder.Header().Get("X-Total-Count"))
+	var hospitals []HospitalSummary
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &hospitals))
+	suite.Require().Len(hospitals, defaultPageSize)
+	suite.Equal(int32(1), hospitals[0].EmployeeCount)
+	suite.Equal(int32(0), hospitals[1].EmployeeCount)
+}
+
+func (suite *HospitalApiSuite) Test_UpdateHospital_Replaced() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA", "address": "Old street"}`)
+
+	recorder := suite.request(http.MethodPut, "/api/hospital/hospital-ba", `{"name": "University Hospital BA"}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	etag := recorder.Header().Get("ETag")
+
+	recorder = suite.request(http.MethodGet, "/api/hospital/hospital-ba", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal(etag, recorder.Header().Get("ETag"))
+	var hospital Hospital
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &hospital))
+	suite.Equal(Hospital{Id: "hospital-ba", Name: "University Hospital BA", Version: 1}, hospital)
+}
+
+func (suite *HospitalApiSuite) Test_UpdateHospital_InvalidChanges_Rejected() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPut, "/api/hospital/hospital-ba", `{"id": "hospital-xx", "name": "Hospital BA"}`).Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPut, "/api/hospital/hospital-ba", `{"name": " "}`).Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPatch, "/api/hospital/hospital-ba", `{"name": null}`).Code)
+	suite.Equal(http.StatusNotFound, suite.request(http.MethodPut, "/api/hospital/hospital-xx", `{"name": "Hospital XX"}`).Code)
+
+	stored, err := suite.dbService.FindDocument(suite.T().Context(), "hospital-ba")
+	suite.Require().NoError(err)
+	suite.Equal("Hospital BA", stored.Name)
+}
+
+func (suite *HospitalApiSuite) Test_PatchHospital_Merged() {
+	suite.request(http.MethodPost, "/api/hospital",
+		`{"id": "hospital-ba", "name": "Hospital BA", "address": "Old street", "predefinedRoles": [{"value": "Nurse", "code": "nurse"}]}`)
+
+	recorder := suite.request(http.MethodPatch, "/api/hospital/hospital-ba", `{"address": null, "name": "University Hospital BA"}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPatch, "/api/hospital/hospital-ba", `{"predefinedRoles": []}`).Code)
+
+	stored, err := suite.dbService.FindDocument(suite.T().Context(), "hospital-ba")
+	suite.Require().NoError(err)
+	suite.Equal(&Hospital{
+		Id:              "hospital-ba",
+		Name:            "University Hospital BA",
+		PredefinedRoles: []Role{{Value: "Nurse", Code: "nurse"}},
+		Version:         1,
+	}, stored)
+}
+
+func (suite *HospitalApiSuite) Test_UpdateEntry_OmittedFieldsCleared() {
+	suite.createHospitalWithRoles()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries",
+		`{"id": "entry-1", "name": "Jozko", "role": {"code": "nurse"}, "performance": 7, "performances": [{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01"}]}`)
+
+	recorder := suite.request(http.MethodPut, "/api/employee-list/hospital-ba/entries/entry-1", `{"name": "Jozef", "performances": [{"activityType": "checkup", "activityDate": "2023-05-01"}]}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+
+	stored, err := suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-ba/entry-1")
+	suite.Require().NoError(err)
+	suite.Equal("Jozef", stored.Entry.Name)
+	suite.Equal(Role{}, stored.Entry.Role)
+	suite.Zero(stored.Entry.Performance)
+	performances := suite.storedPerformances("hospital-ba/entry-1")
+	suite.Require().Len(performances, 1)
+	suite.NotEmpty(performances[0].Id)
+	suite.Equal("checkup", performances[0].ActivityType)
+
+	recorder = suite.request(http.MethodPut, "/api/employee-list/hospital-ba/entries/entry-1", `{"id": "entry-2", "name": "Jozef"}`)
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func (suite *HospitalApiSuite) Test_PatchEntry_MergePatchClearsFields() {
+	suite.createHospitalWithRoles()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries",
+		`{"id": "entry-1", "name": "Jozko", "role": {"code": "nurse"}, "performance": 7, "performances": [{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01"}]}`)
+	path := "/api/employee-list/hospital-ba/entries/entry-1"
+
+	recorder := suite.requestWithType(http.MethodPatch, path, "application/merge-patch+json",
+		`{"name": null, "role": {"code": "doctor"}, "performances": null}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.NotEmpty(recorder.Header().Get("ETag"))
+
+	stored, err := suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-ba/entry-1")
+	suite.Require().NoError(err)
+	suite.Equal(EmployeeListEntry{
+		Id:          "entry-1",
+		Role:        Role{Value: "Doctor", Code: "doctor"},
+		Performance: 7,
+	}, stored.Entry)
+	suite.Empty(suite.storedPerformances("hospital-ba/entry-1"))
+
+	// plain JSON is applied as the merge patch
+	recorder = suite.request(http.MethodPatch, path, `{"name": "Jozef"}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Contains(recorder.Body.String(), `"name":"Jozef"`)
+
+	suite.Equal(http.StatusBadRequest, suite.requestWithType(http.MethodPatch, path, "application/merge-patch+json", `{"role": {"code": "surgeon"}}`).Code)
+	suite.Equal(http.StatusBadRequest, suite.requestWithType(http.MethodPatch, path, "application/merge-patch+json", `{"id": "entry-2"}`).Code)
+}
+
+func (suite *HospitalApiSuite) Test_PatchEntry_JsonPatchApplied() {
+	suite.createHospitalWithRoles()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries",
+		`{"id": "entry-1", "name": "Jozko", "performances": [{"id": "p-1", "activityType": "checkup", "activityDate": "2023-05-01"}, {"id": "p-2", "activityType": "surgery", "activityDate": "2023-05-01"}]}`)
+	path := "/api/employee-list/hospital-ba/entries/entry-1"
+
+	recorder := suite.requestWithType(http.MethodPatch, path, "application/json-patch+json",
+		`[{"op": "replace", "path": "/name", "value": "Jozef"}, {"op": "remove", "path": "/performances/0"}]`)
+	suite.Equal(http.StatusOK, recorder.Code)
+
+	stored, err := suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-ba/entry-1")
+	suite.Require().NoError(err)
+	suite.Equal("Jozef", stored.Entry.Name)
+	suite.Equal([]PerformanceEntry{{Id: "p-2", ActivityType: "surgery", ActivityDate: testActivityDate}}, suite.storedPerformances("hospital-ba/entry-1"))
+
+	recorder = suite.requestWithType(http.MethodPatch, path, "application/json-patch+json",
+		`[{"op": "test", "path": "/name", "value": "Jozko"}]`)
+	suite.Equal(http.StatusConflict, recorder.Code)
+	suite.Contains(recorder.Body.String(), `"code":"patch-test-failed"`)
+	suite.Equal(http.StatusBadRequest, suite.requestWithType(http.MethodPatch, path, "application/json-patch+json",
+		`[{"op": "remove", "path": "/missing"}]`).Code)
+	suite.Equal(http.StatusUnsupportedMediaType, suite.requestWithType(http.MethodPatch, path, "text/plain", `name=Jozef`).Code)
+}
+
+func (suite *HospitalApiSuite) Test_InvalidPayloads_AllViolationsListed() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+
+	recorder := suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/performances",
+		`{"activityType": "dancing", "details": "`+strings.Repeat("x", 256)+`"}`)
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	var response Problem
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	suite.ElementsMatch([]string{"/activityType", "/activityDate", "/details"}, fieldsOf(response.Errors))
+
+	recorder = suite.request(http.MethodPut, "/api/employee-list/hospital-ba/entries/entry-1",
+		`{"name": "Jozko", "performance": 11, "performances": [{"activityType": "checkup", "activityDate": "2023-05-01"}, {"activityType": "Checkup", "activityDate": "2023-05-01"}]}`)
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	suite.ElementsMatch([]string{"/performance", "/performances/1/activityType"}, fieldsOf(response.Errors))
+
+	recorder = suite.request(http.MethodPost, "/api/hospital",
+		`{"id": "hospital-nr", "name": "Hospital NR", "employeeList": [{"name": "Jozko"}, {"name": "Janko", "performance": -1}]}`)
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	suite.ElementsMatch([]string{"/employeeList/1/performance"}, fieldsOf(response.Errors))
+}
+
+func fieldsOf(violations []FieldError) []string {
+	fields := make([]string, 0, len(violations))
+	for _, violation := range violations {
+		fields = append(fields, violation.Field)
+	}
+	return fields
+}
+
+func (suite *HospitalApiSuite) Test_GetPerformances_FilteredByTypeAndDate() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+	base := "/api/employee-list/hospital-ba/entries/entry-1/performances"
+	suite.Equal(http.StatusOK, suite.request(http.MethodPost, base, `{"id": "p-1", "activityType": "checkup", "activityDate": "01/05/23"}`).Code)
+	suite.Equal(http.StatusOK, suite.request(http.MethodPost, base,
+		`{"id": "p-2", "activityType": "surgery", "activityDate": "2023-05-03T10:00:00Z", "activityEnd": "2023-05-03T12:30:00Z"}`).Code)
+	suite.Equal(http.StatusOK, suite.request(http.MethodPost, base, `{"id": "p-3", "activityType": "checkup", "activityDate": "2023-06-01"}`).Code)
+
+	idsOf := func(query string) []string {
+		recorder := suite.request(http.MethodGet, base+query, "")
+		suite.Require().Equal(http.StatusOK, recorder.Code)
+		var performances []PerformanceEntry
+		suite.Require().NoError(json.Unmarshal(recorder.Body.Bytes(), &performances))
+		ids := []string{}
+		for _, performance := range performances {
+			ids = append(ids, performance.Id)
+		}
+		return ids
+	}
+	suite.Equal([]string{"p-1", "p-2"}, idsOf("?from=2023-05-01&to=2023-05-03"))
+	suite.Equal([]string{"p-2", "p-3"}, idsOf("?from=2023-05-02T00:00:00Z"))
+	suite.Equal([]string{"p-1", "p-3"}, idsOf("?activityType=checkup"))
+	suite.Equal([]string{"p-1"}, idsOf("?activityType=checkup&to=2023-05-31"))
+
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodGet, base+"?from=01/05/23", "").Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodGet, base+"?from=2023-06-01&to=2023-05-01", "").Code)
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPost, base,
+		`{"activityType": "surgery", "activityDate": "2023-05-03T10:00:00Z", "activityEnd": "2023-05-03T09:00:00Z"}`).Code)
+}
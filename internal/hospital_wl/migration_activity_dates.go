@@ -0,0 +1,56 @@
+package hospital_wl
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// MigrateActivityDates rewrites performances with activity dates in the legacy DD/MM/YY format,
+// so that they are stored as timestamps and can be compared. The dates are converted on load,
+// so the documents need only to be stored back. Documents with dates which cannot be converted
+// are skipped and logged to be corrected manually. The migration is idempotent and safe to run
+// on every start.
+func MigrateActivityDates(ctx context.Context, performanceDb db_service.DbService[PerformanceDocument]) error {
+	documents, err := performanceDb.ListDocuments(ctx)
+	if err != nil {
+		return err
+	}
+
+	migrated := 0
+	for _, document := range documents {
+		if hasUnparsedActivityDate(document.Performance) {
+			log.Printf("Skipped migration of performance %v, its activity date is not a date", document.Id)
+			continue
+		}
+		if !hasLegacyActivityDate(document.Performance) {
+			continue
+		}
+
+		expectedVersion := document.Version
+		document.Version++
+		err := performanceDb.CompareAndSwapDocument(ctx, document.Id, expectedVersion, &document)
+		switch err {
+		case nil:
+			migrated++
+		case db_service.ErrVersionMismatch, db_service.ErrNotFound:
+			// changed or removed concurrently, the change already stored the converted dates
+		default:
+			return fmt.Errorf("failed to migrate activity dates of performance %v: %w", document.Id, err)
+		}
+	}
+	if migrated > 0 {
+		log.Printf("Converted legacy activity dates of %v performances", migrated)
+	}
+	return nil
+}
+
+func hasLegacyActivityDate(performance PerformanceEntry) bool {
+	return performance.ActivityDate.legacy || (performance.ActivityEnd != nil && performance.ActivityEnd.legacy)
+}
+
+func hasUnparsedActivityDate(performance PerformanceEntry) bool {
+	return performance.ActivityDate.unparsed != "" || (performance.ActivityEnd != nil && performance.ActivityEnd.unparsed != "")
+}
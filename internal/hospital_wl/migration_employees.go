@@ -0,0 +1,89 @@
+package hospital_wl
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+
+	"github.com/google/uuid"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// MigrateEmbeddedEmployees moves employees embedded in hospital documents by earlier versions
+// of the service into the employees collection and their performances into the performances
+// collection. Every hospital is rewritten, which also normalizes the field names of documents
+// inserted by init-db.js. The migration is idempotent and safe to run on every start.
+func MigrateEmbeddedEmployees(
+	ctx context.Context,
+	hospitalDb db_service.DbService[Hospital],
+	employeeDb db_service.DbService[EmployeeDocument],
+	performanceDb db_service.DbService[PerformanceDocument],
+) error {
+	hospitals, err := hospitalDb.ListDocuments(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, hospital := range hospitals {
+		for _, entry := range hospital.EmployeeList {
+			if entry.Id == "" {
+				entry.Id = uuid.NewString()
+			}
+			if err := createEmbeddedPerformances(ctx, performanceDb, hospital.Id, entry); err != nil {
+				return err
+			}
+			err := employeeDb.CreateDocument(ctx, employeeDocumentId(hospital.Id, entry.Id), newEmployeeDocument(hospital.Id, entry))
+			// conflict means the entry was moved by an interrupted run of the migration
+			if err != nil && err != db_service.ErrConflict {
+				return fmt.Errorf("failed to move entry %v of hospital %v: %w", entry.Id, hospital.Id, err)
+			}
+		}
+
+		moved := len(hospital.EmployeeList)
+		hospital.EmployeeList = nil
+		expectedVersion := hospital.Version
+		if moved > 0 {
+			// concurrent updates holding the embedded employees must not store them back
+			hospital.Version++
+		}
+		err := hospitalDb.CompareAndSwapDocument(ctx, hospital.Id, expectedVersion, &hospital)
+		switch err {
+		case nil:
+		case db_service.ErrVersionMismatch, db_service.ErrNotFound:
+			// changed or removed concurrently, it is migrated on the next start
+			continue
+		default:
+			return fmt.Errorf("failed to migrate hospital %v: %w", hospital.Id, err)
+		}
+		if moved > 0 {
+			log.Printf("Moved %v employees of hospital %v into the employees collection", moved, hospital.Id)
+		}
+	}
+	return nil
+}
+
+// createEmbeddedPerformances stores the performances embedded in the entry as documents
+func createEmbeddedPerformances(
+	ctx context.Context,
+	performanceDb db_service.DbService[PerformanceDocument],
+	hospitalId string,
+	entry EmployeeListEntry,
+) error {
+	ids := make([]string, 0, len(entry.Performances))
+	for _, performance := range entry.Performances {
+		// earlier versions did not require the ids to be unique within the entry
+		if performance.Id == "" || slices.Contains(ids, performance.Id) {
+			performance.Id = uuid.NewString()
+		}
+		ids = append(ids, performance.Id)
+		document := newPerformanceDocument(hospitalId, entry.Id, performance)
+		err := performanceDb.CreateDocument(ctx, document.Id, document)
+		// conflict means the performance was moved by an interrupted run of the migration
+		if err != nil && err != db_service.ErrConflict {
+			return fmt.Errorf("failed to move performance %v of entry %v of hospital %v: %w", performance.Id, entry.Id, hospitalId, err)
+		}
+	}
+	return nil
+}
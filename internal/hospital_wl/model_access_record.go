@@ -0,0 +1,40 @@
+/*
+ * Employee List Api
+ *
+ * Hospital Employee Administration for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xkello@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package hospital_wl
+
+import (
+	"time"
+)
+
+// AccessRecord - Record of a response including patient data, kept in the append-only access log
+type AccessRecord struct {
+
+	// Unique identifier of the record
+	Id string `json:"id"`
+
+	// Subject of the access token of the caller
+	Subject string `json:"subject"`
+
+	// Hospital of the patient data
+	HospitalId string `json:"hospitalId"`
+
+	// Employee list entries whose performances were included
+	EntryIds []string `json:"entryIds"`
+
+	// Names of the patients whose data was included, the patients are identified by their names
+	Patients []string `json:"patients"`
+
+	// Method and path of the request
+	Request string `json:"request"`
+
+	// Time of the response
+	AccessedAt time.Time `json:"accessedAt"`
+}
@@ -11,25 +11,28 @@
 package hospital_wl
 
 type PerformanceEntry struct {
-	// Unique id of the performance entry
+	// Unique id of the performance entry, generated by the service if not provided
 	Id string `json:"id"`
 
 	// Type of activity (examination, surgery, preoperative consultation, checkup)
 	ActivityType string `json:"activityType"`
 
-	// Name of the patient
+	// Name of the patient, clinical data masked as [redacted] for callers without the read-clinical-data permission in the hospital, their updates keep the stored value
 	PatientName string `json:"patientName"`
 
-	// Date of the activity in DD/MM/YY format
-	ActivityDate string `json:"activityDate"`
+	// Start of the activity, or its date at midnight UTC if the time is not known. Legacy DD/MM/YY dates are accepted on input.
+	ActivityDate ActivityTime `json:"activityDate"`
 
-	// Details of the operation (up to 255 characters)
+	// End of the activity, if known, it must not be before the start
+	ActivityEnd *ActivityTime `json:"activityEnd,omitempty"`
+
+	// Details of the operation (up to 255 characters), clinical data masked like the patient name
 	Details string `json:"details"`
 }
 
 type EmployeeListEntry struct {
 
-	// Unique id of the entry in this employee list
+	// Unique id of the entry in this employee list, generated by the service if not provided
 	Id string `json:"id"`
 
 	// Name of employee in employee list
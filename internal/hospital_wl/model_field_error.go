@@ -0,0 +1,21 @@
+/*
+ * Employee List Api
+ *
+ * Hospital Employee Administration for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xkello@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package hospital_wl
+
+// FieldError - Violated constraint of a single field of the request body
+type FieldError struct {
+
+	// JSON pointer to the invalid field within the request body, or location and name of the invalid parameter like query.pageSize
+	Field string `json:"field"`
+
+	// Description of the violated constraint
+	Message string `json:"message"`
+}
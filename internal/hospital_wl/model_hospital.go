@@ -12,7 +12,7 @@ package hospital_wl
 
 type Hospital struct {
 
-	// Unique identifier of the hospital
+	// Unique identifier of the hospital, generated by the service if not provided
 	Id string `json:"id"`
 
 	// Human readable display name of the hospital
@@ -23,4 +23,7 @@ type Hospital struct {
 	EmployeeList []EmployeeListEntry `json:"employeeList,omitempty"`
 
 	PredefinedRoles []Role `json:"predefinedRoles,omitempty"`
+
+	// Revision of the hospital document, incremented on every change
+	Version int64 `json:"version,omitempty"`
 }
@@ -0,0 +1,30 @@
+/*
+ * Employee List Api
+ *
+ * Hospital Employee Administration for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xkello@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package hospital_wl
+
+// HospitalSummary - Hospital as listed in the hospital list, employees and roles are included only on request
+type HospitalSummary struct {
+
+	// Unique identifier of the hospital
+	Id string `json:"id"`
+
+	// Human readable display name of the hospital
+	Name string `json:"name"`
+
+	Address string `json:"address,omitempty"`
+
+	// Number of entries in the hospital employee list
+	EmployeeCount int32 `json:"employeeCount"`
+
+	EmployeeList []EmployeeListEntry `json:"employeeList,omitempty"`
+
+	PredefinedRoles []Role `json:"predefinedRoles,omitempty"`
+}
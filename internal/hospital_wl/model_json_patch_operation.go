@@ -0,0 +1,27 @@
+/*
+ * Employee List Api
+ *
+ * Hospital Employee Administration for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xkello@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package hospital_wl
+
+// JsonPatchOperation - Single operation of JSON Patch (RFC 6902)
+type JsonPatchOperation struct {
+
+	// Operation to perform
+	Op string `json:"op"`
+
+	// JSON pointer to the target location
+	Path string `json:"path"`
+
+	// Value for the add, replace and test operations
+	Value interface{} `json:"value,omitempty"`
+
+	// JSON pointer to the source location of the move and copy operations
+	From string `json:"from,omitempty"`
+}
@@ -0,0 +1,36 @@
+/*
+ * Employee List Api
+ *
+ * Hospital Employee Administration for Web-In-Cloud system
+ *
+ * API version: 1.0.0
+ * Contact: xkello@stuba.sk
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package hospital_wl
+
+// Problem - Problem details of a failed request as defined by RFC 7807, sent with the application/problem+json media type
+type Problem struct {
+
+	// URI reference identifying the problem type, about:blank as the problems are distinguished by their code
+	Type string `json:"type"`
+
+	// Short summary of the problem type, the reason phrase of the status
+	Title string `json:"title"`
+
+	// HTTP status code of the response
+	Status int32 `json:"status"`
+
+	// Human readable explanation specific to this occurrence of the problem
+	Detail string `json:"detail,omitempty"`
+
+	// URI reference of the request the problem occurred in
+	Instance string `json:"instance,omitempty"`
+
+	// Stable machine readable identifier of the problem, unlike the detail it does not change between versions of the service
+	Code string `json:"code"`
+
+	// Constraint violations of the request body and parameters, listed if they were evaluated
+	Errors []FieldError `json:"errors,omitempty"`
+}
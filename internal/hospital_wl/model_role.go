@@ -13,7 +13,9 @@ package hospital_wl
 // Role - Describes employee position in hospital
 type Role struct {
 
-	Value string `json:"value"`
+	// Display name of the role, required for predefined roles. Roles of employees take the value of the predefined role with the same code.
+	Value string `json:"value,omitempty"`
 
-	Code string `json:"code,omitempty"`
+	// Unique identifier of the role among the predefined roles of the hospital
+	Code string `json:"code"`
 }
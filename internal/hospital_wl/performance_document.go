@@ -0,0 +1,217 @@
+package hospital_wl
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// PerformanceDocument is the stored form of a performance entry. Performances are kept
+// in their own collection, so that the employee document does not grow with them.
+type PerformanceDocument struct {
+	// Unique id of the document across all hospitals, see performanceDocumentId
+	Id string `json:"id"`
+
+	// Hospital the employee belongs to
+	HospitalId string `json:"hospitalId"`
+
+	// Employee document the performance belongs to
+	EmployeeId string `json:"employeeId"`
+
+	Performance PerformanceEntry `json:"performance"`
+
+	// Revision of the document, incremented on every change
+	Version int64 `json:"version,omitempty"`
+}
+
+// performanceDocumentId combines employee document and performance ids,
+// performance ids are unique only within an entry
+func performanceDocumentId(employeeId string, performanceId string) string {
+	return employeeId + "/" + performanceId
+}
+
+func newPerformanceDocument(hospitalId string, entryId string, performance PerformanceEntry) *PerformanceDocument {
+	employeeId := employeeDocumentId(hospitalId, entryId)
+	return &PerformanceDocument{
+		Id:          performanceDocumentId(employeeId, performance.Id),
+		HospitalId:  hospitalId,
+		EmployeeId:  employeeId,
+		Performance: performance,
+	}
+}
+
+const (
+	// patientNamePath and detailsPath address the clinical data within PerformanceDocument
+	patientNamePath = "performance.patientName"
+	detailsPath     = "performance.details"
+)
+
+// PerformanceEncryptionConfig selects the clinical data of the performances for encryption at rest,
+// the details are encrypted only if requested. Deterministically encrypted patient names can be
+// searched for, at the cost of revealing which performances belong to the same patient.
+func PerformanceEncryptionConfig(keyring *db_service.Keyring, details bool, deterministic bool) db_service.EncryptionConfig {
+	config := db_service.EncryptionConfig{Keyring: keyring, Fields: []string{patientNamePath}}
+	if details {
+		config.Fields = append(config.Fields, detailsPath)
+	}
+	if deterministic {
+		config.Deterministic = []string{patientNamePath}
+	}
+	return config
+}
+
+// employeePerformancesQuery selects the performances of the employee documents
+func employeePerformancesQuery(employeeIds ...string) db_service.Query {
+	ids := make([]interface{}, 0, len(employeeIds))
+	for _, id := range employeeIds {
+		ids = append(ids, id)
+	}
+	return db_service.Query{In: map[string][]interface{}{"employeeId": ids}}
+}
+
+// findPerformances loads the performances of the employee document in the stored order
+func (d *apiDependencies) findPerformances(ctx context.Context, employeeId string) ([]PerformanceEntry, error) {
+	documents, err := d.store.Performances.FindDocuments(ctx, employeePerformancesQuery(employeeId))
+	if err != nil || len(documents) == 0 {
+		return nil, err
+	}
+	performances := make([]PerformanceEntry, 0, len(documents))
+	for _, document := range documents {
+		performances = append(performances, document.Performance)
+	}
+	return performances, nil
+}
+
+// attachPerformances loads the performances of the entries of the hospital with a single query
+func (d *apiDependencies) attachPerformances(ctx context.Context, hospitalId string, entries []EmployeeListEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	employeeIds := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		employeeIds = append(employeeIds, employeeDocumentId(hospitalId, entry.Id))
+	}
+	documents, err := d.store.Performances.FindDocuments(ctx, employeePerformancesQuery(employeeIds...))
+	if err != nil {
+		return err
+	}
+	for _, document := range documents {
+		if index := slices.Index(employeeIds, document.EmployeeId); index >= 0 {
+			entries[index].Performances = append(entries[index].Performances, document.Performance)
+		}
+	}
+	return nil
+}
+
+// replacePerformances stores the performances of the entry in place of the replaced ones. Performances
+// created concurrently, which the replaced list does not include, are kept.
+func (d *apiDependencies) replacePerformances(
+	ctx context.Context,
+	hospitalId string,
+	entryId string,
+	replaced []PerformanceEntry,
+	performances []PerformanceEntry,
+) error {
+	employeeId := employeeDocumentId(hospitalId, entryId)
+	ids := make([]string, 0, len(replaced)+len(performances))
+	for _, performance := range replaced {
+		ids = append(ids, performanceDocumentId(employeeId, performance.Id))
+	}
+	documents := make(map[string]*PerformanceDocument, len(performances))
+	for _, performance := range performances {
+		document := newPerformanceDocument(hospitalId, entryId, performance)
+		documents[document.Id] = document
+		if !slices.Contains(ids, document.Id) {
+			ids = append(ids, document.Id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	return d.store.Performances.UpdateDocuments(ctx, ids, func(current []*PerformanceDocument) ([]*PerformanceDocument, error) {
+		updated := make([]*PerformanceDocument, len(ids))
+		for i, id := range ids {
+			document, ok := documents[id]
+			switch {
+			case !ok:
+				// removed from the entry
+			case current[i] == nil:
+				updated[i] = document
+			case reflect.DeepEqual(current[i].Performance, document.Performance):
+				updated[i] = current[i]
+			default:
+				document.Version = current[i].Version + 1
+				updated[i] = document
+			}
+		}
+		return updated, nil
+	})
+}
+
+// deletePerformances removes the performances of the entry
+func (d *apiDependencies) deletePerformances(ctx context.Context, hospitalId string, entryId string) error {
+	_, err := d.store.Performances.DeleteDocuments(ctx, employeePerformancesQuery(employeeDocumentId(hospitalId, entryId)))
+	return err
+}
+
+// hospitalPerformancesQuery selects the performances of all employees of the hospital
+func hospitalPerformancesQuery(hospitalId string) db_service.Query {
+	return db_service.Query{Equal: map[string]interface{}{"hospitalId": hospitalId}}
+}
+
+// createPerformances stores the performances of a created entry. The performances left behind
+// by an entry with the same id, which failed to be removed or transferred completely, are dropped.
+func (d *apiDependencies) createPerformances(ctx context.Context, hospitalId string, entryId string, performances []PerformanceEntry) error {
+	if err := d.deletePerformances(ctx, hospitalId, entryId); err != nil {
+		return err
+	}
+	return d.replacePerformances(ctx, hospitalId, entryId, nil, performances)
+}
+
+// findPatientEmployees lists the ids of the employee documents of the hospital
+// with performances of the patient
+func (d *apiDependencies) findPatientEmployees(ctx context.Context, hospitalId string, patient string) ([]interface{}, error) {
+	query := hospitalPerformancesQuery(hospitalId)
+	query.Equal[patientNamePath] = patient
+	query.Fields = []string{"employeeId"}
+	documents, err := d.store.Performances.FindDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	employeeIds := []interface{}{}
+	for _, document := range documents {
+		if !slices.Contains(employeeIds, interface{}(document.EmployeeId)) {
+			employeeIds = append(employeeIds, document.EmployeeId)
+		}
+	}
+	return employeeIds, nil
+}
+
+// assignPerformanceIds generates the missing ids of the performances of an entry, the ids must be
+// unique within the entry, as they address the stored performances
+func (d *apiDependencies) assignPerformanceIds(performances []PerformanceEntry) *Problem {
+	ids := make([]string, 0, len(performances))
+	for i := range performances {
+		if performances[i].Id == "" {
+			performances[i].Id = d.newId()
+		}
+		if slices.Contains(ids, performances[i].Id) {
+			return newProblem(http.StatusBadRequest, codeInvalidRequest, "Duplicate performance entry id "+performances[i].Id)
+		}
+		ids = append(ids, performances[i].Id)
+	}
+	return nil
+}
+
+// reportPerformanceChanges lists the performances of the employee document in the dry run response
+func reportPerformanceChanges(ctx *gin.Context, change string, employeeId string, performances []PerformanceEntry) {
+	for _, performance := range performances {
+		reportChange(ctx, change, "performance", performanceDocumentId(employeeId, performance.Id))
+	}
+}
@@ -0,0 +1,126 @@
+package hospital_wl
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// encryptPerformances stores the performances of the following requests encrypted,
+// the returned service reads them as stored
+func (suite *HospitalApiSuite) encryptPerformances(details bool, deterministic bool) db_service.DbService[PerformanceDocument] {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	suite.Require().NoError(err)
+	keyring, err := db_service.NewKeyring("k1", map[string][]byte{"k1": key})
+	suite.Require().NoError(err)
+
+	stored := suite.performanceDbService
+	suite.performanceDbService = db_service.NewEncryptedService(stored, PerformanceEncryptionConfig(keyring, details, deterministic))
+	suite.setupRouter()
+	return stored
+}
+
+// storedPerformances lists the stored performances of the employee document
+func (suite *HospitalApiSuite) storedPerformances(employeeId string) []PerformanceEntry {
+	documents, err := suite.performanceDbService.FindDocuments(suite.T().Context(), employeePerformancesQuery(employeeId))
+	suite.Require().NoError(err)
+	performances := []PerformanceEntry{}
+	for _, document := range documents {
+		performances = append(performances, document.Performance)
+	}
+	return performances
+}
+
+func (suite *HospitalApiSuite) Test_Encryption_ClinicalDataEncryptedAtRest() {
+	stored := suite.encryptPerformances(true, true)
+	suite.createEntryWithPerformance()
+	suite.request(http.MethodPut, "/api/employee-list/hospital-ba/entries/entry-1/performances/perf-1",
+		`{"id": "perf-1", "activityType": "checkup", "patientName": "Jane Roe", "activityDate": "2023-05-01", "details": "Follow-up"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/performances",
+		`{"id": "perf-2", "activityType": "surgery", "patientName": "John Doe", "activityDate": "2023-05-02", "details": ""}`)
+
+	documents, err := stored.FindDocuments(suite.T().Context(), employeePerformancesQuery("hospital-ba/entry-1"))
+	suite.Require().NoError(err)
+	suite.Require().Len(documents, 2)
+	for _, document := range documents {
+		suite.True(strings.HasPrefix(document.Performance.PatientName, "enc:d:k1:"), document.Performance.PatientName)
+	}
+	suite.True(strings.HasPrefix(documents[0].Performance.Details, "enc:r:k1:"), documents[0].Performance.Details)
+	suite.Empty(documents[1].Performance.Details)
+
+	recorder := suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1/performances", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	var performances []PerformanceEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &performances))
+	suite.Require().Len(performances, 2)
+	suite.Equal("Jane Roe", performances[0].PatientName)
+	suite.Equal("Follow-up", performances[0].Details)
+	suite.Equal("John Doe", performances[1].PatientName)
+}
+
+func (suite *HospitalApiSuite) Test_Encryption_Deterministic_PatientSearched() {
+	suite.encryptPerformances(false, true)
+	suite.createEntryWithPerformance()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-2", "name": "Ferko"}`)
+
+	recorder := suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?patient=John%20Doe", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	var entries []EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entries))
+	suite.Require().Len(entries, 1)
+	suite.Equal("entry-1", entries[0].Id)
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?patient=John", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.JSONEq(`[]`, recorder.Body.String())
+}
+
+func (suite *HospitalApiSuite) Test_Encryption_Random_PatientSearchRejected() {
+	suite.encryptPerformances(false, false)
+	suite.createEntryWithPerformance()
+
+	recorder := suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?patient=John%20Doe", "")
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+}
+
+func (suite *HospitalApiSuite) Test_PatientSearch_RequiresClinicalPermission() {
+	suite.createEntryWithPerformance()
+
+	suite.principal = rosterManager()
+	suite.Equal(http.StatusForbidden, suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?patient=John%20Doe", "").Code)
+
+	suite.principal = coordinator(map[string]interface{}{"hospital-ba": "physician"})
+	recorder := suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?patient=John%20Doe", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Contains(recorder.Body.String(), "entry-1")
+}
+
+func (suite *HospitalApiSuite) Test_Encryption_UnparsedActivityDateKept() {
+	stored := suite.encryptPerformances(true, false)
+	ctx := suite.T().Context()
+	var document PerformanceDocument
+	suite.Require().NoError(json.Unmarshal([]byte(
+		`{"id": "hospital-ba/entry-1/perf-1", "employeeId": "hospital-ba/entry-1", "performance": {"id": "perf-1", "activityDate": "yesterday"}}`,
+	), &document))
+	suite.Require().NoError(stored.CreateDocument(ctx, document.Id, &document))
+
+	found, err := suite.performanceDbService.FindDocument(ctx, document.Id)
+	suite.Require().NoError(err)
+	suite.True(found.Performance.ActivityDate.IsZero())
+	found.Performance.Details = "Follow-up"
+	suite.Require().NoError(suite.performanceDbService.UpdateDocument(ctx, found.Id, found))
+
+	found, err = stored.FindDocument(ctx, document.Id)
+	suite.Require().NoError(err)
+	data, err := json.Marshal(found.Performance)
+	suite.Require().NoError(err)
+	suite.Contains(string(data), `"activityDate":"yesterday"`)
+	suite.True(strings.HasPrefix(found.Performance.Details, "enc:r:k1:"), found.Performance.Details)
+
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/performances",
+		`{"id": "perf-2", "activityType": "checkup", "activityDate": "yesterday"}`).Code)
+}
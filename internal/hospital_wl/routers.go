@@ -63,6 +63,8 @@ func DefaultHandleFunc(c *gin.Context) {
 
 type ApiHandleFunctions struct {
 
+	// Routes for the AccessLogAPI part of the API
+	AccessLogAPI AccessLogAPI
 	// Routes for the HospitalEmployeeListAPI part of the API
 	HospitalEmployeeListAPI HospitalEmployeeListAPI
 	// Routes for the HospitalRolesAPI part of the API
@@ -73,6 +75,12 @@ type ApiHandleFunctions struct {
 
 func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 	return []Route{
+		{
+			"GetAccessLog",
+			http.MethodGet,
+			"/api/access-log/:hospitalId",
+			handleFunctions.AccessLogAPI.GetAccessLog,
+		},
 		{
 			"CreateEmployeeListEntry",
 			http.MethodPost,
@@ -97,6 +105,12 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/employee-list/:hospitalId/entries/:entryId",
 			handleFunctions.HospitalEmployeeListAPI.GetEmployeeListEntry,
 		},
+		{
+			"PatchEmployeeListEntry",
+			http.MethodPatch,
+			"/api/employee-list/:hospitalId/entries/:entryId",
+			handleFunctions.HospitalEmployeeListAPI.PatchEmployeeListEntry,
+		},
 		{
 			"TransferEmployeeListEntry",
 			http.MethodPost,
@@ -139,12 +153,36 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/employee-list/:hospitalId/entries/:entryId/performances/:performanceId",
 			handleFunctions.HospitalEmployeeListAPI.DeletePerformanceEntry,
 		},
+		{
+			"CreateRole",
+			http.MethodPost,
+			"/api/employee-list/:hospitalId/role",
+			handleFunctions.HospitalRolesAPI.CreateRole,
+		},
+		{
+			"DeleteRole",
+			http.MethodDelete,
+			"/api/employee-list/:hospitalId/role/:roleCode",
+			handleFunctions.HospitalRolesAPI.DeleteRole,
+		},
 		{
 			"GetRoles",
 			http.MethodGet,
 			"/api/employee-list/:hospitalId/role",
 			handleFunctions.HospitalRolesAPI.GetRoles,
 		},
+		{
+			"ReorderRoles",
+			http.MethodPost,
+			"/api/employee-list/:hospitalId/role/reorder",
+			handleFunctions.HospitalRolesAPI.ReorderRoles,
+		},
+		{
+			"UpdateRole",
+			http.MethodPut,
+			"/api/employee-list/:hospitalId/role/:roleCode",
+			handleFunctions.HospitalRolesAPI.UpdateRole,
+		},
 		{
 			"CreateHospital",
 			http.MethodPost,
@@ -163,5 +201,23 @@ func getRoutes(handleFunctions ApiHandleFunctions) []Route {
 			"/api/hospital",
 			handleFunctions.HospitalsAPI.GetHospital,
 		},
+		{
+			"GetHospitalDetail",
+			http.MethodGet,
+			"/api/hospital/:hospitalId",
+			handleFunctions.HospitalsAPI.GetHospitalDetail,
+		},
+		{
+			"PatchHospital",
+			http.MethodPatch,
+			"/api/hospital/:hospitalId",
+			handleFunctions.HospitalsAPI.PatchHospital,
+		},
+		{
+			"UpdateHospital",
+			http.MethodPut,
+			"/api/hospital/:hospitalId",
+			handleFunctions.HospitalsAPI.UpdateHospital,
+		},
 	}
 }
@@ -0,0 +1,139 @@
+package hospital_wl
+
+import (
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// accessAuditorKey is the key of the dependencies recording the accesses to patient data
+// in the request context
+const accessAuditorKey = "accessAuditor"
+
+// patientsPath addresses the patient names within AccessRecord
+const patientsPath = "patients"
+
+// AccessLogEncryptionConfig encrypts the patient names of the access log deterministically,
+// so that the accesses to a patient can still be searched for
+func AccessLogEncryptionConfig(keyring *db_service.Keyring) db_service.EncryptionConfig {
+	return db_service.EncryptionConfig{Keyring: keyring, Fields: []string{patientsPath}, Deterministic: []string{patientsPath}}
+}
+
+// NewAccessAuditor creates the middleware letting the handlers record the responses including
+// patient data in the access log of the store, it must follow the authorizer
+func NewAccessAuditor(store Store, clock Clock, idGenerator IdGenerator, logger *log.Logger) gin.HandlerFunc {
+	auditor := newApiDependencies(store, clock, idGenerator, logger)
+	return func(ctx *gin.Context) {
+		ctx.Set(accessAuditorKey, &auditor)
+		ctx.Next()
+	}
+}
+
+// respondAudited sends the content shaped for the caller. The patient data left in the content
+// is recorded in the access log first, the content is not sent if it cannot be recorded.
+func respondAudited(ctx *gin.Context, status int, content interface{}) {
+	content = redactFor(ctx, content)
+	if !recordAccess(ctx, content) {
+		return
+	}
+	ctx.JSON(status, content)
+}
+
+// recordAccess stores a record of the patient data in the content shaped for the caller for each
+// hospital the data belongs to. Failures are responded, the patient data must not be sent unrecorded.
+func recordAccess(ctx *gin.Context, content interface{}) bool {
+	records := accessRecords{}
+	records.collect(ctx, content)
+	if len(records) == 0 {
+		return true
+	}
+
+	value, _ := ctx.Get(accessAuditorKey)
+	auditor, ok := value.(*apiDependencies)
+	if !ok {
+		abortWithProblem(ctx, http.StatusInternalServerError, codeInternalError, "Access to patient data cannot be recorded")
+		return false
+	}
+	subject := ""
+	if principal, ok := principalOf(ctx); ok {
+		subject = principal.Subject
+	}
+	// whole seconds in UTC, so that the JSON form of the times sorts like the times
+	accessedAt := auditor.clock().UTC().Truncate(time.Second)
+	for _, hospitalId := range slices.Sorted(maps.Keys(records)) {
+		record := records[hospitalId]
+		record.Id = auditor.newId()
+		record.Subject = subject
+		record.Request = ctx.Request.Method + " " + ctx.Request.URL.Path
+		record.AccessedAt = accessedAt
+		if err := auditor.store.AccessLog.CreateDocument(ctx, record.Id, record); err != nil {
+			abortWithStorageFailure(ctx, auditor.logger, "Failed to record access to patient data", err)
+			return false
+		}
+	}
+	return true
+}
+
+// accessRecords collects the patient data of a response by hospital id
+type accessRecords map[string]*AccessRecord
+
+// collect adds the performances of the content, which the caller may read unmasked,
+// the content types are those shaped by redactFor
+func (r accessRecords) collect(ctx *gin.Context, content interface{}) {
+	hospitalId, entryId := ctx.Param("hospitalId"), ctx.Param("entryId")
+	switch content := content.(type) {
+	case PerformanceEntry:
+		r.add(ctx, hospitalId, entryId, []PerformanceEntry{content})
+	case []PerformanceEntry:
+		r.add(ctx, hospitalId, entryId, content)
+	case EmployeeListEntry:
+		r.add(ctx, hospitalId, content.Id, content.Performances)
+	case *EmployeeListEntry:
+		if content != nil {
+			r.add(ctx, hospitalId, content.Id, content.Performances)
+		}
+	case []EmployeeListEntry:
+		r.addEntries(ctx, hospitalId, content)
+	case Hospital:
+		r.addEntries(ctx, content.Id, content.EmployeeList)
+	case *Hospital:
+		if content != nil {
+			r.addEntries(ctx, content.Id, content.EmployeeList)
+		}
+	case []HospitalSummary:
+		for _, summary := range content {
+			r.addEntries(ctx, summary.Id, summary.EmployeeList)
+		}
+	}
+}
+
+func (r accessRecords) addEntries(ctx *gin.Context, hospitalId string, entries []EmployeeListEntry) {
+	for _, entry := range entries {
+		r.add(ctx, hospitalId, entry.Id, entry.Performances)
+	}
+}
+
+func (r accessRecords) add(ctx *gin.Context, hospitalId string, entryId string, performances []PerformanceEntry) {
+	if len(performances) == 0 || !authorized(ctx, hospitalId, PermissionReadClinicalData) {
+		return
+	}
+	record, ok := r[hospitalId]
+	if !ok {
+		record = &AccessRecord{HospitalId: hospitalId, EntryIds: []string{}, Patients: []string{}}
+		r[hospitalId] = record
+	}
+	if !slices.Contains(record.EntryIds, entryId) {
+		record.EntryIds = append(record.EntryIds, entryId)
+	}
+	for _, performance := range performances {
+		if performance.PatientName != "" && !slices.Contains(record.Patients, performance.PatientName) {
+			record.Patients = append(record.Patients, performance.PatientName)
+		}
+	}
+}
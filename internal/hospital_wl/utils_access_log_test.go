@@ -0,0 +1,120 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+func physician() *Principal {
+	return coordinator(map[string]interface{}{"hospital-ba": "physician"})
+}
+
+// accessesOf lists the stored access records of the caller
+func (suite *HospitalApiSuite) accessesOf(subject string) []AccessRecord {
+	records, err := suite.accessLogDbService.FindDocuments(suite.T().Context(), db_service.Query{
+		Equal: map[string]interface{}{"subject": subject},
+	})
+	suite.Require().NoError(err)
+	return records
+}
+
+func (suite *HospitalApiSuite) Test_AccessLog_PatientDataReadsRecorded() {
+	suite.createEntryWithPerformance()
+	suite.now = time.Date(2025, 6, 1, 12, 0, 0, 500, time.UTC)
+
+	suite.principal = rosterManager()
+	suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1/performances", "")
+	suite.Empty(suite.accessesOf("coordinator"), "masked patient data is not recorded")
+
+	suite.principal = physician()
+	paths := []string{
+		"/api/employee-list/hospital-ba/entries",
+		"/api/employee-list/hospital-ba/entries/entry-1",
+		"/api/employee-list/hospital-ba/entries/entry-1/performances",
+		"/api/employee-list/hospital-ba/entries/entry-1/performances/perf-1",
+	}
+	for _, path := range paths {
+		suite.Equal(http.StatusOK, suite.request(http.MethodGet, path, "").Code, path)
+	}
+	suite.Equal(http.StatusOK, suite.request(http.MethodGet, "/api/employee-list/hospital-ba/role", "").Code)
+
+	records := suite.accessesOf("coordinator")
+	suite.Require().Len(records, len(paths))
+	for i, record := range records {
+		suite.NotEmpty(record.Id)
+		suite.Equal("hospital-ba", record.HospitalId)
+		suite.Equal([]string{"entry-1"}, record.EntryIds)
+		suite.Equal([]string{"John Doe"}, record.Patients)
+		suite.Equal("GET "+paths[i], record.Request)
+		suite.Equal(time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC), record.AccessedAt)
+	}
+}
+
+func (suite *HospitalApiSuite) Test_AccessLog_QueriedByPatientAndDateRange() {
+	// the responses to the developer creating the data are recorded too
+	suite.now = time.Date(2025, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.createEntryWithPerformance()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/performances",
+		`{"id": "perf-2", "activityType": "checkup", "patientName": "Jane Roe", "activityDate": "2023-05-02", "details": "Follow-up"}`)
+	suite.principal = physician()
+	reads := map[time.Time]string{
+		time.Date(2025, 5, 31, 23, 0, 0, 0, time.UTC): "/api/employee-list/hospital-ba/entries/entry-1/performances/perf-1",
+		time.Date(2025, 6, 1, 8, 0, 0, 0, time.UTC):   "/api/employee-list/hospital-ba/entries/entry-1/performances/perf-2",
+		time.Date(2025, 6, 1, 9, 0, 0, 0, time.UTC):   "/api/employee-list/hospital-ba/entries/entry-1/performances",
+		time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC):   "/api/employee-list/hospital-ba/entries/entry-1/performances/perf-1",
+	}
+	for now, path := range reads {
+		suite.now = now
+		suite.request(http.MethodGet, path, "")
+	}
+
+	suite.Equal(http.StatusForbidden, suite.request(http.MethodGet, "/api/access-log/hospital-ba", "").Code)
+
+	suite.principal = coordinator(map[string]interface{}{"*": "compliance-officer"})
+	recorder := suite.request(http.MethodGet, "/api/access-log/hospital-ba?patient=John%20Doe&from=2025-06-01&to=2025-06-01", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("1", recorder.Header().Get("X-Total-Count"))
+	var records []AccessRecord
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &records))
+	suite.Require().Len(records, 1)
+	suite.Equal([]string{"John Doe", "Jane Roe"}, records[0].Patients)
+	suite.Equal("coordinator", records[0].Subject)
+
+	recorder = suite.request(http.MethodGet, "/api/access-log/hospital-ba?patient=John%20Doe&from=2025-05-31T23:00:00Z&page=1&pageSize=2", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("3", recorder.Header().Get("X-Total-Count"))
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &records))
+	suite.Require().Len(records, 2)
+	suite.Equal(time.Date(2025, 6, 2, 0, 0, 0, 0, time.UTC), records[0].AccessedAt, "newest first")
+
+	// the partial second of the bounds is included
+	recorder = suite.request(http.MethodGet, "/api/access-log/hospital-ba?patient=John%20Doe&from=2025-06-01T09:00:00.5Z&to=2025-06-01T09:00:00.5Z", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("1", recorder.Header().Get("X-Total-Count"))
+
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodGet, "/api/access-log/hospital-ba?from=2025-06-02&to=2025-06-01", "").Code)
+}
+
+func (suite *HospitalApiSuite) Test_AccessLog_EncryptedPatientsSearched() {
+	keyring, err := db_service.NewKeyring("k1", map[string][]byte{"k1": make([]byte, 32)})
+	suite.Require().NoError(err)
+	stored := suite.accessLogDbService
+	suite.accessLogDbService = db_service.NewEncryptedService(stored, AccessLogEncryptionConfig(keyring))
+	suite.setupRouter()
+	suite.createEntryWithPerformance()
+	suite.principal = physician()
+	suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1/performances", "")
+
+	records, err := stored.FindDocuments(suite.T().Context(), db_service.Query{Equal: map[string]interface{}{"subject": "coordinator"}})
+	suite.Require().NoError(err)
+	suite.Require().Len(records, 1)
+	suite.NotContains(records[0].Patients, "John Doe")
+
+	suite.principal = coordinator(map[string]interface{}{"hospital-ba": "hospital-admin"})
+	recorder := suite.request(http.MethodGet, "/api/access-log/hospital-ba?patient=John%20Doe", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Contains(recorder.Body.String(), `"patients":["John Doe"]`)
+}
@@ -0,0 +1,289 @@
+package hospital_wl
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"golang.org/x/sync/singleflight"
+)
+
+// principalKey is the key of the authenticated caller in the request context
+const principalKey = "principal"
+
+// jwksRefreshInterval limits how often a token signed by an unknown key refreshes the keys from the issuer
+const jwksRefreshInterval = time.Minute
+
+// signatureAlgorithms accepted in the tokens, symmetric algorithms are excluded as the keys are public
+var signatureAlgorithms = []jose.SignatureAlgorithm{
+	jose.RS256, jose.RS384, jose.RS512,
+	jose.PS256, jose.PS384, jose.PS512,
+	jose.ES256, jose.ES384, jose.ES512,
+	jose.EdDSA,
+}
+
+type AuthenticationConfig struct {
+	// Issuer and Audience the bearer tokens must be issued by and for
+	Issuer   string
+	Audience string
+
+	// JwksUrl provides the keys of the issuer, they are refreshed when a token is signed by an unknown key
+	JwksUrl string
+	// JwksFile provides the keys instead of JwksUrl, so that the tokens can be verified offline
+	JwksFile string
+
+	// DevelopmentBypass lets requests without bearer token through as the developer, tokens sent
+	// are still verified. It is refused in production.
+	DevelopmentBypass bool
+	Production        bool
+
+	// Clock evaluates the expiry of the tokens, the system clock is used if not set
+	Clock Clock
+}
+
+// Principal is the authenticated caller, Claims are all verified claims of the bearer token
+type Principal struct {
+	Subject string
+	Claims  map[string]interface{}
+}
+
+// developmentPrincipal is the caller of the requests let through by the development bypass
+var developmentPrincipal = &Principal{
+	Subject: "developer",
+	Claims:  map[string]interface{}{"sub": "developer"},
+}
+
+// principalOf provides the caller authenticated by the middleware
+func principalOf(ctx *gin.Context) (*Principal, bool) {
+	value, exists := ctx.Get(principalKey)
+	if !exists {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
+
+// NewAuthenticator creates the middleware requiring a bearer token on the routes of handleFunctions.
+// The token must be signed by a key of the configured JSON Web Key Set and issued by the issuer
+// for the audience, its claims are stored in the request context. Requests of other routes are
+// passed through unauthenticated.
+func NewAuthenticator(handleFunctions ApiHandleFunctions, config AuthenticationConfig) (gin.HandlerFunc, error) {
+	if config.DevelopmentBypass && config.Production {
+		return nil, errors.New("development bypass of the authentication is not allowed in production")
+	}
+	if config.Clock == nil {
+		config.Clock = SystemClock
+	}
+
+	var verifier *tokenVerifier
+	switch {
+	case config.JwksUrl != "" || config.JwksFile != "":
+		if config.Issuer == "" || config.Audience == "" {
+			return nil, errors.New("issuer and audience of the tokens are required")
+		}
+		keys := &jwksSource{url: config.JwksUrl, clock: config.Clock, client: &http.Client{Timeout: 10 * time.Second}}
+		var err error
+		if config.JwksFile != "" {
+			err = keys.load(config.JwksFile)
+		} else {
+			err = keys.fetch(context.Background())
+		}
+		if err != nil {
+			return nil, err
+		}
+		verifier = &tokenVerifier{keys: keys, config: config}
+	case !config.DevelopmentBypass:
+		return nil, errors.New("JSON Web Key Set URL or file is required")
+	}
+
+	routes := map[string]bool{}
+	for _, route := range getRoutes(handleFunctions) {
+		routes[route.Method+" "+route.Pattern] = true
+	}
+
+	return func(ctx *gin.Context) {
+		if !routes[ctx.Request.Method+" "+ctx.FullPath()] {
+			ctx.Next()
+			return
+		}
+
+		token, ok := bearerToken(ctx)
+		if !ok {
+			if config.DevelopmentBypass {
+				ctx.Set(principalKey, developmentPrincipal)
+				ctx.Next()
+				return
+			}
+			ctx.Header("WWW-Authenticate", `Bearer`)
+			abortWithProblem(ctx, http.StatusUnauthorized, codeUnauthorized, "Bearer token is required")
+			return
+		}
+		if verifier == nil {
+			ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			abortWithProblem(ctx, http.StatusUnauthorized, codeUnauthorized, "Bearer tokens are not accepted by the development setup")
+			return
+		}
+
+		principal, err := verifier.verify(ctx, token)
+		if err != nil {
+			ctx.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			abortWithProblem(ctx, http.StatusUnauthorized, codeUnauthorized, "Bearer token is invalid: "+err.Error())
+			return
+		}
+		ctx.Set(principalKey, principal)
+		ctx.Next()
+	}, nil
+}
+
+// bearerToken provides the token of the Authorization header, the scheme is case insensitive
+func bearerToken(ctx *gin.Context) (string, bool) {
+	scheme, token, found := strings.Cut(ctx.GetHeader("Authorization"), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || strings.TrimSpace(token) == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
+
+type tokenVerifier struct {
+	keys   *jwksSource
+	config AuthenticationConfig
+}
+
+// verify checks the signature and the registered claims of the token
+func (v *tokenVerifier) verify(ctx context.Context, raw string) (*Principal, error) {
+	token, err := jwt.ParseSigned(raw, signatureAlgorithms)
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	if len(token.Headers) != 1 || token.Headers[0].KeyID == "" {
+		return nil, errors.New("key id is missing")
+	}
+
+	keys, err := v.keys.keySet(ctx, token.Headers[0].KeyID)
+	if err != nil {
+		return nil, err
+	}
+	var claims jwt.Claims
+	var all map[string]interface{}
+	if err := token.Claims(keys, &claims, &all); err != nil {
+		return nil, errors.New("signature verification failed")
+	}
+	if claims.Expiry == nil {
+		return nil, errors.New("expiry is missing")
+	}
+	err = claims.Validate(jwt.Expected{
+		Issuer:      v.config.Issuer,
+		AnyAudience: jwt.Audience{v.config.Audience},
+		Time:        v.config.Clock(),
+	})
+	switch {
+	case err == nil:
+	case errors.Is(err, jwt.ErrExpired):
+		return nil, errors.New("token is expired")
+	case errors.Is(err, jwt.ErrInvalidIssuer):
+		return nil, errors.New("unexpected issuer")
+	case errors.Is(err, jwt.ErrInvalidAudience):
+		return nil, errors.New("unexpected audience")
+	default:
+		return nil, errors.New("token is not valid yet")
+	}
+
+	return &Principal{Subject: claims.Subject, Claims: all}, nil
+}
+
+// jwksSource keeps the JSON Web Key Set of the issuer
+type jwksSource struct {
+	url    string
+	client *http.Client
+	clock  Clock
+
+	mutex   sync.RWMutex
+	keys    jose.JSONWebKeySet
+	fetched time.Time
+	// refresh lets the verifications of tokens with an unknown key id share one download
+	refresh singleflight.Group
+}
+
+// keySet provides the keys, the keys of the URL are refreshed when the key id is unknown,
+// so that the keys rotated by the issuer are picked up. The keys are downloaded without
+// holding the lock, so that the tokens signed by the known keys are verified meanwhile.
+func (s *jwksSource) keySet(ctx context.Context, keyId string) (jose.JSONWebKeySet, error) {
+	keys, fetched := s.current()
+	if len(keys.Key(keyId)) == 0 && s.url != "" && s.clock().Sub(fetched) >= jwksRefreshInterval {
+		// the download is shared by several requests, it must not be canceled with the first of them
+		_, err, _ := s.refresh.Do(s.url, func() (interface{}, error) {
+			if _, fetched := s.current(); s.clock().Sub(fetched) < jwksRefreshInterval {
+				return nil, nil
+			}
+			return nil, s.fetch(context.WithoutCancel(ctx))
+		})
+		if err != nil {
+			log.Printf("Failed to refresh the signing keys of the issuer: %v", err)
+			return jose.JSONWebKeySet{}, errors.New("signing keys are not available")
+		}
+		keys, _ = s.current()
+	}
+	if len(keys.Key(keyId)) == 0 {
+		return jose.JSONWebKeySet{}, errors.New("unknown signing key")
+	}
+	return keys, nil
+}
+
+// current provides the keys and the time they were last fetched at
+func (s *jwksSource) current() (jose.JSONWebKeySet, time.Time) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.keys, s.fetched
+}
+
+// load reads the keys from the file
+func (s *jwksSource) load(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read JSON Web Key Set: %w", err)
+	}
+	if err := json.Unmarshal(data, &s.keys); err != nil {
+		return fmt.Errorf("invalid JSON Web Key Set: %w", err)
+	}
+	return nil
+}
+
+// fetch downloads the keys from the URL, failed downloads count as fetched too,
+// so that the issuer is not asked again before the refresh interval passes
+func (s *jwksSource) fetch(ctx context.Context) error {
+	s.mutex.Lock()
+	s.fetched = s.clock()
+	s.mutex.Unlock()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid JSON Web Key Set URL: %w", err)
+	}
+	response, err := s.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JSON Web Key Set: %w", err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JSON Web Key Set: status %v", response.StatusCode)
+	}
+
+	var keys jose.JSONWebKeySet
+	if err := json.NewDecoder(response.Body).Decode(&keys); err != nil {
+		return fmt.Errorf("invalid JSON Web Key Set: %w", err)
+	}
+	s.mutex.Lock()
+	s.keys = keys
+	s.mutex.Unlock()
+	return nil
+}
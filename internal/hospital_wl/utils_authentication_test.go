@@ -0,0 +1,234 @@
+package hospital_wl
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/stretchr/testify/suite"
+)
+
+const (
+	testIssuer   = "https://login.example.com/realms/hospital"
+	testAudience = "ot-hospital-api"
+)
+
+// AuthenticationSuite exercises the authentication middleware in front of the complete router
+type AuthenticationSuite struct {
+	suite.Suite
+	keys            map[string]*rsa.PrivateKey
+	handleFunctions ApiHandleFunctions
+	now             time.Time
+	// principal is the caller seen by the handlers of the last request
+	principal *Principal
+}
+
+func TestAuthenticationSuite(t *testing.T) {
+	suite.Run(t, new(AuthenticationSuite))
+}
+
+func (suite *AuthenticationSuite) SetupSuite() {
+	suite.keys = map[string]*rsa.PrivateKey{}
+	for _, keyId := range []string{"key-1", "key-2"} {
+		key, err := rsa.GenerateKey(rand.Reader, 2048)
+		suite.Require().NoError(err)
+		suite.keys[keyId] = key
+	}
+}
+
+func (suite *AuthenticationSuite) SetupTest() {
+	gin.SetMode(gin.TestMode)
+	suite.now = time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	suite.principal = nil
+	store := Store{
+		Hospitals: newMemoryService[Hospital](suite.T()),
+		Employees: newMemoryService[EmployeeDocument](suite.T()),
+	}
+	logger := log.Default()
+	suite.handleFunctions = ApiHandleFunctions{
+		AccessLogAPI:            NewAccessLogApi(store, SystemClock, UuidGenerator, logger),
+		HospitalEmployeeListAPI: NewHospitalEmployeeListApi(store, SystemClock, UuidGenerator, logger),
+		HospitalRolesAPI:        NewHospitalRolesApi(store, SystemClock, UuidGenerator, logger),
+		HospitalsAPI:            NewHospitalsApi(store, SystemClock, UuidGenerator, logger),
+	}
+}
+
+// keySet publishes the public keys with the given ids
+func (suite *AuthenticationSuite) keySet(keyIds ...string) []byte {
+	var keys jose.JSONWebKeySet
+	for _, keyId := range keyIds {
+		keys.Keys = append(keys.Keys, jose.JSONWebKey{Key: &suite.keys[keyId].PublicKey, KeyID: keyId, Algorithm: string(jose.RS256), Use: "sig"})
+	}
+	data, err := json.Marshal(keys)
+	suite.Require().NoError(err)
+	return data
+}
+
+// keySetFile writes the key set to a file of the test
+func (suite *AuthenticationSuite) keySetFile(keyIds ...string) string {
+	path := filepath.Join(suite.T().TempDir(), "jwks.json")
+	suite.Require().NoError(os.WriteFile(path, suite.keySet(keyIds...), 0o600))
+	return path
+}
+
+// token signs the claims with the key, standard claims not set by the test are valid
+func (suite *AuthenticationSuite) token(keyId string, claims jwt.Claims) string {
+	if claims.Issuer == "" {
+		claims.Issuer = testIssuer
+	}
+	if claims.Audience == nil {
+		claims.Audience = jwt.Audience{testAudience}
+	}
+	if claims.Expiry == nil {
+		claims.Expiry = jwt.NewNumericDate(suite.now.Add(5 * time.Minute))
+	}
+	signer, err := jose.NewSigner(
+		jose.SigningKey{Algorithm: jose.RS256, Key: jose.JSONWebKey{Key: suite.keys[keyId], KeyID: keyId}},
+		(&jose.SignerOptions{}).WithType("JWT"),
+	)
+	suite.Require().NoError(err)
+	token, err := jwt.Signed(signer).Claims(claims).Claims(map[string]interface{}{"name": "Jozko Mrkvicka"}).Serialize()
+	suite.Require().NoError(err)
+	return token
+}
+
+func (suite *AuthenticationSuite) router(config AuthenticationConfig) *gin.Engine {
+	config.Clock = func() time.Time { return suite.now }
+	authenticator, err := NewAuthenticator(suite.handleFunctions, config)
+	suite.Require().NoError(err)
+
+	router := gin.New()
+	router.Use(authenticator, func(ctx *gin.Context) {
+		suite.principal, _ = principalOf(ctx)
+	})
+	NewRouterWithGinEngine(router, suite.handleFunctions)
+	return router
+}
+
+func (suite *AuthenticationSuite) request(router *gin.Engine, token string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	request := httptest.NewRequest(http.MethodGet, "/api/hospital", nil)
+	if token != "" {
+		request.Header.Set("Authorization", "Bearer "+token)
+	}
+	router.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func (suite *AuthenticationSuite) fileConfig() AuthenticationConfig {
+	return AuthenticationConfig{Issuer: testIssuer, Audience: testAudience, JwksFile: suite.keySetFile("key-1")}
+}
+
+func (suite *AuthenticationSuite) Test_ValidToken_ClaimsInContext() {
+	router := suite.router(suite.fileConfig())
+
+	recorder := suite.request(router, suite.token("key-1", jwt.Claims{Subject: "user-1"}))
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Require().NotNil(suite.principal)
+	suite.Equal("user-1", suite.principal.Subject)
+	suite.Equal("Jozko Mrkvicka", suite.principal.Claims["name"])
+}
+
+func (suite *AuthenticationSuite) Test_MissingToken_Unauthorized() {
+	router := suite.router(suite.fileConfig())
+
+	recorder := suite.request(router, "")
+	suite.Equal(http.StatusUnauthorized, recorder.Code)
+	suite.Equal("Bearer", recorder.Header().Get("WWW-Authenticate"))
+	suite.Equal(problemContentType, recorder.Header().Get("Content-Type"))
+	var problem Problem
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &problem))
+	suite.Equal(codeUnauthorized, problem.Code)
+	suite.Nil(suite.principal)
+}
+
+func (suite *AuthenticationSuite) Test_InvalidTokens_Unauthorized() {
+	router := suite.router(suite.fileConfig())
+	otherKey := suite.token("key-2", jwt.Claims{Subject: "user-1"})
+
+	tokens := map[string]string{
+		"expired":        suite.token("key-1", jwt.Claims{Subject: "user-1", Expiry: jwt.NewNumericDate(suite.now.Add(-5 * time.Minute))}),
+		"not yet valid":  suite.token("key-1", jwt.Claims{Subject: "user-1", NotBefore: jwt.NewNumericDate(suite.now.Add(5 * time.Minute))}),
+		"other issuer":   suite.token("key-1", jwt.Claims{Subject: "user-1", Issuer: "https://evil.example.com"}),
+		"other audience": suite.token("key-1", jwt.Claims{Subject: "user-1", Audience: jwt.Audience{"other-api"}}),
+		"unknown key":    otherKey,
+		"malformed":      "not-a-token",
+	}
+	for name, token := range tokens {
+		recorder := suite.request(router, token)
+		suite.Equal(http.StatusUnauthorized, recorder.Code, name)
+		suite.Equal(`Bearer error="invalid_token"`, recorder.Header().Get("WWW-Authenticate"), name)
+	}
+}
+
+func (suite *AuthenticationSuite) Test_JwksUrl_RotatedKeysFetched() {
+	var mutex sync.Mutex
+	published := suite.keySet("key-1")
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(published)
+	}))
+	defer issuer.Close()
+	router := suite.router(AuthenticationConfig{Issuer: testIssuer, Audience: testAudience, JwksUrl: issuer.URL})
+
+	suite.Equal(http.StatusOK, suite.request(router, suite.token("key-1", jwt.Claims{Subject: "user-1"})).Code)
+
+	mutex.Lock()
+	published = suite.keySet("key-1", "key-2")
+	mutex.Unlock()
+	suite.now = suite.now.Add(2 * jwksRefreshInterval)
+	suite.Equal(http.StatusOK, suite.request(router, suite.token("key-2", jwt.Claims{Subject: "user-2"})).Code)
+	suite.Equal("user-2", suite.principal.Subject)
+}
+
+func (suite *AuthenticationSuite) Test_JwksUrl_RefreshNotBlockingKnownKeys() {
+	fetched := make(chan bool, 1)
+	release := make(chan bool)
+	issuer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case fetched <- true:
+		default:
+			// the refresh waits until the test releases it
+			<-release
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(suite.keySet("key-1"))
+	}))
+	defer issuer.Close()
+	router := suite.router(AuthenticationConfig{Issuer: testIssuer, Audience: testAudience, JwksUrl: issuer.URL})
+	suite.now = suite.now.Add(2 * jwksRefreshInterval)
+
+	refreshed := make(chan int)
+	go func() {
+		refreshed <- suite.request(router, suite.token("key-2", jwt.Claims{Subject: "user-2"})).Code
+	}()
+	time.Sleep(50 * time.Millisecond)
+	suite.Equal(http.StatusOK, suite.request(router, suite.token("key-1", jwt.Claims{Subject: "user-1"})).Code)
+
+	close(release)
+	suite.Equal(http.StatusUnauthorized, <-refreshed)
+}
+
+func (suite *AuthenticationSuite) Test_DevelopmentBypass_RefusedInProduction() {
+	_, err := NewAuthenticator(suite.handleFunctions, AuthenticationConfig{DevelopmentBypass: true, Production: true})
+	suite.Error(err)
+	_, err = NewAuthenticator(suite.handleFunctions, AuthenticationConfig{})
+	suite.Error(err)
+
+	router := suite.router(AuthenticationConfig{DevelopmentBypass: true})
+	suite.Equal(http.StatusOK, suite.request(router, "").Code)
+	suite.Equal(developmentPrincipal, suite.principal)
+}
@@ -0,0 +1,204 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Permission allows an operation within a hospital
+type Permission string
+
+const (
+	PermissionReadEmployees Permission = "read-employees"
+	PermissionEditEmployees Permission = "edit-employees"
+	PermissionManageRoles   Permission = "manage-roles"
+	// PermissionTransferOut allows moving employees to another hospital, the target hospital
+	// requires PermissionEditEmployees
+	PermissionTransferOut Permission = "transfer-out"
+	// PermissionDeleteHospital granted for all hospitals allows also creating hospitals
+	PermissionDeleteHospital Permission = "delete-hospital"
+	// PermissionReadClinicalData allows reading patient names and details of the performances,
+	// they are masked for the other callers
+	PermissionReadClinicalData Permission = "read-clinical-data"
+	// PermissionReadAccessLog allows reading who accessed the patient data in the hospital
+	PermissionReadAccessLog Permission = "read-access-log"
+)
+
+var allPermissions = []Permission{
+	PermissionReadEmployees,
+	PermissionEditEmployees,
+	PermissionManageRoles,
+	PermissionTransferOut,
+	PermissionDeleteHospital,
+	PermissionReadClinicalData,
+	PermissionReadAccessLog,
+}
+
+// allHospitals is the hospital id of the roles granted in all hospitals
+const allHospitals = "*"
+
+// grantsKey is the key of the permissions of the caller in the request context
+const grantsKey = "grants"
+
+// routePermissions lists the permission each route of getRoutes requires in the hospital of its
+// hospitalId parameter, routes without the parameter require it in all hospitals. Routes with
+// empty permission are open to all authenticated callers, they check the permissions themselves.
+var routePermissions = map[string]Permission{
+	"GetAccessLog":              PermissionReadAccessLog,
+	"CreateEmployeeListEntry":   PermissionEditEmployees,
+	"DeleteEmployeeListEntry":   PermissionEditEmployees,
+	"GetEmployeeListEntries":    PermissionReadEmployees,
+	"GetEmployeeListEntry":      PermissionReadEmployees,
+	"PatchEmployeeListEntry":    PermissionEditEmployees,
+	"TransferEmployeeListEntry": PermissionTransferOut,
+	"UpdateEmployeeListEntry":   PermissionEditEmployees,
+	"GetPerformanceEntries":     PermissionReadEmployees,
+	"CreatePerformanceEntry":    PermissionEditEmployees,
+	"GetPerformanceEntry":       PermissionReadEmployees,
+	"UpdatePerformanceEntry":    PermissionEditEmployees,
+	"DeletePerformanceEntry":    PermissionEditEmployees,
+	"CreateRole":                PermissionManageRoles,
+	"DeleteRole":                PermissionManageRoles,
+	"GetRoles":                  PermissionReadEmployees,
+	"ReorderRoles":              PermissionManageRoles,
+	"UpdateRole":                PermissionManageRoles,
+	"CreateHospital":            PermissionDeleteHospital,
+	"DeleteHospital":            PermissionDeleteHospital,
+	"GetHospital":               "",
+	"GetHospitalDetail":         PermissionReadEmployees,
+	"PatchHospital":             PermissionManageRoles,
+	"UpdateHospital":            PermissionManageRoles,
+}
+
+// Policy maps the roles of the caller in the hospitals to permissions
+type Policy struct {
+	// Claim of the token listing the roles of the caller per hospital id, for example
+	// {"hospital-ba": ["nurse-coordinator"]}, roles listed under "*" apply to all hospitals
+	Claim string `json:"claim"`
+	// Roles grant the permissions to the callers having them
+	Roles map[string][]Permission `json:"roles"`
+}
+
+// DefaultPolicy is used unless a policy file is configured
+var DefaultPolicy = Policy{
+	Claim: "hospital_roles",
+	Roles: map[string][]Permission{
+		"viewer":             {PermissionReadEmployees},
+		"roster-manager":     {PermissionReadEmployees, PermissionEditEmployees},
+		"physician":          {PermissionReadEmployees, PermissionReadClinicalData},
+		"nurse-coordinator":  {PermissionReadEmployees, PermissionEditEmployees, PermissionTransferOut, PermissionReadClinicalData},
+		"compliance-officer": {PermissionReadAccessLog},
+		"hospital-admin":     allPermissions,
+	},
+}
+
+// LoadPolicy reads the policy from the JSON file
+func LoadPolicy(path string) (Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Policy{}, fmt.Errorf("failed to read authorization policy: %w", err)
+	}
+	var policy Policy
+	if err := json.Unmarshal(data, &policy); err != nil {
+		return Policy{}, fmt.Errorf("invalid authorization policy: %w", err)
+	}
+	if policy.Claim == "" {
+		return Policy{}, fmt.Errorf("invalid authorization policy: claim is required")
+	}
+	for role, permissions := range policy.Roles {
+		for _, permission := range permissions {
+			if !slices.Contains(allPermissions, permission) {
+				return Policy{}, fmt.Errorf("invalid authorization policy: unknown permission %v of role %v", permission, role)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// grants are the permissions of the caller per hospital id
+type grants map[string][]Permission
+
+func (g grants) allows(hospitalId string, permission Permission) bool {
+	return slices.Contains(g[allHospitals], permission) || (hospitalId != allHospitals && slices.Contains(g[hospitalId], permission))
+}
+
+// grantsOf maps the hospital roles in the claims of the caller to permissions, the developer
+// let through by the development bypass may do everything
+func (p Policy) grantsOf(principal *Principal) grants {
+	if principal == developmentPrincipal {
+		return grants{allHospitals: allPermissions}
+	}
+
+	result := grants{}
+	hospitals, _ := principal.Claims[p.Claim].(map[string]interface{})
+	for hospitalId, value := range hospitals {
+		var roles []interface{}
+		switch value := value.(type) {
+		case []interface{}:
+			roles = value
+		case string:
+			roles = []interface{}{value}
+		}
+		for _, role := range roles {
+			name, _ := role.(string)
+			for _, permission := range p.Roles[name] {
+				if !slices.Contains(result[hospitalId], permission) {
+					result[hospitalId] = append(result[hospitalId], permission)
+				}
+			}
+		}
+	}
+	return result
+}
+
+// NewAuthorizer creates the middleware enforcing the permissions of routePermissions on the routes
+// of handleFunctions, it must follow the authenticator. Routes missing in routePermissions fail
+// at startup, so that a new route is never left unprotected.
+func NewAuthorizer(handleFunctions ApiHandleFunctions, policy Policy) (gin.HandlerFunc, error) {
+	permissions := map[string]Permission{}
+	for _, route := range getRoutes(handleFunctions) {
+		permission, ok := routePermissions[route.Name]
+		if !ok {
+			return nil, fmt.Errorf("route %v %v has no permission assigned", route.Method, route.Pattern)
+		}
+		permissions[route.Method+" "+route.Pattern] = permission
+	}
+
+	return func(ctx *gin.Context) {
+		permission, ok := permissions[ctx.Request.Method+" "+ctx.FullPath()]
+		if !ok {
+			ctx.Next()
+			return
+		}
+		principal, ok := principalOf(ctx)
+		if !ok {
+			abortWithProblem(ctx, http.StatusUnauthorized, codeUnauthorized, "Caller is not authenticated")
+			return
+		}
+
+		callerGrants := policy.grantsOf(principal)
+		ctx.Set(grantsKey, callerGrants)
+		hospitalId := ctx.Param("hospitalId")
+		if hospitalId == "" {
+			hospitalId = allHospitals
+		}
+		if permission != "" && !callerGrants.allows(hospitalId, permission) {
+			abortWithProblem(ctx, http.StatusForbidden, codeForbidden, "Permission "+string(permission)+" is required")
+			return
+		}
+		ctx.Next()
+	}, nil
+}
+
+// authorized checks the permission of the caller in the hospital, callers not checked by the
+// authorizer have no permissions
+func authorized(ctx *gin.Context, hospitalId string, permission Permission) bool {
+	value, _ := ctx.Get(grantsKey)
+	callerGrants, _ := value.(grants)
+	return callerGrants.allows(hospitalId, permission)
+}
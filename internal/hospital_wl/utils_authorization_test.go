@@ -0,0 +1,92 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// coordinator is a nurse coordinator in the hospitals, the other roles are added by the test
+func coordinator(hospitalRoles map[string]interface{}) *Principal {
+	return &Principal{
+		Subject: "coordinator",
+		Claims:  map[string]interface{}{"sub": "coordinator", "hospital_roles": hospitalRoles},
+	}
+}
+
+func (suite *HospitalApiSuite) createTwoHospitals() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-nr", "name": "Hospital NR"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-nr/entries", `{"id": "entry-2", "name": "Ferko"}`)
+}
+
+func (suite *HospitalApiSuite) Test_Authorization_PermissionsPerHospital() {
+	suite.createTwoHospitals()
+	suite.principal = coordinator(map[string]interface{}{"hospital-ba": []interface{}{"nurse-coordinator"}})
+
+	suite.Equal(http.StatusOK, suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1", "").Code)
+	suite.Equal(http.StatusOK, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-3", "name": "Janko"}`).Code)
+
+	recorder := suite.request(http.MethodDelete, "/api/employee-list/hospital-nr/entries/entry-2", "")
+	suite.Equal(http.StatusForbidden, recorder.Code)
+	var problem Problem
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &problem))
+	suite.Equal(codeForbidden, problem.Code)
+	suite.Equal(http.StatusForbidden, suite.request(http.MethodGet, "/api/employee-list/hospital-nr/entries", "").Code)
+	suite.Equal(http.StatusForbidden, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/role", `{"value": "Surgeon", "code": "surgeon"}`).Code)
+	suite.Equal(http.StatusForbidden, suite.request(http.MethodDelete, "/api/hospital/hospital-ba", "").Code)
+	suite.Equal(http.StatusForbidden, suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ke", "name": "Hospital KE"}`).Code)
+
+	_, err := suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-nr/entry-2")
+	suite.NoError(err)
+}
+
+func (suite *HospitalApiSuite) Test_Authorization_TransferNeedsBothHospitals() {
+	suite.createTwoHospitals()
+	transfer := `{"targetHospitalId": "hospital-nr"}`
+
+	suite.principal = coordinator(map[string]interface{}{"hospital-ba": []interface{}{"nurse-coordinator"}})
+	suite.Equal(http.StatusForbidden, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/transfer", transfer).Code)
+	suite.Equal(http.StatusForbidden, suite.request(http.MethodPost, "/api/employee-list/hospital-nr/entries/entry-2/transfer",
+		`{"targetHospitalId": "hospital-ba"}`).Code)
+
+	suite.principal = coordinator(map[string]interface{}{"hospital-ba": []interface{}{"viewer"}, "hospital-nr": "nurse-coordinator"})
+	suite.Equal(http.StatusForbidden, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/transfer", transfer).Code)
+
+	suite.principal = coordinator(map[string]interface{}{"hospital-ba": []interface{}{"nurse-coordinator"}, "hospital-nr": "nurse-coordinator"})
+	suite.Equal(http.StatusOK, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/transfer", transfer).Code)
+}
+
+func (suite *HospitalApiSuite) Test_Authorization_HospitalListHidesEmployees() {
+	suite.createTwoHospitals()
+	suite.principal = coordinator(map[string]interface{}{"hospital-ba": []interface{}{"viewer"}})
+
+	recorder := suite.request(http.MethodGet, "/api/hospital?expand=employeeList", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	var hospitals []HospitalSummary
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &hospitals))
+	suite.Require().Len(hospitals, 2)
+	for _, hospital := range hospitals {
+		suite.EqualValues(1, hospital.EmployeeCount, hospital.Id)
+		if hospital.Id == "hospital-ba" {
+			suite.Len(hospital.EmployeeList, 1)
+		} else {
+			suite.Empty(hospital.EmployeeList)
+		}
+	}
+}
+
+func (suite *HospitalApiSuite) Test_LoadPolicy_UnknownPermissionRejected() {
+	path := filepath.Join(suite.T().TempDir(), "policy.json")
+	suite.Require().NoError(os.WriteFile(path, []byte(`{"claim": "roles", "roles": {"admin": ["delete-everything"]}}`), 0o600))
+	_, err := LoadPolicy(path)
+	suite.Error(err)
+
+	suite.Require().NoError(os.WriteFile(path, []byte(`{"claim": "roles", "roles": {"admin": ["manage-roles"]}}`), 0o600))
+	policy, err := LoadPolicy(path)
+	suite.Require().NoError(err)
+	suite.True(policy.grantsOf(&Principal{Claims: map[string]interface{}{"roles": map[string]interface{}{"*": "admin"}}}).
+		allows("hospital-ba", PermissionManageRoles))
+}
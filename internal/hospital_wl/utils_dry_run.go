@@ -0,0 +1,31 @@
+package hospital_wl
+
+import (
+	"errors"
+
+	"github.com/gin-gonic/gin"
+)
+
+// dryRunChangesHeader lists the documents a dry run would change, one value per document
+// in the form "<change> <kind>:<document id>", for example "delete entry:hospital-ba/entry-1"
+const dryRunChangesHeader = "X-Dry-Run-Changes"
+
+const (
+	changeCreate = "create"
+	changeUpdate = "update"
+	changeDelete = "delete"
+)
+
+// errDryRun aborts the storage transaction of a dry run after its changes were evaluated
+var errDryRun = errors.New("dry run")
+
+// isDryRun checks the dryRun query parameter. Dry runs evaluate the request including its
+// validation and respond as the request would, but store nothing.
+func isDryRun(ctx *gin.Context) bool {
+	return ctx.Query("dryRun") == "true"
+}
+
+// reportChange lists the document in the dry run response, kind is hospital, entry or performance
+func reportChange(ctx *gin.Context, change string, kind string, documentId string) {
+	ctx.Writer.Header().Add(dryRunChangesHeader, change+" "+kind+":"+documentId)
+}
@@ -0,0 +1,84 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+func (suite *HospitalApiSuite) Test_DryRun_TransferNotStored() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-nr", "name": "Hospital NR"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+
+	recorder := suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/transfer?dryRun=true", `{"targetHospitalId": "hospital-nr"}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal([]string{"delete entry:hospital-ba/entry-1", "create entry:hospital-nr/entry-1"}, recorder.Header().Values(dryRunChangesHeader))
+	var entry EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entry))
+	suite.Equal("Jozko", entry.Name)
+
+	_, err := suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-ba/entry-1")
+	suite.NoError(err)
+	_, err = suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-nr/entry-1")
+	suite.Equal(db_service.ErrNotFound, err)
+
+	// the checks of the transfer are evaluated
+	recorder = suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-2/transfer?dryRun=true", `{"targetHospitalId": "hospital-nr"}`)
+	suite.Equal(http.StatusNotFound, recorder.Code)
+	suite.Empty(recorder.Header().Values(dryRunChangesHeader))
+}
+
+func (suite *HospitalApiSuite) Test_DryRun_UpdateHospitalNotStored() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+
+	recorder := suite.requestWithType(http.MethodPatch, "/api/hospital/hospital-ba?dryRun=true", "application/merge-patch+json",
+		`{"name": "Hospital Bratislava"}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal([]string{"update hospital:hospital-ba"}, recorder.Header().Values(dryRunChangesHeader))
+	var hospital Hospital
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &hospital))
+	suite.Equal("Hospital Bratislava", hospital.Name)
+
+	stored, err := suite.dbService.FindDocument(suite.T().Context(), "hospital-ba")
+	suite.Require().NoError(err)
+	suite.Equal("Hospital BA", stored.Name)
+}
+
+func (suite *HospitalApiSuite) Test_DryRun_DeletionsListDocuments() {
+	suite.createHospitalWithRoles()
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "e-1", "name": "Jozko", "role": {"code": "nurse"}}`)
+
+	recorder := suite.request(http.MethodDelete, "/api/employee-list/hospital-ba/role/nurse?reassignTo=doctor&dryRun=true", "")
+	suite.Equal(http.StatusNoContent, recorder.Code)
+	suite.Equal([]string{"update entry:hospital-ba/e-1", "update hospital:hospital-ba"}, recorder.Header().Values(dryRunChangesHeader))
+
+	recorder = suite.request(http.MethodDelete, "/api/hospital/hospital-ba?dryRun=true", "")
+	suite.Equal(http.StatusNoContent, recorder.Code)
+	suite.Equal([]string{"delete hospital:hospital-ba", "delete entry:hospital-ba/e-1"}, recorder.Header().Values(dryRunChangesHeader))
+
+	recorder = suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/e-1", "")
+	suite.Equal(http.StatusOK, recorder.Code)
+	var entry EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entry))
+	suite.Equal("nurse", entry.Role.Code)
+}
+
+func (suite *HospitalApiSuite) Test_DryRun_IdempotencyKeyNotUsed() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	path := "/api/employee-list/hospital-ba/entries"
+	body := `{"id": "entry-1", "name": "Jozko"}`
+
+	recorder := suite.requestWithHeader(http.MethodPost, path+"?dryRun=true", idempotentHeader("key-1"), body)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal([]string{"create entry:hospital-ba/entry-1"}, recorder.Header().Values(dryRunChangesHeader))
+
+	recorder = suite.requestWithHeader(http.MethodPost, path, idempotentHeader("key-1"), body)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Empty(recorder.Header().Get(idempotentReplayedHeader))
+	suite.Empty(recorder.Header().Values(dryRunChangesHeader))
+
+	recorder = suite.request(http.MethodPost, path+"?dryRun=true", body)
+	suite.Equal(http.StatusConflict, recorder.Code)
+}
@@ -0,0 +1,145 @@
+package hospital_wl
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// employeeUpdater modifies the loaded employee list entry, like hospitalUpdater it may be
+// invoked repeatedly for the same request
+type employeeUpdater = func(
+	ctx *gin.Context,
+	entry *EmployeeListEntry,
+) (updatedEntry *EmployeeListEntry, responseContent interface{}, status int)
+
+// updateEmployeeFunc applies the updater to the entry addressed by hospitalId and entryId path parameters.
+// The updater receives the entry including its performances, the performances of the updated entry
+// replace the loaded ones once the entry is stored.
+func (d *apiDependencies) updateEmployeeFunc(ctx *gin.Context, updater employeeUpdater) {
+	hospitalId, entryId := ctx.Param("hospitalId"), ctx.Param("entryId")
+	var loaded, performances []PerformanceEntry
+	updateDocumentFunc(
+		ctx,
+		d.logger,
+		d.store.Employees,
+		employeeDocumentId(hospitalId, entryId),
+		"entry",
+		func(document *EmployeeDocument) *int64 { return &document.Version },
+		func(ctx *gin.Context, document *EmployeeDocument) (*EmployeeDocument, interface{}, int) {
+			var err error
+			if loaded, err = d.findPerformances(ctx, document.Id); err != nil {
+				return nil, storageFailureProblem(ctx, d.logger, "Failed to load performances from database", err), http.StatusBadGateway
+			}
+			document.Entry.Performances = slices.Clone(loaded)
+			updatedEntry, responseContent, status := updater(ctx, &document.Entry)
+			if updatedEntry == nil {
+				return nil, responseContent, status
+			}
+			performances = updatedEntry.Performances
+			document.Entry = *updatedEntry
+			document.Entry.Performances = nil
+			return document, responseContent, status
+		},
+		func(ctx *gin.Context) bool {
+			if err := d.replacePerformances(ctx, hospitalId, entryId, loaded, performances); err != nil {
+				abortWithStorageFailure(ctx, d.logger, "Failed to update performances in database", err)
+				return false
+			}
+			return true
+		},
+	)
+}
+
+// performanceUpdater modifies the loaded performance, like hospitalUpdater it may be
+// invoked repeatedly for the same request
+type performanceUpdater = func(
+	ctx *gin.Context,
+	performance *PerformanceEntry,
+) (updatedPerformance *PerformanceEntry, responseContent interface{}, status int)
+
+// updatePerformanceFunc applies the updater to the performance addressed by hospitalId, entryId
+// and performanceId path parameters. Only the performance is replaced, so concurrent changes
+// of the entry or its other performances do not collide.
+func (d *apiDependencies) updatePerformanceFunc(ctx *gin.Context, updater performanceUpdater) {
+	if !d.employeeExists(ctx) {
+		return
+	}
+	employeeId := employeeDocumentId(ctx.Param("hospitalId"), ctx.Param("entryId"))
+	updateDocumentFunc(
+		ctx,
+		d.logger,
+		d.store.Performances,
+		performanceDocumentId(employeeId, ctx.Param("performanceId")),
+		"performance",
+		func(document *PerformanceDocument) *int64 { return &document.Version },
+		func(ctx *gin.Context, document *PerformanceDocument) (*PerformanceDocument, interface{}, int) {
+			updatedPerformance, responseContent, status := updater(ctx, &document.Performance)
+			if updatedPerformance == nil {
+				return nil, responseContent, status
+			}
+			document.Performance = *updatedPerformance
+			return document, responseContent, status
+		},
+		nil,
+	)
+}
+
+// employeeExists checks that the entry addressed by hospitalId and entryId path parameters
+// is stored, responding with an error if it is not
+func (d *apiDependencies) employeeExists(ctx *gin.Context) bool {
+	_, err := d.store.Employees.FindDocumentWith(ctx, employeeDocumentId(ctx.Param("hospitalId"), ctx.Param("entryId")),
+		db_service.ReadOptions{Fields: []string{"id"}})
+	switch err {
+	case nil:
+		return true
+	case db_service.ErrNotFound:
+		abortWithProblem(ctx, http.StatusNotFound, codeEntryNotFound, "Entry not found")
+	default:
+		abortWithStorageFailure(ctx, d.logger, "Failed to load entry from database", err)
+	}
+	return false
+}
+
+// abortWithPerformanceNotFound responds to a missing performance, telling whether the entry is missing too
+func (d *apiDependencies) abortWithPerformanceNotFound(ctx *gin.Context) {
+	if d.employeeExists(ctx) {
+		abortWithProblem(ctx, http.StatusNotFound, codePerformanceNotFound, "Performance entry not found")
+	}
+}
+
+// findEmployeeEntry loads the entry addressed by hospitalId and entryId path parameters
+// including its performances, responding with an error if it cannot be loaded
+func (d *apiDependencies) findEmployeeEntry(ctx *gin.Context) (*EmployeeListEntry, bool) {
+	entryId := ctx.Param("entryId")
+	if entryId == "" {
+		abortWithProblem(ctx, http.StatusBadRequest, codeInvalidRequest, "Entry ID is required")
+		return nil, false
+	}
+
+	documentId := employeeDocumentId(ctx.Param("hospitalId"), entryId)
+	document, err := d.store.Employees.FindDocumentWith(ctx, documentId, db_service.ReadOptions{
+		Fields: []string{"entry"},
+	})
+	switch err {
+	case nil:
+		if document.Entry.Performances, err = d.findPerformances(ctx, documentId); err != nil {
+			abortWithStorageFailure(ctx, d.logger, "Failed to load performances from database", err)
+			return nil, false
+		}
+		return &document.Entry, true
+	case db_service.ErrNotFound:
+		abortWithProblem(ctx, http.StatusNotFound, codeEntryNotFound, "Entry not found")
+	default:
+		abortWithStorageFailure(ctx, d.logger, "Failed to load entry from database", err)
+	}
+	return nil, false
+}
+
+// respondWithETag sends the resource shaped for the caller together with the entity tag of the resource
+func respondWithETag(ctx *gin.Context, status int, resource interface{}) {
+	ctx.Header("ETag", etagOf(resource))
+	respondAudited(ctx, status, resource)
+}
@@ -0,0 +1,61 @@
+package hospital_wl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// etagKey keys the entity tags, the tag of the complete resource is sent also to the callers
+// receiving it masked, who must not be able to confirm guesses of the masked values against it.
+// The random key changes the tags on every start, replicas of the service share the key set by SetETagSecret.
+var etagKey = randomETagKey()
+
+func randomETagKey() []byte {
+	key := make([]byte, sha256.Size)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// SetETagSecret derives the key of the entity tags from the secret, it must be called before
+// the requests are served
+func SetETagSecret(secret string) {
+	sum := sha256.Sum256([]byte(secret))
+	etagKey = sum[:]
+}
+
+// etagOf computes the strong entity tag of the JSON representation of the resource
+func etagOf(resource interface{}) string {
+	data, err := json.Marshal(resource)
+	if err != nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, etagKey)
+	mac.Write(data)
+	return `"` + hex.EncodeToString(mac.Sum(nil)[:16]) + `"`
+}
+
+// ifMatchSatisfied evaluates the If-Match precondition of the request against the current
+// state of the resource. Requests without If-Match header are always satisfied. The strong
+// comparison is used, so weak validators never match (RFC 9110, section 13.1.1).
+func ifMatchSatisfied(ctx *gin.Context, resource interface{}) bool {
+	header := ctx.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	etag := etagOf(resource)
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -1,112 +1,159 @@
 package hospital_wl
 
 import (
+	"io"
+	"log"
 	"net/http"
+	"strings"
 
-	"github.com/xkello/ambulance-otapi/internal/db_service"
 	"github.com/gin-gonic/gin"
+	"github.com/xkello/ambulance-otapi/internal/db_service"
 )
 
+// maxUpdateAttempts limits retries of updates losing the race with a concurrent modification
+const maxUpdateAttempts = 5
+
+// hospitalUpdater modifies the loaded hospital. It may be invoked repeatedly for the same
+// request when the hospital was modified concurrently, so the request body must be bound
+// with ShouldBindBodyWithJSON.
 type hospitalUpdater = func(
 	ctx *gin.Context,
 	hospital *Hospital,
 ) (updatedHospital *Hospital, responseContent interface{}, status int)
 
-func updateHospitalFunc(ctx *gin.Context, updater hospitalUpdater) {
-	value, exists := ctx.Get("db_service")
-	if !exists {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service not found",
-				"error":   "db_service not found",
-			})
-		return
+// requestBody reads the raw request body and caches it the same way as ShouldBindBodyWithJSON,
+// so that updaters invoked repeatedly can read it again
+func requestBody(ctx *gin.Context) ([]byte, error) {
+	if cached, ok := ctx.Get(gin.BodyBytesKey); ok {
+		if body, ok := cached.([]byte); ok {
+			return body, nil
+		}
 	}
-
-	db, ok := value.(db_service.DbService[Hospital])
-	if !ok {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "db_service context is not of type db_service.DbService",
-				"error":   "cannot cast db_service context to db_service.DbService",
-			})
-		return
+	body, err := io.ReadAll(ctx.Request.Body)
+	if err != nil {
+		return nil, err
 	}
+	ctx.Set(gin.BodyBytesKey, body)
+	return body, nil
+}
 
-	hospitalId := ctx.Param("hospitalId")
+// storedHandler completes a successful update before it is responded, for example by updating
+// the documents depending on the updated one. It responds with an error and returns false
+// if it fails.
+type storedHandler = func(ctx *gin.Context) bool
 
-	hospital, err := db.FindDocument(ctx, hospitalId)
+func (d *apiDependencies) updateHospitalFunc(ctx *gin.Context, updater hospitalUpdater) {
+	d.updateHospitalThenFunc(ctx, updater, nil)
+}
 
-	switch err {
-	case nil:
-		// continue
-	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Hospital not found",
-				"error":   err.Error(),
-			},
-		)
-		return
-	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to load hospital from database",
-				"error":   err.Error(),
-			})
-		return
-	}
+// updateHospitalThenFunc updates the hospital like updateHospitalFunc and runs the stored handler
+// once the updated hospital is stored, the handler is not run for dry runs and failed updates
+func (d *apiDependencies) updateHospitalThenFunc(ctx *gin.Context, updater hospitalUpdater, stored storedHandler) {
+	updateDocumentFunc(
+		ctx,
+		d.logger,
+		d.store.Hospitals,
+		ctx.Param("hospitalId"),
+		"hospital",
+		func(hospital *Hospital) *int64 { return &hospital.Version },
+		updater,
+		stored,
+	)
+}
 
-	if !ok {
-		ctx.JSON(
-			http.StatusInternalServerError,
-			gin.H{
-				"status":  "Internal Server Error",
-				"message": "Failed to cast hospital from database",
-				"error":   "Failed to cast hospital from database",
-			})
-		return
-	}
+// updateDocumentFunc loads the document, lets the updater modify it and stores the result
+// with compare-and-swap on the document version. Unconditional requests are retried
+// when the document was modified concurrently. Dry runs respond with the result of the
+// updater without storing it. The optional stored handler runs after the document is stored
+// and before the response is sent.
+func updateDocumentFunc[DocType interface{}](
+	ctx *gin.Context,
+	logger *log.Logger,
+	db db_service.DbService[DocType],
+	documentId string,
+	documentName string,
+	version func(document *DocType) *int64,
+	updater func(ctx *gin.Context, document *DocType) (*DocType, interface{}, int),
+	stored storedHandler,
+) {
+	title := strings.ToUpper(documentName[:1]) + documentName[1:]
+	// conditional requests must not be retried, the precondition was evaluated against stale data
+	conditional := ctx.GetHeader("If-Match") != ""
+
+	for attempt := 1; ; attempt++ {
+		document, err := db.FindDocument(ctx, documentId)
+
+		switch err {
+		case nil:
+			// continue
+		case db_service.ErrNotFound:
+			// the document names are chosen so that the codes are hospital-not-found and entry-not-found
+			abortWithProblem(ctx, http.StatusNotFound, documentName+"-not-found", title+" not found")
+			return
+		default:
+			abortWithStorageFailure(ctx, logger, "Failed to load "+documentName+" from database", err)
+			return
+		}
+
+		expectedVersion := *version(document)
+		updatedDocument, responseObject, status := updater(ctx, document)
+
+		switch {
+		case updatedDocument == nil:
+			err = nil // redundant but for clarity
+		case isDryRun(ctx):
+			// the updated document is only reported, the response shows its resulting state
+			*version(updatedDocument) = expectedVersion + 1
+			reportChange(ctx, changeUpdate, documentName, documentId)
+			err = nil
+		default:
+			*version(updatedDocument) = expectedVersion + 1
+			err = db.CompareAndSwapDocument(ctx, documentId, expectedVersion, updatedDocument)
+		}
+
+		if err == db_service.ErrVersionMismatch && !conditional && attempt < maxUpdateAttempts {
+			continue
+		}
 
-	updatedHospital, responseObject, status := updater(ctx, hospital)
+		if err == nil && updatedDocument != nil && stored != nil && !isDryRun(ctx) && !stored(ctx) {
+			return
+		}
 
-	if updatedHospital != nil {
-		err = db.UpdateDocument(ctx, hospitalId, updatedHospital)
-	} else {
-		err = nil // redundant but for clarity
+		switch err {
+		case nil:
+			if responseObject != nil {
+				if status < http.StatusBadRequest {
+					ctx.Header("ETag", etagOf(responseObject))
+				}
+				respond(ctx, status, responseObject)
+			} else {
+				ctx.AbortWithStatus(status)
+			}
+		case db_service.ErrNotFound:
+			abortWithProblem(ctx, http.StatusNotFound, documentName+"-not-found", title+" was deleted while processing the request")
+		case db_service.ErrVersionMismatch:
+			if conditional {
+				abortWithProblem(ctx, http.StatusPreconditionFailed, codePreconditionFailed, title+" was modified while processing the request")
+			} else {
+				abortWithProblem(ctx, http.StatusConflict, codeConcurrentModification, title+" is being modified concurrently, try again later")
+			}
+		default:
+			abortWithStorageFailure(ctx, logger, "Failed to update "+documentName+" in database", err)
+		}
+		return
 	}
+}
 
+// hospitalExists checks that the hospital is stored, responding with an error if it is not
+func (d *apiDependencies) hospitalExists(ctx *gin.Context, hospitalId string) bool {
+	_, err := d.store.Hospitals.FindDocumentWith(ctx, hospitalId, db_service.ReadOptions{Fields: []string{"id"}})
 	switch err {
 	case nil:
-		if responseObject != nil {
-			ctx.JSON(status, responseObject)
-		} else {
-			ctx.AbortWithStatus(status)
-		}
+		return true
 	case db_service.ErrNotFound:
-		ctx.JSON(
-			http.StatusNotFound,
-			gin.H{
-				"status":  "Not Found",
-				"message": "Hospital was deleted while processing the request",
-				"error":   err.Error(),
-			},
-		)
+		abortWithProblem(ctx, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
 	default:
-		ctx.JSON(
-			http.StatusBadGateway,
-			gin.H{
-				"status":  "Bad Gateway",
-				"message": "Failed to update hospital in database",
-				"error":   err.Error(),
-			})
+		abortWithStorageFailure(ctx, d.logger, "Failed to load hospital from database", err)
 	}
+	return false
 }
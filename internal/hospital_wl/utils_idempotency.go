@@ -0,0 +1,179 @@
+package hospital_wl
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyRecordLifetime = 24 * time.Hour
+)
+
+// idempotentFunc runs the handler of a creating request at most once for each Idempotency-Key
+// of the hospital. The first response is stored and replayed to the retries of the request,
+// a key reused for a different request or by another caller is rejected with 422. Responses with 5xx status are
+// not stored, so that the request can be retried after the failure. Requests without the key
+// and dry runs are handled as usual, a dry run must not occupy the key of the real request.
+// The successful responses replayed are decoded by decodeResponse, so that they are sent
+// like the responses of the handler, see replayResponse.
+func (d *apiDependencies) idempotentFunc(
+	ctx *gin.Context,
+	hospitalId string,
+	decodeResponse responseDecoder,
+	handler gin.HandlerFunc,
+) {
+	key := ctx.GetHeader(idempotencyKeyHeader)
+	if key == "" || isDryRun(ctx) {
+		handler(ctx)
+		return
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		abortWithProblem(ctx, http.StatusBadRequest, codeInvalidRequest, "Idempotency-Key must not be longer than 255 characters")
+		return
+	}
+	if d.store.Idempotency == nil {
+		abortWithProblem(ctx, http.StatusInternalServerError, codeInternalError, "Idempotency records storage is not configured")
+		return
+	}
+
+	body, err := requestBody(ctx)
+	if err != nil {
+		respondWithProblem(ctx, invalidBodyProblem(err))
+		return
+	}
+	// the handler binds the body again
+	ctx.Request.Body = io.NopCloser(bytes.NewReader(body))
+	hash := sha256.New()
+	hash.Write([]byte(ctx.Request.Method + " " + ctx.Request.URL.Path + "\n"))
+	// the responses are shaped for the caller, so they are replayed only to the same caller
+	if principal, ok := principalOf(ctx); ok {
+		hash.Write([]byte(principal.Subject + "\n"))
+	}
+	hash.Write(body)
+
+	now := d.clock()
+	recordId := idempotencyRecordId(hospitalId, key)
+	record := &IdempotencyRecord{
+		Id:          recordId,
+		HospitalId:  hospitalId,
+		Key:         key,
+		RequestHash: hex.EncodeToString(hash.Sum(nil)),
+		ExpiresAt:   now.Add(idempotencyRecordLifetime),
+	}
+
+	existing, err := d.claimIdempotencyKey(ctx, record, now)
+	switch {
+	case err != nil:
+		abortWithStorageFailure(ctx, d.logger, "Failed to store idempotency key", err)
+		return
+	case existing != nil:
+		replayResponse(ctx, existing, record.RequestHash, decodeResponse)
+		return
+	}
+
+	writer := &recordingWriter{ResponseWriter: ctx.Writer}
+	ctx.Writer = writer
+	handler(ctx)
+	ctx.Writer = writer.ResponseWriter
+
+	if writer.Status() >= http.StatusInternalServerError {
+		if err := d.store.Idempotency.DeleteDocument(ctx, recordId); err != nil {
+			d.logger.Printf("Failed to release idempotency key %v after failed request: %v", recordId, err)
+		}
+		return
+	}
+	record.Status = writer.Status()
+	record.ContentType = writer.Header().Get("Content-Type")
+	record.ETag = writer.Header().Get("ETag")
+	record.Body = writer.body.String()
+	if err := d.store.Idempotency.UpdateDocument(ctx, recordId, record); err != nil {
+		// the response is already sent, retries of the request are rejected as in progress until the record expires
+		d.logger.Printf("Failed to store response for idempotency key %v: %v", recordId, err)
+	}
+}
+
+// responseDecoder decodes the stored body of a successful response into its content
+type responseDecoder func(body []byte) (interface{}, error)
+
+// replayedAs decodes the stored responses with the content of type T
+func replayedAs[T interface{}](body []byte) (interface{}, error) {
+	var content T
+	err := json.Unmarshal(body, &content)
+	return content, err
+}
+
+// replayResponse sends the stored response of the request, if the record belongs to the same request.
+// The successful responses include patient data, they are sent through respondAudited, so that
+// the replay is shaped for the caller and recorded in the access log like the original response.
+func replayResponse(ctx *gin.Context, record *IdempotencyRecord, requestHash string, decodeResponse responseDecoder) {
+	switch {
+	case record.RequestHash != requestHash:
+		abortWithProblem(ctx, http.StatusUnprocessableEntity, codeIdempotencyKeyReused,
+			"Idempotency-Key was already used for a different request")
+	case record.Status == 0:
+		abortWithProblem(ctx, http.StatusConflict, codeRequestInProgress,
+			"Request with the same Idempotency-Key is still being processed, try again later")
+	default:
+		if record.ETag != "" {
+			ctx.Header("ETag", record.ETag)
+		}
+		ctx.Header(idempotentReplayedHeader, "true")
+		if record.Status < http.StatusOK || record.Status >= http.StatusMultipleChoices {
+			ctx.Data(record.Status, record.ContentType, []byte(record.Body))
+			ctx.Abort()
+			return
+		}
+		content, err := decodeResponse([]byte(record.Body))
+		if err != nil {
+			abortWithProblem(ctx, http.StatusInternalServerError, codeInternalError, "Stored response cannot be replayed")
+			return
+		}
+		respondAudited(ctx, record.Status, content)
+		ctx.Abort()
+	}
+}
+
+// claimIdempotencyKey stores the record of the request being processed. The record is inserted,
+// so that of concurrent retries only one claims the key, the others receive the record
+// of the claiming request. An expired record is replaced only if it was not changed meanwhile.
+func (d *apiDependencies) claimIdempotencyKey(ctx *gin.Context, record *IdempotencyRecord, now time.Time) (*IdempotencyRecord, error) {
+	for {
+		err := d.store.Idempotency.CreateDocument(ctx, record.Id, record)
+		if !errors.Is(err, db_service.ErrConflict) {
+			return nil, err
+		}
+		existing, err := d.store.Idempotency.FindDocument(ctx, record.Id)
+		switch {
+		case errors.Is(err, db_service.ErrNotFound):
+			// removed since the conflict, claim it again
+			continue
+		case err != nil:
+			return nil, err
+		case existing.ExpiresAt.After(now):
+			return existing, nil
+		}
+
+		record.Version = existing.Version + 1
+		err = d.store.Idempotency.CompareAndSwapDocument(ctx, record.Id, existing.Version, record)
+		switch {
+		case errors.Is(err, db_service.ErrVersionMismatch) || errors.Is(err, db_service.ErrNotFound):
+			// another retry replaced or removed the expired record first
+			continue
+		default:
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+func idempotentHeader(key string) http.Header {
+	return http.Header{"Content-Type": {"application/json"}, "Idempotency-Key": {key}}
+}
+
+func (suite *HospitalApiSuite) Test_Idempotency_RetryReplayed() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+	path := "/api/employee-list/hospital-ba/entries/entry-1/performances"
+	body := `{"activityType": "surgery", "patientName": "John Doe", "activityDate": "2023-05-01", "details": "Routine"}`
+
+	first := suite.requestWithHeader(http.MethodPost, path, idempotentHeader("key-1"), body)
+	suite.Equal(http.StatusOK, first.Code)
+	retry := suite.requestWithHeader(http.MethodPost, path, idempotentHeader("key-1"), body)
+	suite.Equal(http.StatusOK, retry.Code)
+	suite.Equal("true", retry.Header().Get("Idempotent-Replayed"))
+	suite.Equal(first.Body.String(), retry.Body.String())
+	suite.Equal(first.Header().Get("ETag"), retry.Header().Get("ETag"))
+	suite.Len(suite.accessesOf(developmentPrincipal.Subject), 2, "replayed patient data is recorded")
+
+	var performances []PerformanceEntry
+	suite.NoError(json.Unmarshal(suite.request(http.MethodGet, path, "").Body.Bytes(), &performances))
+	suite.Len(performances, 1)
+}
+
+func (suite *HospitalApiSuite) Test_Idempotency_KeyReusedForDifferentPayload_Unprocessable() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	path := "/api/employee-list/hospital-ba/entries"
+
+	suite.Equal(http.StatusOK, suite.requestWithHeader(http.MethodPost, path, idempotentHeader("key-1"), `{"name": "Jozko"}`).Code)
+	recorder := suite.requestWithHeader(http.MethodPost, path, idempotentHeader("key-1"), `{"name": "Ferko"}`)
+	suite.Equal(http.StatusUnprocessableEntity, recorder.Code)
+	var problem Problem
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &problem))
+	suite.Equal(codeIdempotencyKeyReused, problem.Code)
+
+	// keys are scoped by hospital
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-nr", "name": "Hospital NR"}`)
+	suite.Equal(http.StatusOK,
+		suite.requestWithHeader(http.MethodPost, "/api/employee-list/hospital-nr/entries", idempotentHeader("key-1"), `{"name": "Ferko"}`).Code)
+}
+
+func (suite *HospitalApiSuite) Test_Idempotency_ExpiredKeyReused() {
+	header := idempotentHeader("key-1")
+	suite.Equal(http.StatusCreated, suite.requestWithHeader(http.MethodPost, "/api/hospital", header, `{"name": "Hospital BA"}`).Code)
+	suite.Equal(http.StatusCreated, suite.requestWithHeader(http.MethodPost, "/api/hospital", header, `{"name": "Hospital BA"}`).Code)
+
+	suite.now = suite.now.Add(25 * time.Hour)
+	suite.Equal(http.StatusCreated, suite.requestWithHeader(http.MethodPost, "/api/hospital", header, `{"name": "Hospital BA"}`).Code)
+
+	var hospitals []HospitalSummary
+	suite.NoError(json.Unmarshal(suite.request(http.MethodGet, "/api/hospital", "").Body.Bytes(), &hospitals))
+	suite.Len(hospitals, 2)
+}
+
+func (suite *HospitalApiSuite) Test_Idempotency_EncryptedResponseReplayed() {
+	keyring, err := db_service.NewKeyring("k1", map[string][]byte{"k1": make([]byte, 32)})
+	suite.Require().NoError(err)
+	stored := suite.idempotencyDbService
+	suite.idempotencyDbService = db_service.NewEncryptedService(stored, IdempotencyEncryptionConfig(keyring))
+	suite.setupRouter()
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+	path := "/api/employee-list/hospital-ba/entries/entry-1/performances"
+	body := `{"activityType": "surgery", "patientName": "John Doe", "activityDate": "2023-05-01", "details": "Routine"}`
+
+	first := suite.requestWithHeader(http.MethodPost, path, idempotentHeader("key-1"), body)
+	suite.Equal(http.StatusOK, first.Code)
+	records, err := stored.ListDocuments(suite.T().Context())
+	suite.Require().NoError(err)
+	suite.Require().Len(records, 1)
+	suite.True(strings.HasPrefix(records[0].Body, "enc:r:k1:"), records[0].Body)
+
+	retry := suite.requestWithHeader(http.MethodPost, path, idempotentHeader("key-1"), body)
+	suite.Equal("true", retry.Header().Get("Idempotent-Replayed"))
+	suite.Equal(first.Body.String(), retry.Body.String())
+}
@@ -0,0 +1,223 @@
+package hospital_wl
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// employeeSortFields maps the values of the sort query parameter to the employee document fields
+var employeeSortFields = map[string]string{
+	"name":        "entry.name",
+	"role":        "entry.role.value",
+	"performance": "entry.performance",
+}
+
+// bindPage reads page and pageSize query parameters into the query. Requests without them
+// are not paged, so that existing clients keep receiving complete lists.
+func bindPage(ctx *gin.Context, query *db_service.Query) bool {
+	pageParam, pageSizeParam := ctx.Query("page"), ctx.Query("pageSize")
+	if pageParam == "" && pageSizeParam == "" {
+		return true
+	}
+
+	page, pageSize := int64(1), int64(defaultPageSize)
+	var err error
+	if pageParam != "" {
+		if page, err = strconv.ParseInt(pageParam, 10, 64); err != nil || page < 1 {
+			respondInvalidQuery(ctx, "page must be a positive integer")
+			return false
+		}
+	}
+	if pageSizeParam != "" {
+		if pageSize, err = strconv.ParseInt(pageSizeParam, 10, 64); err != nil || pageSize < 1 || pageSize > maxPageSize {
+			respondInvalidQuery(ctx, "pageSize must be an integer between 1 and "+strconv.Itoa(maxPageSize))
+			return false
+		}
+	}
+
+	query.Skip = (page - 1) * pageSize
+	query.Limit = pageSize
+	return true
+}
+
+// bindSort reads the sort query parameter, a comma separated list of the allowed sort keys
+// optionally prefixed with "-" for descending order
+func bindSort(ctx *gin.Context, query *db_service.Query, fields map[string]string) bool {
+	sortParam := ctx.Query("sort")
+	if sortParam == "" {
+		return true
+	}
+	for _, key := range strings.Split(sortParam, ",") {
+		name, descending := strings.CutPrefix(key, "-")
+		field, ok := fields[name]
+		if !ok {
+			respondInvalidQuery(ctx, "unsupported sort key "+key)
+			return false
+		}
+		if descending {
+			field = "-" + field
+		}
+		query.Sort = append(query.Sort, field)
+	}
+	return true
+}
+
+// employeeListQuery builds the query selecting employees of the hospital by the filter,
+// sort and page query parameters. The patient the employees must have treated is returned
+// separately, as the patients are stored with the performances.
+func employeeListQuery(ctx *gin.Context, hospitalId string) (query db_service.Query, patient string, ok bool) {
+	query = hospitalEmployeesQuery(hospitalId)
+	query.GreaterOrEqual = map[string]interface{}{}
+	query.Contains = map[string]string{}
+
+	if role := ctx.Query("role"); role != "" {
+		query.Equal["entry.role.value"] = role
+	}
+	if minPerformanceParam := ctx.Query("minPerformance"); minPerformanceParam != "" {
+		minPerformance, err := strconv.ParseInt(minPerformanceParam, 10, 32)
+		if err != nil || minPerformance < 0 || minPerformance > 10 {
+			respondInvalidQuery(ctx, "minPerformance must be an integer between 0 and 10")
+			return query, patient, false
+		}
+		// zero performance is not serialized, so the lowest bound selects all entries
+		if minPerformance > 0 {
+			query.GreaterOrEqual["entry.performance"] = minPerformance
+		}
+	}
+	if name := ctx.Query("q"); name != "" {
+		query.Contains["entry.name"] = name
+	}
+	// the matching entries reveal who treated the patient
+	if patient = ctx.Query("patient"); patient != "" && !mayReadClinicalData(ctx) {
+		abortWithProblem(ctx, http.StatusForbidden, codeForbidden, "Permission "+string(PermissionReadClinicalData)+" is required to search by patient")
+		return query, patient, false
+	}
+
+	if !bindSort(ctx, &query, employeeSortFields) || !bindPage(ctx, &query) {
+		return query, patient, false
+	}
+	return query, patient, true
+}
+
+// performanceFilter selects performances of an employee by the activity type and the time range of their start
+type performanceFilter struct {
+	activityType string
+	from         time.Time
+	// to is exclusive, unlike the query parameter
+	to time.Time
+}
+
+// bindPerformanceFilter reads activityType, from and to query parameters, see bindTimeRange
+func bindPerformanceFilter(ctx *gin.Context) (performanceFilter, bool) {
+	filter := performanceFilter{activityType: ctx.Query("activityType")}
+	var ok bool
+	filter.from, filter.to, ok = bindTimeRange(ctx)
+	return filter, ok
+}
+
+// bindTimeRange reads from and to query parameters. The bounds are inclusive, a date without time
+// as the upper bound selects the whole day. The returned upper bound is exclusive, zero bounds are not set.
+func bindTimeRange(ctx *gin.Context) (from time.Time, to time.Time, ok bool) {
+	if fromParam := ctx.Query("from"); fromParam != "" {
+		parsed, err := parseActivityTime(fromParam)
+		if err != nil || parsed.legacy {
+			respondInvalidQuery(ctx, "from must be an RFC 3339 timestamp or YYYY-MM-DD date")
+			return from, to, false
+		}
+		from = parsed.Time
+	}
+	if toParam := ctx.Query("to"); toParam != "" {
+		parsed, err := parseActivityTime(toParam)
+		if err != nil || parsed.legacy {
+			respondInvalidQuery(ctx, "to must be an RFC 3339 timestamp or YYYY-MM-DD date")
+			return from, to, false
+		}
+		if _, err := time.Parse(time.DateOnly, toParam); err == nil {
+			to = parsed.AddDate(0, 0, 1)
+		} else {
+			to = parsed.Add(time.Second)
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		respondInvalidQuery(ctx, "from must not be after to")
+		return from, to, false
+	}
+	return from, to, true
+}
+
+func (filter performanceFilter) matches(performance PerformanceEntry) bool {
+	if filter.activityType != "" && performance.ActivityType != filter.activityType {
+		return false
+	}
+	start := performance.ActivityDate.Time
+	if !filter.from.IsZero() && (start.IsZero() || start.Before(filter.from)) {
+		return false
+	}
+	if !filter.to.IsZero() && (start.IsZero() || !start.Before(filter.to)) {
+		return false
+	}
+	return true
+}
+
+// accessLogSortFields maps the values of the sort query parameter to the access record fields
+var accessLogSortFields = map[string]string{
+	"accessedAt": "accessedAt",
+	"subject":    "subject",
+}
+
+// accessLogQuery builds the query selecting the access records of the hospital by the patient,
+// time range, sort and page query parameters, the newest records are listed first by default
+func accessLogQuery(ctx *gin.Context, hospitalId string) (db_service.Query, bool) {
+	query := db_service.Query{
+		Equal:          map[string]interface{}{"hospitalId": hospitalId},
+		GreaterOrEqual: map[string]interface{}{},
+		Less:           map[string]interface{}{},
+	}
+	if patient := ctx.Query("patient"); patient != "" {
+		query.Equal["patients"] = patient
+	}
+	from, to, ok := bindTimeRange(ctx)
+	if !ok {
+		return query, false
+	}
+	// the records are stored with whole seconds in UTC, so that they compare as their JSON form,
+	// the bounds are widened to whole seconds, as the records of a partial second may fall within
+	if !from.IsZero() {
+		query.GreaterOrEqual["accessedAt"] = from.UTC().Truncate(time.Second)
+	}
+	if !to.IsZero() {
+		less := to.UTC().Truncate(time.Second)
+		if less.Before(to) {
+			less = less.Add(time.Second)
+		}
+		query.Less["accessedAt"] = less
+	}
+
+	if !bindSort(ctx, &query, accessLogSortFields) || !bindPage(ctx, &query) {
+		return query, false
+	}
+	if len(query.Sort) == 0 {
+		query.Sort = []string{"-accessedAt"}
+	}
+	return query, true
+}
+
+// paged checks whether the query selects only a part of the matching documents
+func paged(query db_service.Query) bool {
+	return query.Skip > 0 || query.Limit > 0
+}
+
+func respondInvalidQuery(ctx *gin.Context, message string) {
+	abortWithProblem(ctx, http.StatusBadRequest, codeInvalidRequest, "Invalid query parameters: "+message)
+}
@@ -0,0 +1,22 @@
+package hospital_wl
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xkello/ambulance-otapi/api"
+)
+
+// HandleOpenApiJson serves the embedded specification as JSON for tools not reading YAML,
+// the cause of a failure is only logged like the storage failures
+func HandleOpenApiJson(ctx *gin.Context) {
+	data, err := api.OpenApiJson()
+	if err != nil {
+		log.Printf("%v %v: Failed to render the OpenAPI specification: %v", ctx.Request.Method, ctx.Request.URL.Path, err)
+		abortWithProblem(ctx, http.StatusInternalServerError, codeInternalError, "Failed to render the OpenAPI specification")
+		return
+	}
+	ctx.Data(http.StatusOK, "application/json", data)
+}
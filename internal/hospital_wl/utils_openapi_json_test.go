@@ -0,0 +1,20 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+)
+
+func (suite *HospitalApiSuite) Test_OpenApiJson_Served() {
+	suite.router.GET("/openapi.json", HandleOpenApiJson)
+
+	recorder := httptest.NewRecorder()
+	suite.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/openapi.json", nil))
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Equal("application/json", recorder.Header().Get("Content-Type"))
+	var document map[string]interface{}
+	suite.Require().NoError(json.Unmarshal(recorder.Body.Bytes(), &document))
+	suite.Equal("3.0.0", document["openapi"])
+	suite.Contains(document["paths"], "/hospital")
+}
@@ -0,0 +1,178 @@
+package hospital_wl
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/gin-gonic/gin"
+)
+
+type OpenApiValidatorConfig struct {
+	// ValidateResponses enables validation of the responses, violations are only logged.
+	// It is intended for development, as the responses are buffered for the validation.
+	ValidateResponses bool
+}
+
+// NewOpenApiValidator creates the middleware validating path parameters, query parameters,
+// headers and bodies of the requests handled by the routes of handleFunctions against the
+// embedded OpenAPI specification. Invalid requests are rejected before reaching the handlers.
+// Requests of other routes are passed through unchecked.
+func NewOpenApiValidator(handleFunctions ApiHandleFunctions, config OpenApiValidatorConfig) (gin.HandlerFunc, error) {
+	document, err := openApiDocument()
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the OpenAPI specification: %w", err)
+	}
+	if err := document.Validate(openapi3.NewLoader().Context, openapi3.DisableExamplesValidation()); err != nil {
+		return nil, fmt.Errorf("invalid OpenAPI specification: %w", err)
+	}
+
+	// gin patterns are mapped to the operations at startup, so undocumented routes fail fast
+	operations := map[string]*routers.Route{}
+	basePath := serverBasePath(document)
+	for _, route := range getRoutes(handleFunctions) {
+		path := openApiPath(strings.TrimPrefix(route.Pattern, basePath))
+		pathItem := document.Paths.Find(path)
+		if pathItem == nil || pathItem.GetOperation(route.Method) == nil {
+			return nil, fmt.Errorf("route %v %v is not described by the OpenAPI specification", route.Method, route.Pattern)
+		}
+		operations[route.Method+" "+route.Pattern] = &routers.Route{
+			Spec:      document,
+			Path:      path,
+			PathItem:  pathItem,
+			Method:    route.Method,
+			Operation: pathItem.GetOperation(route.Method),
+		}
+	}
+
+	return func(ctx *gin.Context) {
+		route, ok := operations[ctx.Request.Method+" "+ctx.FullPath()]
+		if !ok {
+			ctx.Next()
+			return
+		}
+
+		pathParams := make(map[string]string, len(ctx.Params))
+		for _, param := range ctx.Params {
+			pathParams[param.Key] = param.Value
+		}
+		input := &openapi3filter.RequestValidationInput{
+			Request:    ctx.Request,
+			PathParams: pathParams,
+			Route:      route,
+			Options: &openapi3filter.Options{
+				MultiError: true,
+				// clients may send back the resources as they received them
+				ExcludeReadOnlyValidations: true,
+				AuthenticationFunc:         openapi3filter.NoopAuthenticationFunc,
+			},
+		}
+
+		if requestBody := route.Operation.RequestBody; requestBody != nil && requestBody.Value != nil && ctx.Request.ContentLength != 0 {
+			if requestBody.Value.Content.Get(ctx.GetHeader("Content-Type")) == nil {
+				abortWithProblem(ctx, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, "Unsupported content type "+ctx.ContentType())
+				return
+			}
+		}
+
+		if err := openapi3filter.ValidateRequest(ctx, input); err != nil {
+			problem := newProblem(http.StatusBadRequest, codeValidationFailed, "Request does not conform to the API specification")
+			problem.Errors = requestViolations(err)
+			respondWithProblem(ctx, problem)
+			return
+		}
+
+		if !config.ValidateResponses {
+			ctx.Next()
+			return
+		}
+
+		writer := &recordingWriter{ResponseWriter: ctx.Writer}
+		ctx.Writer = writer
+		ctx.Next()
+
+		responseInput := &openapi3filter.ResponseValidationInput{
+			RequestValidationInput: input,
+			Status:                 writer.Status(),
+			Header:                 writer.Header(),
+			Options: &openapi3filter.Options{
+				MultiError:            true,
+				IncludeResponseStatus: true,
+			},
+		}
+		responseInput.SetBodyBytes(writer.body.Bytes())
+		if err := openapi3filter.ValidateResponse(ctx, responseInput); err != nil {
+			log.Printf("Response %v of %v %v violates the API specification: %v",
+				writer.Status(), ctx.Request.Method, ctx.Request.URL.Path, err)
+		}
+	}, nil
+}
+
+// serverBasePath provides the path of the first server of the specification, which prefixes the routes
+func serverBasePath(document *openapi3.T) string {
+	if len(document.Servers) == 0 {
+		return ""
+	}
+	server, err := url.Parse(document.Servers[0].URL)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSuffix(server.Path, "/")
+}
+
+// openApiPath converts the gin route pattern to the OpenAPI path template
+func openApiPath(pattern string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// requestViolations lists the violations reported by the request validation, parameters
+// are identified by their location and name, for example query.pageSize
+func requestViolations(err error) []FieldError {
+	var violations []FieldError
+	switch err := err.(type) {
+	case openapi3.MultiError:
+		for _, err := range err {
+			violations = append(violations, requestViolations(err)...)
+		}
+	case *openapi3filter.RequestError:
+		collectViolations(err.Err, &violations)
+		if len(violations) == 0 {
+			violations = []FieldError{{Message: err.Error()}}
+		}
+		if err.Parameter != nil {
+			for i := range violations {
+				violations[i].Field = err.Parameter.In + "." + err.Parameter.Name
+			}
+		}
+	default:
+		violations = append(violations, FieldError{Message: err.Error()})
+	}
+	return violations
+}
+
+// recordingWriter keeps a copy of the response body for its validation
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(data string) (int, error) {
+	return w.Write([]byte(data))
+}
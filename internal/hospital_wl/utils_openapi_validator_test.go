@@ -0,0 +1,60 @@
+package hospital_wl
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func (suite *HospitalApiSuite) Test_OpenApiValidator_InvalidParametersRejected() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+
+	recorder := suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries?pageSize=1000&minPerformance=x", "")
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	var response Problem
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	suite.ElementsMatch([]string{"query.pageSize", "query.minPerformance"}, fieldsOf(response.Errors))
+
+	recorder = suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"name": 42}`)
+	suite.Equal(http.StatusBadRequest, recorder.Code)
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &response))
+	suite.Equal([]string{"/name"}, fieldsOf(response.Errors))
+
+	suite.Equal(http.StatusBadRequest, suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"name":`).Code)
+	suite.Equal(http.StatusUnsupportedMediaType,
+		suite.requestWithType(http.MethodPost, "/api/employee-list/hospital-ba/entries", "text/plain", `name=Jozko`).Code)
+	// routes outside of the specification are not validated
+	suite.Equal(http.StatusNotFound, suite.request(http.MethodGet, "/api/unknown?pageSize=1000", "").Code)
+}
+
+func (suite *HospitalApiSuite) Test_OpenApiValidator_ResponseViolationsLogged() {
+	store := Store{Hospitals: suite.dbService, Employees: suite.employeeDbService}
+	handleFunctions := ApiHandleFunctions{
+		AccessLogAPI:            NewAccessLogApi(store, SystemClock, UuidGenerator, log.Default()),
+		HospitalEmployeeListAPI: NewHospitalEmployeeListApi(store, SystemClock, UuidGenerator, log.Default()),
+		HospitalRolesAPI:        NewHospitalRolesApi(store, SystemClock, UuidGenerator, log.Default()),
+		HospitalsAPI:            NewHospitalsApi(store, SystemClock, UuidGenerator, log.Default()),
+	}
+	validator, err := NewOpenApiValidator(handleFunctions, OpenApiValidatorConfig{ValidateResponses: true})
+	suite.Require().NoError(err)
+	router := gin.New()
+	router.Use(validator)
+	router.GET("/api/employee-list/:hospitalId/role", func(ctx *gin.Context) {
+		ctx.JSON(http.StatusOK, []gin.H{{"value": 42}})
+	})
+
+	var output bytes.Buffer
+	log.SetOutput(&output)
+	defer log.SetOutput(os.Stderr)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/employee-list/hospital-ba/role", nil))
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.True(strings.Contains(output.String(), "violates the API specification"), output.String())
+}
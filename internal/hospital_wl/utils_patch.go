@@ -0,0 +1,57 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"errors"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json"
+	jsonPatchContentType  = "application/json-patch+json"
+)
+
+// errUnsupportedPatch reports a patch request with a content type other than the patch formats
+var errUnsupportedPatch = errors.New("unsupported patch media type")
+
+// errPatchTestFailed reports a JSON patch with a test operation the current state does not satisfy
+var errPatchTestFailed = errors.New("patch test operation failed")
+
+// applyPatch applies the patch in the request body to the current state of the resource and
+// decodes the result into patched. The format is selected by the content type, JSON merge patch
+// (RFC 7396) is also assumed for plain application/json. The body is cached by requestBody,
+// so the patch may be applied repeatedly by retried updaters.
+func applyPatch(ctx *gin.Context, current interface{}, patched interface{}) error {
+	contentType := ctx.ContentType()
+	if contentType != mergePatchContentType && contentType != jsonPatchContentType && contentType != gin.MIMEJSON {
+		return errUnsupportedPatch
+	}
+
+	patch, err := requestBody(ctx)
+	if err != nil {
+		return err
+	}
+	original, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	var result []byte
+	if contentType == jsonPatchContentType {
+		var operations jsonpatch.Patch
+		if operations, err = jsonpatch.DecodePatch(patch); err == nil {
+			result, err = operations.Apply(original)
+		}
+		if errors.Is(err, jsonpatch.ErrTestFailed) {
+			return errPatchTestFailed
+		}
+	} else {
+		result, err = jsonpatch.MergePatch(original, patch)
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(result, patched)
+}
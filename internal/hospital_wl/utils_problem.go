@@ -0,0 +1,88 @@
+package hospital_wl
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// problemContentType is the media type of the error responses, see RFC 7807
+const problemContentType = "application/problem+json"
+
+// Codes of the problems, clients rely on them, so they must not change. The list is
+// maintained also in the Problem schema of the specification.
+const (
+	codeInvalidRequest         = "invalid-request"
+	codeValidationFailed       = "validation-failed"
+	codeUnsupportedMediaType   = "unsupported-media-type"
+	codeUnauthorized           = "unauthorized"
+	codeForbidden              = "forbidden"
+	codeHospitalNotFound       = "hospital-not-found"
+	codeEntryNotFound          = "entry-not-found"
+	codePerformanceNotFound    = "performance-not-found"
+	codeRoleNotFound           = "role-not-found"
+	codeAlreadyExists          = "already-exists"
+	codeInvalidRole            = "invalid-role"
+	codeRoleInUse              = "role-in-use"
+	codePreconditionFailed     = "precondition-failed"
+	codeConcurrentModification = "concurrent-modification"
+	codePatchTestFailed        = "patch-test-failed"
+	codeIdempotencyKeyReused   = "idempotency-key-reused"
+	codeRequestInProgress      = "request-in-progress"
+	codeStorageFailure         = "storage-failure"
+	codeInternalError          = "internal-error"
+)
+
+// newProblem describes the failure of the request, the title is the reason phrase of the status
+func newProblem(status int, code string, detail string) *Problem {
+	return &Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: int32(status),
+		Code:   code,
+		Detail: detail,
+	}
+}
+
+// respondWithProblem sends the problem as application/problem+json and aborts the request,
+// the instance is the path of the request unless set by the caller
+func respondWithProblem(ctx *gin.Context, problem *Problem) {
+	if problem.Instance == "" {
+		problem.Instance = ctx.Request.URL.Path
+	}
+	ctx.Header("Content-Type", problemContentType)
+	ctx.AbortWithStatusJSON(int(problem.Status), problem)
+}
+
+func abortWithProblem(ctx *gin.Context, status int, code string, detail string) {
+	respondWithProblem(ctx, newProblem(status, code, detail))
+}
+
+// abortWithStorageFailure reports that the database failed, the cause is only logged,
+// so that the internals of the storage are not disclosed to the clients
+func abortWithStorageFailure(ctx *gin.Context, logger *log.Logger, detail string, err error) {
+	respondWithProblem(ctx, storageFailureProblem(ctx, logger, detail, err))
+}
+
+// storageFailureProblem logs the failure of the database like abortWithStorageFailure, it is used
+// by the updaters, which return the problem instead of responding
+func storageFailureProblem(ctx *gin.Context, logger *log.Logger, detail string, err error) *Problem {
+	logger.Printf("%v %v: %v: %v", ctx.Request.Method, ctx.Request.URL.Path, detail, err)
+	return newProblem(http.StatusBadGateway, codeStorageFailure, detail)
+}
+
+// invalidBodyProblem describes a request body that cannot be decoded
+func invalidBodyProblem(err error) *Problem {
+	return newProblem(http.StatusBadRequest, codeInvalidRequest, "Invalid request body: "+err.Error())
+}
+
+// respond sends the response content produced by an updater or a validation, problems
+// are sent with their media type, resources are shaped for the caller
+func respond(ctx *gin.Context, status int, responseContent interface{}) {
+	if problem, ok := responseContent.(*Problem); ok {
+		respondWithProblem(ctx, problem)
+		return
+	}
+	respondAudited(ctx, status, responseContent)
+}
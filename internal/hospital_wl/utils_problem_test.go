@@ -0,0 +1,33 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+func (suite *HospitalApiSuite) Test_Problems_SentAsProblemJson() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+
+	// problems are produced by the handlers, by updateDocumentFunc and by the validation middleware
+	for _, request := range []struct{ method, path, body, code string }{
+		{http.MethodGet, "/api/hospital/unknown", "", codeHospitalNotFound},
+		{http.MethodPut, "/api/hospital/unknown", `{"name": "Hospital"}`, codeHospitalNotFound},
+		{http.MethodGet, "/api/employee-list/hospital-ba/entries/unknown", "", codeEntryNotFound},
+		{http.MethodGet, "/api/employee-list/hospital-ba/entries?pageSize=1000", "", codeValidationFailed},
+		{http.MethodDelete, "/api/employee-list/hospital-ba/role/unknown", "", codeRoleNotFound},
+	} {
+		recorder := suite.request(request.method, request.path, request.body)
+
+		suite.Equal(problemContentType, recorder.Header().Get("Content-Type"), request.path)
+		var problem Problem
+		suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &problem))
+		suite.Equal(request.code, problem.Code, request.path)
+		suite.Equal(int32(recorder.Code), problem.Status, request.path)
+		suite.Equal(http.StatusText(recorder.Code), problem.Title, request.path)
+		suite.Equal("about:blank", problem.Type, request.path)
+		suite.NotEmpty(problem.Detail, request.path)
+		path, _, _ := strings.Cut(request.path, "?")
+		suite.Equal(path, problem.Instance)
+	}
+}
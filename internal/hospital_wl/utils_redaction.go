@@ -0,0 +1,117 @@
+package hospital_wl
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+// redactedValue replaces the clinical data of the performances in the responses to callers
+// without PermissionReadClinicalData, the fields are required, so they are masked instead of omitted
+const redactedValue = "[redacted]"
+
+// mayReadClinicalData checks the permission of the caller in the hospital of the request
+func mayReadClinicalData(ctx *gin.Context) bool {
+	return authorized(ctx, ctx.Param("hospitalId"), PermissionReadClinicalData)
+}
+
+// redactFor shapes the response content for the caller, the patient names and details of the
+// performances are masked in the hospitals the caller may not read clinical data in. The content
+// is copied, the entity tags are computed from the unmasked resources, so that the callers can
+// still use them in preconditions.
+func redactFor(ctx *gin.Context, content interface{}) interface{} {
+	switch content := content.(type) {
+	case PerformanceEntry:
+		if !mayReadClinicalData(ctx) {
+			return redactPerformance(content)
+		}
+	case []PerformanceEntry:
+		if !mayReadClinicalData(ctx) {
+			return redactPerformances(content)
+		}
+	case EmployeeListEntry:
+		if !mayReadClinicalData(ctx) {
+			return redactEntry(content)
+		}
+	case *EmployeeListEntry:
+		if !mayReadClinicalData(ctx) {
+			redacted := redactEntry(*content)
+			return &redacted
+		}
+	case []EmployeeListEntry:
+		if !mayReadClinicalData(ctx) {
+			return redactEntries(content)
+		}
+	case Hospital:
+		return redactHospital(ctx, content)
+	case *Hospital:
+		redacted := redactHospital(ctx, *content)
+		return &redacted
+	case []HospitalSummary:
+		redacted := make([]HospitalSummary, len(content))
+		for i, summary := range content {
+			if !authorized(ctx, summary.Id, PermissionReadClinicalData) {
+				summary.EmployeeList = redactEntries(summary.EmployeeList)
+			}
+			redacted[i] = summary
+		}
+		return redacted
+	}
+	return content
+}
+
+func redactHospital(ctx *gin.Context, hospital Hospital) Hospital {
+	if !authorized(ctx, hospital.Id, PermissionReadClinicalData) {
+		hospital.EmployeeList = redactEntries(hospital.EmployeeList)
+	}
+	return hospital
+}
+
+func redactEntries(entries []EmployeeListEntry) []EmployeeListEntry {
+	if entries == nil {
+		return nil
+	}
+	redacted := make([]EmployeeListEntry, len(entries))
+	for i, entry := range entries {
+		redacted[i] = redactEntry(entry)
+	}
+	return redacted
+}
+
+func redactEntry(entry EmployeeListEntry) EmployeeListEntry {
+	entry.Performances = redactPerformances(entry.Performances)
+	return entry
+}
+
+func redactPerformances(performances []PerformanceEntry) []PerformanceEntry {
+	if performances == nil {
+		return nil
+	}
+	redacted := make([]PerformanceEntry, len(performances))
+	for i, performance := range performances {
+		redacted[i] = redactPerformance(performance)
+	}
+	return redacted
+}
+
+func redactPerformance(performance PerformanceEntry) PerformanceEntry {
+	performance.PatientName = redactedValue
+	performance.Details = redactedValue
+	return performance
+}
+
+// preserveClinicalData keeps the stored patient names and details of the performances, which
+// callers without PermissionReadClinicalData receive masked and so must not change. New
+// performances are taken as sent.
+func preserveClinicalData(ctx *gin.Context, stored []PerformanceEntry, performances []PerformanceEntry) {
+	if mayReadClinicalData(ctx) {
+		return
+	}
+	for i := range performances {
+		for _, current := range stored {
+			if current.Id == performances[i].Id {
+				performances[i].PatientName = current.PatientName
+				performances[i].Details = current.Details
+				break
+			}
+		}
+	}
+}
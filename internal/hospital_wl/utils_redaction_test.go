@@ -0,0 +1,89 @@
+package hospital_wl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+)
+
+func (suite *HospitalApiSuite) createEntryWithPerformance() {
+	suite.request(http.MethodPost, "/api/hospital", `{"id": "hospital-ba", "name": "Hospital BA"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries", `{"id": "entry-1", "name": "Jozko"}`)
+	suite.request(http.MethodPost, "/api/employee-list/hospital-ba/entries/entry-1/performances",
+		`{"id": "perf-1", "activityType": "surgery", "patientName": "John Doe", "activityDate": "2023-05-01", "details": "Appendectomy"}`)
+}
+
+func rosterManager() *Principal {
+	return coordinator(map[string]interface{}{"hospital-ba": "roster-manager"})
+}
+
+func (suite *HospitalApiSuite) Test_Redaction_ClinicalDataMaskedInAllResponses() {
+	suite.createEntryWithPerformance()
+	suite.principal = rosterManager()
+
+	responses := map[string]string{
+		"list":         "/api/employee-list/hospital-ba/entries",
+		"entry":        "/api/employee-list/hospital-ba/entries/entry-1",
+		"performances": "/api/employee-list/hospital-ba/entries/entry-1/performances",
+		"performance":  "/api/employee-list/hospital-ba/entries/entry-1/performances/perf-1",
+		"hospitals":    "/api/hospital?expand=employeeList",
+	}
+	for name, path := range responses {
+		recorder := suite.request(http.MethodGet, path, "")
+		suite.Equal(http.StatusOK, recorder.Code, name)
+		suite.Contains(recorder.Body.String(), redactedValue, name)
+		suite.NotContains(recorder.Body.String(), "John Doe", name)
+		suite.NotContains(recorder.Body.String(), "Appendectomy", name)
+	}
+
+	suite.principal = coordinator(map[string]interface{}{"hospital-ba": "physician"})
+	for name, path := range responses {
+		recorder := suite.request(http.MethodGet, path, "")
+		suite.Contains(recorder.Body.String(), "John Doe", name)
+	}
+}
+
+func (suite *HospitalApiSuite) Test_Redaction_ETagNotConfirmingGuesses() {
+	suite.createEntryWithPerformance()
+	path := "/api/employee-list/hospital-ba/entries/entry-1/performances/perf-1"
+	suite.principal = coordinator(map[string]interface{}{"hospital-ba": "physician"})
+	var performance PerformanceEntry
+	suite.NoError(json.Unmarshal(suite.request(http.MethodGet, path, "").Body.Bytes(), &performance))
+
+	suite.principal = rosterManager()
+	etag := suite.request(http.MethodGet, path, "").Header().Get("ETag")
+	suite.NotEmpty(etag)
+	// a correct guess of the masked values hashes to an unkeyed tag
+	guess, _ := json.Marshal(performance)
+	sum := sha256.Sum256(guess)
+	suite.NotEqual(`"`+hex.EncodeToString(sum[:16])+`"`, etag)
+}
+
+func (suite *HospitalApiSuite) Test_Redaction_MaskedValuesNotStored() {
+	suite.createEntryWithPerformance()
+	suite.principal = rosterManager()
+
+	recorder := suite.request(http.MethodGet, "/api/employee-list/hospital-ba/entries/entry-1", "")
+	var entry EmployeeListEntry
+	suite.NoError(json.Unmarshal(recorder.Body.Bytes(), &entry))
+	entry.Name = "Jozko Mrkvicka"
+	body, _ := json.Marshal(entry)
+	recorder = suite.requestWithHeader(http.MethodPut, "/api/employee-list/hospital-ba/entries/entry-1",
+		http.Header{"Content-Type": {"application/json"}, "If-Match": {recorder.Header().Get("ETag")}}, string(body))
+	suite.Equal(http.StatusOK, recorder.Code)
+
+	recorder = suite.request(http.MethodPut, "/api/employee-list/hospital-ba/entries/entry-1/performances/perf-1",
+		`{"id": "perf-1", "activityType": "checkup", "patientName": "[redacted]", "activityDate": "2023-05-01", "details": "[redacted]"}`)
+	suite.Equal(http.StatusOK, recorder.Code)
+	suite.Contains(recorder.Body.String(), redactedValue)
+
+	document, err := suite.employeeDbService.FindDocument(suite.T().Context(), "hospital-ba/entry-1")
+	suite.Require().NoError(err)
+	suite.Equal("Jozko Mrkvicka", document.Entry.Name)
+	performances := suite.storedPerformances("hospital-ba/entry-1")
+	suite.Require().Len(performances, 1)
+	suite.Equal("checkup", performances[0].ActivityType)
+	suite.Equal("John Doe", performances[0].PatientName)
+	suite.Equal("Appendectomy", performances[0].Details)
+}
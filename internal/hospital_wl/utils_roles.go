@@ -0,0 +1,121 @@
+package hospital_wl
+
+import (
+	"net/http"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/xkello/ambulance-otapi/internal/db_service"
+)
+
+// findRole looks up the predefined role by its code
+func findRole(roles []Role, code string) (Role, bool) {
+	index := slices.IndexFunc(roles, func(role Role) bool { return role.Code == code })
+	if index < 0 {
+		return Role{}, false
+	}
+	return roles[index], true
+}
+
+// resolveRole replaces the role of an employee by the predefined role with the same code.
+// Employees without any role are allowed, a role given only by its value is not.
+func resolveRole(roles []Role, role Role) (Role, bool) {
+	if role.Code == "" && role.Value == "" {
+		return role, true
+	}
+	return findRole(roles, role.Code)
+}
+
+// validateRoles checks that every predefined role has a unique code and a value
+func validateRoles(roles []Role) (string, bool) {
+	codes := make(map[string]bool, len(roles))
+	for _, role := range roles {
+		if role.Code == "" || role.Value == "" {
+			return "Role code and value are required", false
+		}
+		if codes[role.Code] {
+			return "Duplicate role code " + role.Code, false
+		}
+		codes[role.Code] = true
+	}
+	return "", true
+}
+
+// rolesOf provides the predefined roles of the hospital as they are listed by GetRoles
+func rolesOf(hospital *Hospital) []Role {
+	if hospital.PredefinedRoles == nil {
+		return []Role{}
+	}
+	return hospital.PredefinedRoles
+}
+
+// findHospitalRoles loads the predefined roles of the hospital, responding with an error
+// if the hospital cannot be loaded
+func (d *apiDependencies) findHospitalRoles(ctx *gin.Context, hospitalId string) ([]Role, bool) {
+	hospital, err := d.store.Hospitals.FindDocumentWith(ctx, hospitalId, db_service.ReadOptions{
+		Fields: []string{"predefinedRoles"},
+	})
+	switch err {
+	case nil:
+		return rolesOf(hospital), true
+	case db_service.ErrNotFound:
+		abortWithProblem(ctx, http.StatusNotFound, codeHospitalNotFound, "Hospital not found")
+	default:
+		abortWithStorageFailure(ctx, d.logger, "Failed to load hospital from database", err)
+	}
+	return nil, false
+}
+
+// roleUsageQuery selects employees of the hospital having the role
+func roleUsageQuery(hospitalId string, code string) db_service.Query {
+	query := hospitalEmployeesQuery(hospitalId)
+	query.Equal["entry.role.code"] = code
+	return query
+}
+
+// reassignRole sets the role of all employees of the hospital having the role with the given code
+func reassignRole(
+	ctx *gin.Context,
+	db db_service.DbService[EmployeeDocument],
+	hospitalId string,
+	code string,
+	role Role,
+) error {
+	ids, err := roleUsers(ctx, db, hospitalId, code)
+	if err != nil || len(ids) == 0 {
+		return err
+	}
+
+	return db.UpdateDocuments(ctx, ids, func(documents []*EmployeeDocument) ([]*EmployeeDocument, error) {
+		for _, document := range documents {
+			// documents changed since the lookup keep their current role
+			if document != nil && document.Entry.Role.Code == code {
+				document.Entry.Role = role
+				document.Version++
+			}
+		}
+		return documents, nil
+	})
+}
+
+// roleUsers lists the document ids of the employees of the hospital having the role
+func roleUsers(
+	ctx *gin.Context,
+	db db_service.DbService[EmployeeDocument],
+	hospitalId string,
+	code string,
+) ([]string, error) {
+	query := roleUsageQuery(hospitalId, code)
+	query.Fields = []string{"id"}
+	documents, err := db.FindDocuments(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(documents))
+	for _, document := range documents {
+		ids = append(ids, document.Id)
+	}
+	return ids, nil
+}
@@ -0,0 +1,143 @@
+package hospital_wl
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/getkin/kin-openapi/openapi3"
+
+	"github.com/xkello/ambulance-otapi/api"
+)
+
+// openApiDocument loads the embedded OpenAPI specification. Payloads and requests are validated
+// against it, so the constraints are maintained only in the specification.
+var openApiDocument = sync.OnceValues(func() (*openapi3.T, error) {
+	// activity times accept dates and legacy dates on input, see parseActivityTime
+	openapi3.DefineStringFormatCallback("date-time", func(text string) error {
+		if text == "" {
+			return errors.New("empty activity time")
+		}
+		_, err := parseActivityTime(text)
+		return err
+	})
+	return openapi3.NewLoader().LoadFromData(api.OpenApiSpec())
+})
+
+// validatePayload checks the payload against the named schema of the specification. If it is
+// not valid, the problem lists every violated constraint, field paths are prefixed by prefix.
+func validatePayload(schemaName string, prefix string, payload interface{}) *Problem {
+	return validationProblem(schemaName, prefix, func(schema *openapi3.Schema) *openapi3.Schema {
+		return schema
+	}, payload)
+}
+
+// validatePayloadItems checks every item of the payload list against the named schema,
+// field paths of the violations start with the index of the item
+func validatePayloadItems(schemaName string, prefix string, payload interface{}) *Problem {
+	return validationProblem(schemaName, prefix, func(schema *openapi3.Schema) *openapi3.Schema {
+		return openapi3.NewArraySchema().WithItems(schema)
+	}, payload)
+}
+
+// validationProblem describes the violations of the schema, nil means the payload is valid
+func validationProblem(
+	schemaName string,
+	prefix string,
+	schemaOf func(schema *openapi3.Schema) *openapi3.Schema,
+	payload interface{},
+) *Problem {
+	violations, err := payloadViolations(schemaName, schemaOf, payload)
+	if err != nil {
+		log.Printf("Failed to validate payload against the %v schema: %v", schemaName, err)
+		return newProblem(http.StatusInternalServerError, codeInternalError, "Failed to validate request body")
+	}
+	if len(violations) == 0 {
+		return nil
+	}
+
+	problem := newProblem(http.StatusBadRequest, codeValidationFailed, "Request body violates the "+schemaName+" schema")
+	problem.Errors = prefixed(prefix, violations)
+	return problem
+}
+
+// payloadViolations lists the constraints of the named schema violated by the payload
+func payloadViolations(
+	schemaName string,
+	schemaOf func(schema *openapi3.Schema) *openapi3.Schema,
+	payload interface{},
+) ([]FieldError, error) {
+	document, err := openApiDocument()
+	if err != nil {
+		return nil, err
+	}
+	schema, ok := document.Components.Schemas[schemaName]
+	if !ok || schema.Value == nil {
+		return nil, errors.New("schema " + schemaName + " is not defined in the specification")
+	}
+
+	// the schema is evaluated against the generic JSON form, as the payload is sent by clients
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	var violations []FieldError
+	collectViolations(schemaOf(schema.Value).VisitJSON(value, openapi3.MultiErrors()), &violations)
+	return append(violations, constraintViolations(payload)...), nil
+}
+
+// constraintViolations checks the constraints relating several fields, which the specification cannot express
+func constraintViolations(payload interface{}) []FieldError {
+	var violations []FieldError
+	switch payload := payload.(type) {
+	case PerformanceEntry:
+		if payload.ActivityEnd != nil && !payload.ActivityDate.IsZero() && payload.ActivityEnd.Before(payload.ActivityDate.Time) {
+			violations = append(violations, FieldError{Field: "/activityEnd", Message: "activity must not end before it starts"})
+		}
+	case EmployeeListEntry:
+		for i, performance := range payload.Performances {
+			violations = append(violations, prefixed("/performances/"+strconv.Itoa(i), constraintViolations(performance))...)
+		}
+	case []EmployeeListEntry:
+		for i, entry := range payload {
+			violations = append(violations, prefixed("/"+strconv.Itoa(i), constraintViolations(entry))...)
+		}
+	}
+	return violations
+}
+
+func prefixed(prefix string, violations []FieldError) []FieldError {
+	for i := range violations {
+		violations[i].Field = prefix + violations[i].Field
+	}
+	return violations
+}
+
+func collectViolations(err error, violations *[]FieldError) {
+	var multiError openapi3.MultiError
+	var schemaError *openapi3.SchemaError
+	switch {
+	case err == nil:
+	case errors.As(err, &multiError):
+		for _, err := range multiError {
+			collectViolations(err, violations)
+		}
+	case errors.As(err, &schemaError):
+		field := ""
+		if pointer := schemaError.JSONPointer(); len(pointer) > 0 {
+			field = "/" + strings.Join(pointer, "/")
+		}
+		*violations = append(*violations, FieldError{Field: field, Message: schemaError.Reason})
+	default:
+		*violations = append(*violations, FieldError{Message: err.Error()})
+	}
+}
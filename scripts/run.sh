@@ -8,6 +8,8 @@ PROJECT_ROOT="$( cd "$( dirname "${BASH_SOURCE[0]}" )/.." && pwd )"
 # Set environment variables
 export HOSPITAL_API_ENVIRONMENT="Development"
 export HOSPITAL_API_PORT="8080"
+# requests without bearer token are handled as the developer, never set in production
+export HOSPITAL_API_AUTH_DEVELOPMENT_BYPASS="true"
 
 export HOSPITAL_API_MONGODB_USERNAME="root"
 export HOSPITAL_API_MONGODB_PASSWORD="root"
@@ -35,6 +37,12 @@ case "$command" in
     # When this script exits (even due to an error), the trap will execute
     # and shut down the MongoDB containers.
     ;;
+  memory)
+    # Run the API service with in-memory storage seeded with sample data, no MongoDB needed.
+    export HOSPITAL_API_STORAGE="memory"
+    export HOSPITAL_API_MEMORY_SEED_FILE="${PROJECT_ROOT}/deployments/seed/hospitals.json"
+    go run "${PROJECT_ROOT}/cmd/hospital-api-service"
+    ;;
   openapi)
     docker run --rm -ti -v "${PROJECT_ROOT}":/local openapitools/openapi-generator-cli generate -c /local/scripts/generator-cfg.yaml
     ;;